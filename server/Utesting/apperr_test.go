@@ -0,0 +1,93 @@
+package testing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"server/internal/apperr"
+	"server/internal/message"
+)
+
+// missingRoomRepository reports every room as missing, like the Postgres repository
+type missingRoomRepository struct {
+	*MockMessageRepository
+}
+
+func (missingRoomRepository) GetRoomByID(ctx context.Context, id string) (*message.Room, error) {
+	return nil, message.ErrRoomNotFound
+}
+
+func TestErrorMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	render := func(handler gin.HandlerFunc) (int, map[string]string) {
+		r := gin.New()
+		r.Use(apperr.Middleware())
+		r.GET("/", handler)
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+		var body map[string]string
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+		return w.Code, body
+	}
+
+	t.Run("renders kinds with their status and code", func(t *testing.T) {
+		cases := []struct {
+			err    *apperr.Error
+			status int
+		}{
+			{apperr.NotFound("thing_not_found", "thing not found"), http.StatusNotFound},
+			{apperr.Conflict("thing_exists", "thing exists"), http.StatusConflict},
+			{apperr.Unauthorized("no_session", "no session"), http.StatusUnauthorized},
+			{apperr.Forbidden("forbidden", "forbidden"), http.StatusForbidden},
+			{apperr.Validation("invalid_request", "bad input"), http.StatusBadRequest},
+			{apperr.Unavailable("overloaded", "busy"), http.StatusServiceUnavailable},
+		}
+
+		for _, tc := range cases {
+			status, body := render(func(c *gin.Context) { c.Error(tc.err) })
+			assert.Equal(t, tc.status, status, tc.err.Code)
+			assert.Equal(t, tc.err.Code, body["code"])
+			assert.Equal(t, tc.err.Message, body["error"])
+		}
+	})
+
+	t.Run("hides the cause of internal errors", func(t *testing.T) {
+		cause := errors.New("pq: password authentication failed")
+		status, body := render(func(c *gin.Context) {
+			c.Error(apperr.Internal(cause, "rooms_unavailable", "failed to retrieve rooms"))
+		})
+
+		assert.Equal(t, http.StatusInternalServerError, status)
+		assert.Equal(t, map[string]string{"error": "failed to retrieve rooms", "code": "rooms_unavailable"}, body)
+	})
+
+	t.Run("keeps the kind of wrapped errors", func(t *testing.T) {
+		err := apperr.Internal(message.ErrRoomExists.Wrap(errors.New("duplicate key")), "room_create_failed", "failed")
+		assert.ErrorIs(t, err, message.ErrRoomExists)
+		assert.Equal(t, apperr.KindConflict, apperr.KindOf(err))
+	})
+
+	t.Run("missing rooms are 404", func(t *testing.T) {
+		svc := message.NewService(missingRoomRepository{NewMockMessageRepository()}, nil)
+		r := gin.New()
+		r.Use(apperr.Middleware())
+		r.GET("/api/rooms/:roomId", message.NewHandler(svc).GetRoom)
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/rooms/nope", nil))
+
+		assert.Equal(t, http.StatusNotFound, w.Code)
+		assert.JSONEq(t, `{"error": "room not found", "code": "room_not_found"}`, w.Body.String())
+	})
+}
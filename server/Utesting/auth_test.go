@@ -9,81 +9,18 @@ import (
 	"testing"
 
 	"github.com/gin-gonic/gin"
-	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
 
+	"server/internal/apperr"
 	"server/internal/auth"
 )
 
-// MockAuthRepository implements auth.Repository interface for testing
-type MockAuthRepository struct {
-	users    map[string]*auth.User
-	sessions map[string]*auth.Session
-}
-
-func NewMockAuthRepository() *MockAuthRepository {
-	return &MockAuthRepository{
-		users:    make(map[string]*auth.User),
-		sessions: make(map[string]*auth.Session),
-	}
-}
-
-func (m *MockAuthRepository) UpsertUser(ctx context.Context, user *auth.User) (*auth.User, error) {
-	if user.ID == "" {
-		user.ID = uuid.New().String()
-	}
-	m.users[user.Email] = user
-	return user, nil
-}
-
-func (m *MockAuthRepository) GetUserByID(ctx context.Context, id string) (*auth.User, error) {
-	for _, user := range m.users {
-		if user.ID == id {
-			return user, nil
-		}
-	}
-	return nil, nil
-}
-
-func (m *MockAuthRepository) GetUserByEmail(ctx context.Context, email string) (*auth.User, error) {
-	if user, exists := m.users[email]; exists {
-		return user, nil
-	}
-	return nil, nil
-}
-
-func (m *MockAuthRepository) CreateSession(ctx context.Context, session *auth.Session) (*auth.Session, error) {
-	m.sessions[session.Token] = session
-	return session, nil
-}
-
-func (m *MockAuthRepository) GetSessionByToken(ctx context.Context, token string) (*auth.Session, error) {
-	if session, exists := m.sessions[token]; exists {
-		return session, nil
-	}
-	return nil, nil
-}
-
-func (m *MockAuthRepository) DeleteSession(ctx context.Context, token string) error {
-	delete(m.sessions, token)
-	return nil
-}
-
-func (m *MockAuthRepository) GetUserByGoogleID(ctx context.Context, googleID string) (*auth.User, error) {
-	for _, user := range m.users {
-		if user.GoogleID == googleID {
-			return user, nil
-		}
-	}
-	return nil, nil
-}
-
 func TestSignup(t *testing.T) {
 	gin.SetMode(gin.TestMode)
 	r := gin.New()
-	mockRepo := NewMockAuthRepository()
-	authService := auth.NewService(mockRepo)
-	authHandler := auth.NewHandler(authService)
+	r.Use(apperr.Middleware())
+	authService := auth.NewService(auth.NewMemoryRepository())
+	authHandler := auth.NewHandler(authService, auth.OAuthConfig{ClientOrigin: "http://localhost:3000"})
 	r.POST("/signup", authHandler.Signup)
 
 	tests := []struct {
@@ -107,7 +44,7 @@ func TestSignup(t *testing.T) {
 				Email:    "test@example.com",
 				Password: "testpass123",
 			},
-			wantStatus: http.StatusBadRequest,
+			wantStatus: http.StatusConflict,
 		},
 	}
 
@@ -123,3 +60,55 @@ func TestSignup(t *testing.T) {
 		})
 	}
 }
+
+// unreachableAuthRepository fails every email lookup, like a database that is down
+type unreachableAuthRepository struct {
+	*auth.MemoryRepository
+}
+
+func (unreachableAuthRepository) GetUserByEmail(ctx context.Context, email string) (*auth.User, error) {
+	return nil, errDatabaseDown
+}
+
+func TestLogin(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	login := func(repo auth.Repository, payload auth.LoginRequest) int {
+		r := gin.New()
+		r.Use(apperr.Middleware())
+		authHandler := auth.NewHandler(auth.NewService(repo), auth.OAuthConfig{ClientOrigin: "http://localhost:3000"})
+		r.POST("/login", authHandler.Login)
+
+		jsonData, _ := json.Marshal(payload)
+		req := httptest.NewRequest("POST", "/login", bytes.NewBuffer(jsonData))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	repo := auth.NewMemoryRepository()
+	_, err := auth.NewService(repo).Signup(context.Background(), &auth.SignupRequest{
+		Name:     "Test User",
+		Email:    "test@example.com",
+		Password: "testpass123",
+	})
+	assert.NoError(t, err)
+
+	t.Run("valid credentials", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, login(repo, auth.LoginRequest{Email: "test@example.com", Password: "testpass123"}))
+	})
+
+	t.Run("wrong password is unauthorized", func(t *testing.T) {
+		assert.Equal(t, http.StatusUnauthorized, login(repo, auth.LoginRequest{Email: "test@example.com", Password: "wrongpass"}))
+	})
+
+	t.Run("unknown email is unauthorized", func(t *testing.T) {
+		assert.Equal(t, http.StatusUnauthorized, login(repo, auth.LoginRequest{Email: "nobody@example.com", Password: "testpass123"}))
+	})
+
+	t.Run("repository failures are server errors", func(t *testing.T) {
+		failing := unreachableAuthRepository{auth.NewMemoryRepository()}
+		assert.Equal(t, http.StatusInternalServerError, login(failing, auth.LoginRequest{Email: "test@example.com", Password: "testpass123"}))
+	})
+}
@@ -0,0 +1,349 @@
+package testing
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/sony/gobreaker"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"server/internal/message"
+)
+
+// MockMessageRepository is an in-memory message repository that counts
+// history queries
+type MockMessageRepository struct {
+	*message.MemoryRepository
+	historyCalls int32
+}
+
+func NewMockMessageRepository() *MockMessageRepository {
+	return &MockMessageRepository{MemoryRepository: message.NewMemoryRepository()}
+}
+
+func (m *MockMessageRepository) GetMessagesByRoom(ctx context.Context, roomID string, limit, offset int) ([]*message.Message, error) {
+	atomic.AddInt32(&m.historyCalls, 1)
+	return m.MemoryRepository.GetMessagesByRoom(ctx, roomID, limit, offset)
+}
+
+func (m *MockMessageRepository) calls() int {
+	return int(atomic.LoadInt32(&m.historyCalls))
+}
+
+func TestRoomMessageWindow(t *testing.T) {
+	caches := map[string]func(t *testing.T) message.Cache{
+		"redis": func(t *testing.T) message.Cache {
+			return message.NewRedisCache(miniredis.RunT(t).Addr(), "", 0)
+		},
+		"memory": func(t *testing.T) message.Cache {
+			return message.NewMemoryCache(100)
+		},
+	}
+
+	for name, newCache := range caches {
+		t.Run(name, func(t *testing.T) {
+			testRoomMessageWindow(t, newCache(t))
+		})
+	}
+}
+
+func testRoomMessageWindow(t *testing.T, cache message.Cache) {
+	repo := NewMockMessageRepository()
+	svc := message.NewService(repo, cache)
+	ctx := context.Background()
+
+	base := time.Now().Add(-time.Hour)
+	for i := 0; i < 5; i++ {
+		err := repo.SaveMessage(ctx, &message.Message{
+			ID:        fmt.Sprintf("old-%d", i),
+			RoomID:    "room",
+			Content:   fmt.Sprintf("old %d", i),
+			Timestamp: base.Add(time.Duration(i) * time.Second),
+		})
+		require.NoError(t, err)
+	}
+
+	t.Run("pages respect limit and offset", func(t *testing.T) {
+		first, err := svc.GetMessagesByRoom(ctx, "room", 2, 0)
+		require.NoError(t, err)
+		second, err := svc.GetMessagesByRoom(ctx, "room", 2, 2)
+		require.NoError(t, err)
+
+		assert.Equal(t, []string{"old 4", "old 3"}, contents(first))
+		assert.Equal(t, []string{"old 2", "old 1"}, contents(second))
+		assert.Equal(t, 1, repo.calls(), "second page should be served from the window")
+	})
+
+	t.Run("saved messages are appended to the window", func(t *testing.T) {
+		require.NoError(t, svc.SaveMessage(ctx, &message.Message{RoomID: "room", Content: "new"}))
+
+		latest, err := svc.GetMessagesByRoom(ctx, "room", 2, 0)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"new", "old 4"}, contents(latest))
+		assert.Equal(t, 1, repo.calls())
+	})
+
+	t.Run("pages beyond the history are empty", func(t *testing.T) {
+		page, err := svc.GetMessagesByRoom(ctx, "room", 10, 50)
+		require.NoError(t, err)
+		assert.Empty(t, page)
+		assert.Equal(t, 1, repo.calls())
+	})
+}
+
+func TestMemoryCache(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("evicts least recently used entries", func(t *testing.T) {
+		cache := message.NewMemoryCache(2)
+		require.NoError(t, cache.CacheMessage(ctx, &message.Message{ID: "a"}))
+		require.NoError(t, cache.CacheMessage(ctx, &message.Message{ID: "b"}))
+
+		_, err := cache.GetCachedMessage(ctx, "a")
+		require.NoError(t, err)
+
+		require.NoError(t, cache.CacheMessage(ctx, &message.Message{ID: "c"}))
+		assert.Equal(t, 2, cache.Len())
+
+		_, err = cache.GetCachedMessage(ctx, "b")
+		assert.ErrorIs(t, err, message.ErrCacheMiss)
+		_, err = cache.GetCachedMessage(ctx, "a")
+		assert.NoError(t, err)
+	})
+
+	t.Run("returns copies", func(t *testing.T) {
+		cache := message.NewMemoryCache(10)
+		room := &message.Room{ID: "room", Name: "original"}
+		require.NoError(t, cache.CacheRoom(ctx, room))
+		room.Name = "mutated"
+
+		cached, err := cache.GetCachedRoom(ctx, "room")
+		require.NoError(t, err)
+		assert.Equal(t, "original", cached.Name)
+	})
+}
+
+func TestServiceWithoutCache(t *testing.T) {
+	ctx := context.Background()
+	repo := NewMockMessageRepository()
+	svc := message.NewService(repo, nil)
+
+	require.NoError(t, svc.SaveMessage(ctx, &message.Message{RoomID: "room", Content: "hello"}))
+
+	messages, err := svc.GetMessagesByRoom(ctx, "room", 10, 0)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"hello"}, contents(messages))
+
+	_, err = svc.GetUserSession(ctx, "user")
+	assert.ErrorIs(t, err, message.ErrCacheMiss)
+}
+
+// slowRoomRepository delays room list queries and counts them
+type slowRoomRepository struct {
+	*MockMessageRepository
+	delay time.Duration
+	calls int32
+}
+
+func (r *slowRoomRepository) GetRooms(ctx context.Context) ([]*message.Room, error) {
+	atomic.AddInt32(&r.calls, 1)
+	select {
+	case <-time.After(r.delay):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	return r.MockMessageRepository.GetRooms(ctx)
+}
+
+func TestRoomListStampedeProtection(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("concurrent misses share one query", func(t *testing.T) {
+		repo := &slowRoomRepository{MockMessageRepository: NewMockMessageRepository(), delay: 50 * time.Millisecond}
+		require.NoError(t, repo.CreateRoom(ctx, &message.Room{ID: "room", Name: "room"}))
+		svc := message.NewService(repo, message.NewMemoryCache(100))
+
+		var wg sync.WaitGroup
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				rooms, err := svc.GetRooms(ctx)
+				assert.NoError(t, err)
+				assert.Len(t, rooms, 1)
+			}()
+		}
+		wg.Wait()
+
+		assert.Equal(t, int32(1), atomic.LoadInt32(&repo.calls))
+	})
+
+	t.Run("a caller giving up does not fail the others", func(t *testing.T) {
+		repo := &slowRoomRepository{MockMessageRepository: NewMockMessageRepository(), delay: 50 * time.Millisecond}
+		require.NoError(t, repo.CreateRoom(ctx, &message.Room{ID: "room", Name: "room"}))
+		svc := message.NewService(repo, message.NewMemoryCache(100))
+
+		leaderCtx, cancel := context.WithCancel(ctx)
+		leader := make(chan error, 1)
+		go func() {
+			_, err := svc.GetRooms(leaderCtx)
+			leader <- err
+		}()
+		require.Eventually(t, func() bool { return atomic.LoadInt32(&repo.calls) == 1 }, time.Second, time.Millisecond)
+
+		var wg sync.WaitGroup
+		for i := 0; i < 5; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				rooms, err := svc.GetRooms(ctx)
+				assert.NoError(t, err)
+				assert.Len(t, rooms, 1)
+			}()
+		}
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+
+		assert.ErrorIs(t, <-leader, context.Canceled)
+		wg.Wait()
+		assert.Equal(t, int32(1), atomic.LoadInt32(&repo.calls))
+	})
+
+	t.Run("stale list is served while it is refreshed", func(t *testing.T) {
+		repo := &slowRoomRepository{MockMessageRepository: NewMockMessageRepository(), delay: 50 * time.Millisecond}
+		require.NoError(t, repo.CreateRoom(ctx, &message.Room{ID: "fresh", Name: "fresh"}))
+		cache := message.NewMemoryCache(100)
+		require.NoError(t, cache.CacheRoomList(ctx, []*message.Room{{ID: "stale", Name: "stale"}}))
+		svc := message.NewService(repo, cache)
+
+		// The service has never loaded this list itself, so it counts as stale
+		start := time.Now()
+		rooms, err := svc.GetRooms(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, "stale", rooms[0].ID)
+		assert.Less(t, time.Since(start), repo.delay, "stale list should not wait for the database")
+
+		assert.Eventually(t, func() bool {
+			cached, err := cache.GetCachedRoomList(ctx)
+			return err == nil && cached[0].ID == "fresh"
+		}, time.Second, 10*time.Millisecond)
+
+		rooms, err = svc.GetRooms(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, "fresh", rooms[0].ID)
+		assert.Equal(t, int32(1), atomic.LoadInt32(&repo.calls), "a fresh list should not be refreshed again")
+	})
+}
+
+// hangingCache blocks every room list read until the caller gives up
+type hangingCache struct {
+	message.Cache
+	reads int32
+}
+
+func (c *hangingCache) GetCachedRoomList(ctx context.Context) ([]*message.Room, error) {
+	atomic.AddInt32(&c.reads, 1)
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestResilientCacheFallsBackToDatabase(t *testing.T) {
+	ctx := context.Background()
+	repo := NewMockMessageRepository()
+	require.NoError(t, repo.CreateRoom(ctx, &message.Room{ID: "room", Name: "room"}))
+
+	hung := &hangingCache{Cache: message.NewNoopCache()}
+	cache := message.NewResilientCache(hung, message.CircuitBreakerConfig{
+		Name:    "test",
+		Timeout: time.Minute,
+		ReadyToTrip: func(counts gobreaker.Counts) bool {
+			return counts.TotalFailures >= 2
+		},
+	}, 20*time.Millisecond)
+	svc := message.NewService(repo, cache)
+
+	for i := 0; i < 2; i++ {
+		start := time.Now()
+		rooms, err := svc.GetRooms(ctx)
+		require.NoError(t, err)
+		assert.Len(t, rooms, 1)
+		assert.Less(t, time.Since(start), time.Second, "a hung cache should time out")
+	}
+
+	assert.True(t, cache.Degraded())
+	assert.Equal(t, gobreaker.StateOpen, cache.BreakerState())
+
+	rooms, err := svc.GetRooms(ctx)
+	require.NoError(t, err)
+	assert.Len(t, rooms, 1)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&hung.reads), "an open breaker should skip the cache")
+}
+
+func TestResilientCacheMissesDoNotTrip(t *testing.T) {
+	cache := message.NewResilientCache(message.NewRedisCache(miniredis.RunT(t).Addr(), "", 0), message.CircuitBreakerConfig{
+		Name: "test",
+		ReadyToTrip: func(counts gobreaker.Counts) bool {
+			return counts.ConsecutiveFailures >= 1
+		},
+	}, 0)
+
+	_, err := cache.GetCachedRoom(context.Background(), "missing")
+	assert.Error(t, err)
+	assert.False(t, cache.Degraded())
+}
+
+func contents(messages []*message.Message) []string {
+	out := make([]string, len(messages))
+	for i, msg := range messages {
+		out[i] = msg.Content
+	}
+	return out
+}
+
+func TestTieredCacheInvalidation(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	addr := miniredis.RunT(t).Addr()
+	newReplica := func() (*message.TieredCache, message.Service, *MockMessageRepository) {
+		cache := message.NewTieredCache(
+			message.NewMemoryCacheWithTTL(100, time.Minute),
+			message.NewRedisCache(addr, "", 0),
+		)
+		require.NoError(t, cache.Start(ctx))
+		repo := NewMockMessageRepository()
+		return cache, message.NewService(repo, cache), repo
+	}
+
+	cacheA, svcA, repoA := newReplica()
+	cacheB, svcB, repoB := newReplica()
+
+	require.NoError(t, repoB.CreateRoom(ctx, &message.Room{ID: "room", Name: "original"}))
+
+	_, err := svcB.GetRoomByID(ctx, "room")
+	require.NoError(t, err)
+	cached, err := svcB.GetRoomByID(ctx, "room")
+	require.NoError(t, err)
+	assert.Equal(t, "original", cached.Name)
+	assert.Equal(t, uint64(1), cacheB.Stats().L1.Hits, "second read should be served from L1")
+
+	// Replica A sees the renamed room in the database and refreshes the cache
+	require.NoError(t, repoA.CreateRoom(ctx, &message.Room{ID: "room", Name: "renamed"}))
+	require.NoError(t, svcA.UpdateRoomActivity(ctx, "room"))
+
+	assert.Eventually(t, func() bool {
+		cached, err := svcB.GetRoomByID(ctx, "room")
+		return err == nil && cached.Name == "renamed"
+	}, time.Second, 10*time.Millisecond, "replica B should drop its stale L1 entry")
+
+	stats := cacheB.Stats()
+	assert.GreaterOrEqual(t, stats.L1.Misses, uint64(2))
+	assert.GreaterOrEqual(t, stats.L2.Hits, uint64(1))
+	assert.Zero(t, cacheA.Stats().L1.Misses, "writes should not count as lookups")
+}
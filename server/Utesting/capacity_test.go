@@ -0,0 +1,79 @@
+package testing
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"server/internal/ws"
+)
+
+func TestRoomCapacity(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	hub := ws.NewHub()
+	go hub.Run()
+
+	hub.Rooms["full"] = &ws.Room{ID: "full", Clients: make(map[string]*ws.Client), MaxMembers: 1}
+	hub.Rooms["stage"] = &ws.Room{ID: "stage", Clients: make(map[string]*ws.Client), MaxMembers: 1, AllowSpectators: true}
+
+	handler := ws.NewHandler(hub, nil)
+	router := gin.New()
+	router.GET("/ws/:roomId", handler.JoinRoom)
+
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	dial := func(roomID, userID string) *websocket.Conn {
+		url := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws/" + roomID + "?userId=" + userID + "&username=" + userID
+		conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+		require.NoError(t, err)
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		return conn
+	}
+
+	t.Run("rejects joins beyond capacity", func(t *testing.T) {
+		first := dial("full", "alice")
+		defer first.Close()
+
+		var joined ws.Message
+		require.NoError(t, first.ReadJSON(&joined))
+		assert.Equal(t, ws.MessageTypeJoin, joined.Type)
+
+		second := dial("full", "bob")
+		defer second.Close()
+
+		_, _, err := second.ReadMessage()
+		var closeErr *websocket.CloseError
+		require.ErrorAs(t, err, &closeErr)
+		assert.Equal(t, ws.CloseRoomFull, closeErr.Code)
+		assert.Equal(t, "room is full", closeErr.Text)
+	})
+
+	t.Run("admits spectators once full", func(t *testing.T) {
+		first := dial("stage", "carol")
+		defer first.Close()
+
+		var joined ws.Message
+		require.NoError(t, first.ReadJSON(&joined))
+
+		spectator := dial("stage", "dave")
+		defer spectator.Close()
+
+		var notice ws.Message
+		require.NoError(t, spectator.ReadJSON(&notice))
+		assert.Equal(t, ws.MessageTypeSystem, notice.Type)
+
+		require.NoError(t, spectator.WriteJSON(map[string]string{"content": "hello"}))
+
+		var rejected ws.Message
+		require.NoError(t, spectator.ReadJSON(&rejected))
+		assert.Equal(t, ws.MessageTypeError, rejected.Type)
+	})
+}
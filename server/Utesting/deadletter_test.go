@@ -0,0 +1,129 @@
+package testing
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/sony/gobreaker"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"server/internal/message"
+)
+
+// rejectingService fails every write that contains a message with bad content
+type rejectingService struct {
+	message.Service
+	mu    sync.Mutex
+	saved []*message.Message
+	calls int32
+}
+
+func (s *rejectingService) SaveMessages(ctx context.Context, messages []*message.Message) error {
+	atomic.AddInt32(&s.calls, 1)
+	for _, msg := range messages {
+		if msg.Content == "bad" {
+			return &pq.Error{Code: "23503", Message: "violates foreign key constraint"}
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.saved = append(s.saved, messages...)
+	return nil
+}
+
+func (s *rejectingService) SaveMessage(ctx context.Context, msg *message.Message) error {
+	return s.SaveMessages(ctx, []*message.Message{msg})
+}
+
+// recordingSink collects dead-lettered messages
+type recordingSink struct {
+	mu       sync.Mutex
+	messages []*message.Message
+	causes   []error
+}
+
+func (s *recordingSink) Record(ctx context.Context, msg *message.Message, cause error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.messages = append(s.messages, msg)
+	s.causes = append(s.causes, cause)
+	return nil
+}
+
+func TestDeadLetters(t *testing.T) {
+	t.Run("classifies permanent errors", func(t *testing.T) {
+		assert.True(t, message.IsPermanentError(&pq.Error{Code: "23505"}))
+		assert.True(t, message.IsPermanentError(&pq.Error{Code: "22001"}))
+		assert.False(t, message.IsPermanentError(&pq.Error{Code: "08006"}))
+		assert.False(t, message.IsPermanentError(&pq.Error{Code: "2"}), "codes without a class are not classified")
+		assert.False(t, message.IsPermanentError(&pq.Error{}))
+		assert.False(t, message.IsPermanentError(errors.New("connection refused")))
+	})
+
+	t.Run("only the offending message is dead-lettered", func(t *testing.T) {
+		svc := &rejectingService{}
+		sink := &recordingSink{}
+
+		wb := message.NewWriteBehind(svc, message.WriteBehindConfig{
+			QueueSize:     10,
+			BatchSize:     10,
+			FlushInterval: time.Hour,
+		})
+		wb.UseDeadLetters(sink)
+		go wb.Run()
+
+		for _, content := range []string{"hello", "bad", "world"} {
+			assert.NoError(t, wb.Enqueue(&message.Message{RoomID: "room", Content: content}))
+		}
+		wb.Close()
+
+		assert.Len(t, svc.saved, 2)
+		assert.Len(t, sink.messages, 1)
+		assert.Equal(t, "bad", sink.messages[0].Content)
+		assert.True(t, message.IsPermanentError(sink.causes[0]))
+	})
+
+	t.Run("permanent failures behind the resilient service are dead-lettered, not spooled", func(t *testing.T) {
+		svc := &rejectingService{}
+		resilient := message.NewResilientService(svc, message.CircuitBreakerConfig{
+			Name: "test",
+			ReadyToTrip: func(counts gobreaker.Counts) bool {
+				return counts.ConsecutiveFailures >= 3
+			},
+		}, message.RetryConfig{
+			MaxElapsedTime:  time.Second,
+			MaxInterval:     time.Millisecond,
+			InitialInterval: time.Millisecond,
+		})
+		sink := &recordingSink{}
+		spool, err := message.NewSpool(t.TempDir())
+		require.NoError(t, err)
+		defer spool.Close()
+
+		wb := message.NewWriteBehind(resilient, message.WriteBehindConfig{
+			QueueSize:     10,
+			BatchSize:     10,
+			FlushInterval: time.Hour,
+		})
+		wb.UseDeadLetters(sink)
+		wb.UseSpool(spool)
+		go wb.Run()
+
+		for _, content := range []string{"bad", "bad", "bad", "hello"} {
+			assert.NoError(t, wb.Enqueue(&message.Message{RoomID: "room", Content: content}))
+		}
+		wb.Close()
+
+		assert.Len(t, sink.messages, 3)
+		assert.Len(t, svc.saved, 1)
+		assert.Zero(t, spool.Depth())
+		assert.Equal(t, int32(5), atomic.LoadInt32(&svc.calls), "the batch and each message are tried once")
+		assert.Equal(t, gobreaker.StateClosed, resilient.MessageBreakerState(), "bad data says nothing about the database's health")
+	})
+}
@@ -0,0 +1,146 @@
+package testing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sony/gobreaker"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"server/internal/health"
+	"server/internal/ws"
+)
+
+func TestHealthChecker(t *testing.T) {
+	ctx := context.Background()
+	up := func(ctx context.Context) error { return nil }
+	down := func(ctx context.Context) error { return errors.New("connection refused") }
+
+	statuses := func(report *health.Report) map[string]health.Status {
+		statuses := make(map[string]health.Status)
+		for _, result := range report.Checks {
+			statuses[result.Name] = result.Status
+		}
+		return statuses
+	}
+
+	t.Run("a critical dependency that is down takes the server down", func(t *testing.T) {
+		checker := health.NewChecker(health.Config{Timeout: time.Second})
+		checker.Register("postgres", true, down)
+		checker.Register("redis", false, up)
+
+		report := checker.Run(ctx)
+		assert.Equal(t, health.StatusDown, report.Status)
+		assert.False(t, report.Ready())
+		assert.Equal(t, map[string]health.Status{"postgres": health.StatusDown, "redis": health.StatusUp}, statuses(report))
+		assert.Equal(t, "connection refused", report.Checks[0].Error)
+	})
+
+	t.Run("other dependencies only degrade the server", func(t *testing.T) {
+		checker := health.NewChecker(health.Config{Timeout: time.Second})
+		checker.Register("postgres", true, up)
+		checker.Register("redis", false, down)
+
+		report := checker.Run(ctx)
+		assert.Equal(t, health.StatusDegraded, report.Status)
+		assert.True(t, report.Ready())
+	})
+
+	t.Run("slow and timed out checks", func(t *testing.T) {
+		checker := health.NewChecker(health.Config{Timeout: 50 * time.Millisecond, SlowThreshold: 10 * time.Millisecond})
+		checker.Register("slow", true, func(ctx context.Context) error {
+			time.Sleep(20 * time.Millisecond)
+			return nil
+		})
+		checker.Register("hung", false, func(ctx context.Context) error {
+			<-ctx.Done()
+			return ctx.Err()
+		})
+
+		report := checker.Run(ctx)
+		assert.Equal(t, map[string]health.Status{"slow": health.StatusDegraded, "hung": health.StatusDown}, statuses(report))
+		assert.GreaterOrEqual(t, report.Checks[0].LatencyMS, 20.0)
+		assert.Equal(t, health.StatusDegraded, report.Status)
+	})
+
+	t.Run("breaker states", func(t *testing.T) {
+		checker := health.NewChecker(health.Config{})
+		for name, state := range map[string]gobreaker.State{
+			"closed":    gobreaker.StateClosed,
+			"half-open": gobreaker.StateHalfOpen,
+			"open":      gobreaker.StateOpen,
+		} {
+			state := state
+			checker.Register(name, false, health.Breaker(func() gobreaker.State { return state }))
+		}
+
+		assert.Equal(t, map[string]health.Status{
+			"closed":    health.StatusUp,
+			"half-open": health.StatusDegraded,
+			"open":      health.StatusDown,
+		}, statuses(checker.Run(ctx)))
+	})
+}
+
+func TestHealthHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var postgresErr error
+	checker := health.NewChecker(health.Config{Timeout: time.Second})
+	checker.Register("postgres", true, func(ctx context.Context) error { return postgresErr })
+
+	handler := health.NewHandler(checker)
+	r := gin.New()
+	r.GET("/healthz", handler.Live)
+	r.GET("/readyz", handler.Ready)
+	r.GET("/status", handler.Status)
+
+	get := func(path string) (int, map[string]interface{}) {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+
+		var body map[string]interface{}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+		return w.Code, body
+	}
+
+	code, body := get("/readyz")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, map[string]interface{}{"postgres": "up"}, body["checks"])
+
+	postgresErr = errors.New("connection refused")
+
+	code, _ = get("/healthz")
+	assert.Equal(t, http.StatusOK, code, "liveness does not depend on dependencies")
+
+	code, body = get("/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, "down", body["status"])
+
+	code, body = get("/status")
+	assert.Equal(t, http.StatusOK, code)
+	require.Len(t, body["checks"], 1)
+	check := body["checks"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, "connection refused", check["error"])
+	assert.Contains(t, check, "latencyMs")
+}
+
+func TestHubPing(t *testing.T) {
+	hub := ws.NewShardedHub(2)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, hub.Ping(ctx), context.DeadlineExceeded, "loops that do not run never answer")
+
+	go hub.Run()
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	assert.NoError(t, hub.Ping(ctx))
+}
@@ -0,0 +1,81 @@
+package testing
+
+import (
+	"fmt"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"server/internal/ws"
+)
+
+const (
+	benchRooms          = 64
+	benchClientsPerRoom = 8
+)
+
+// setupBenchRouter creates rooms with draining clients on every shard of the
+// router and returns the room IDs and the delivery counter
+func setupBenchRouter(router ws.Router) ([]string, *int64) {
+	var delivered int64
+	roomIDs := make([]string, benchRooms)
+
+	for i := range roomIDs {
+		roomID := fmt.Sprintf("room-%d", i)
+		roomIDs[i] = roomID
+
+		room := &ws.Room{ID: roomID, Clients: make(map[string]*ws.Client)}
+		for j := 0; j < benchClientsPerRoom; j++ {
+			cl := &ws.Client{
+				ID:      fmt.Sprintf("%s-client-%d", roomID, j),
+				RoomID:  roomID,
+				Message: make(chan *ws.Message, 256),
+			}
+			room.Clients[cl.ID] = cl
+
+			go func(messages chan *ws.Message) {
+				for range messages {
+					atomic.AddInt64(&delivered, 1)
+				}
+			}(cl.Message)
+		}
+		router.ShardFor(roomID).Rooms[roomID] = room
+	}
+
+	for _, shard := range router.Shards() {
+		go shard.Run()
+	}
+
+	return roomIDs, &delivered
+}
+
+func benchmarkBroadcast(b *testing.B, router ws.Router) {
+	roomIDs, delivered := setupBenchRouter(router)
+	var next uint64
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			roomID := roomIDs[atomic.AddUint64(&next, 1)%uint64(len(roomIDs))]
+			router.ShardFor(roomID).Broadcast <- &ws.Message{
+				Type:   ws.MessageTypeChat,
+				RoomID: roomID,
+			}
+		}
+	})
+
+	want := int64(b.N) * benchClientsPerRoom
+	for atomic.LoadInt64(delivered) < want {
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func BenchmarkHubBroadcastSingleLoop(b *testing.B) {
+	benchmarkBroadcast(b, ws.NewHub())
+}
+
+func BenchmarkHubBroadcastSharded(b *testing.B) {
+	benchmarkBroadcast(b, ws.NewShardedHub(runtime.GOMAXPROCS(0)))
+}
@@ -0,0 +1,152 @@
+package testing
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"server/internal/apperr"
+	"server/internal/message"
+	"server/internal/metrics"
+	"server/internal/ws"
+)
+
+// scrapeMetrics returns the exposition of the metrics registry
+func scrapeMetrics(t *testing.T) string {
+	w := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	return w.Body.String()
+}
+
+func TestHTTPMetrics(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(metrics.Middleware())
+	r.GET("/api/rooms/:roomId", func(c *gin.Context) {
+		c.Status(http.StatusNotFound)
+	})
+
+	requests := func(route, status string) float64 {
+		return testutil.ToFloat64(metrics.HTTPRequests.WithLabelValues(http.MethodGet, route, status))
+	}
+	before, unmatched := requests("/api/rooms/:roomId", "404"), requests("unmatched", "404")
+
+	for _, path := range []string{"/api/rooms/a", "/api/rooms/b", "/nowhere"} {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	assert.Equal(t, before+2, requests("/api/rooms/:roomId", "404"), "requests are labelled by route, not path")
+	assert.Equal(t, unmatched+1, requests("unmatched", "404"))
+	assert.Contains(t, scrapeMetrics(t), `chat_http_request_duration_seconds_count{method="GET",route="/api/rooms/:roomId"}`)
+}
+
+func TestRepositoryMetrics(t *testing.T) {
+	ctx := context.Background()
+	repo := message.NewInstrumentedRepository(message.NewMemoryRepository())
+
+	saved := testutil.ToFloat64(metrics.MessagesSaved)
+	require.NoError(t, repo.SaveMessage(ctx, &message.Message{ID: "m1", RoomID: "room", Type: "message", Timestamp: time.Now()}))
+	require.NoError(t, repo.SaveMessages(ctx, []*message.Message{
+		{ID: "m2", RoomID: "room", Type: "message", Timestamp: time.Now()},
+		{ID: "m3", RoomID: "room", Type: "message", Timestamp: time.Now()},
+	}))
+	_, err := repo.GetMessageByID(ctx, "missing")
+	require.Error(t, err)
+
+	assert.Equal(t, saved+3, testutil.ToFloat64(metrics.MessagesSaved))
+	out := scrapeMetrics(t)
+	assert.Contains(t, out, `chat_repository_duration_seconds_count{operation="SaveMessages",result="ok"}`)
+	assert.Contains(t, out, `chat_repository_duration_seconds_count{operation="GetMessageByID",result="error"}`)
+}
+
+func TestRetryMetrics(t *testing.T) {
+	inner := &countingService{err: errDatabaseDown}
+	svc := newCountingResilientService(inner)
+	svc.UseRetryConfig(message.OpGetRoomByID, message.RetryConfig{
+		MaxElapsedTime:  time.Second,
+		MaxInterval:     time.Millisecond,
+		InitialInterval: time.Millisecond,
+		MaxRetries:      2,
+	})
+
+	retries := metrics.Retries.WithLabelValues(string(message.OpGetRoomByID))
+	before := testutil.ToFloat64(retries)
+
+	_, err := svc.GetRoomByID(context.Background(), "room")
+	require.Error(t, err)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&inner.attempts))
+	assert.Equal(t, before+2, testutil.ToFloat64(retries), "the first attempt is not a retry")
+}
+
+func TestHubMetrics(t *testing.T) {
+	hub := ws.NewHub()
+	hub.Rooms["room"] = &ws.Room{ID: "room", Clients: make(map[string]*ws.Client)}
+	go hub.Run()
+
+	clients := testutil.ToFloat64(metrics.HubClients)
+	cl := &ws.Client{ID: "c1", RoomID: "room", Message: make(chan *ws.Message, 10)}
+	hub.Register <- cl
+	hub.Unregister <- cl
+	hub.Register <- &ws.Client{ID: "c2", RoomID: "room", Message: make(chan *ws.Message, 10)}
+	require.NoError(t, hub.Ping(context.Background()))
+
+	assert.Equal(t, clients+1, testutil.ToFloat64(metrics.HubClients))
+
+	hub.Broadcast <- &ws.Message{RoomID: "room", Content: "hi"}
+	require.NoError(t, hub.Ping(context.Background()))
+	assert.Contains(t, scrapeMetrics(t), "chat_hub_broadcast_fanout_seconds_count")
+
+	assert.Equal(t, 4, testutil.CollectAndCount(ws.NewQueueCollector(hub)), "one depth per queue of the shard")
+}
+
+func TestCreatingAnExistingRoomKeepsItsClients(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	hub := ws.NewHub()
+	go hub.Run()
+
+	r := gin.New()
+	r.Use(apperr.Middleware())
+	r.POST("/ws/createRoom", ws.NewHandler(hub, nil).CreateRoom)
+	createRoom := func() int {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/ws/createRoom", strings.NewReader(`{"id":"existing","name":"Existing"}`)))
+		return w.Code
+	}
+
+	rooms := testutil.ToFloat64(metrics.HubRooms)
+	require.Equal(t, http.StatusOK, createRoom())
+	hub.Register <- &ws.Client{ID: "c1", RoomID: "existing", Message: make(chan *ws.Message, 10)}
+	require.NoError(t, hub.Ping(context.Background()))
+
+	assert.Equal(t, http.StatusConflict, createRoom())
+	require.NoError(t, hub.Ping(context.Background()))
+	assert.Len(t, hub.Rooms["existing"].Clients, 1)
+	assert.Equal(t, rooms+1, testutil.ToFloat64(metrics.HubRooms))
+}
+
+func TestRedisMetrics(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer client.Close()
+	client.AddHook(metrics.RedisHook{})
+
+	ctx := context.Background()
+	require.NoError(t, client.Set(ctx, "key", "value", 0).Err())
+	require.ErrorIs(t, client.Get(ctx, "missing").Err(), redis.Nil)
+
+	out := scrapeMetrics(t)
+	assert.Contains(t, out, `chat_redis_duration_seconds_count{command="set",result="ok"}`)
+	assert.Contains(t, out, `chat_redis_duration_seconds_count{command="get",result="ok"}`, "a missing key is not an error")
+}
@@ -0,0 +1,49 @@
+package testing
+
+import (
+	"io/fs"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"server/db"
+)
+
+func TestEmbeddedMigrations(t *testing.T) {
+	name := regexp.MustCompile(`^(\d{6})_\w+\.(up|down)\.sql$`)
+
+	// migrationFiles lists the migration files of a directory, ignoring the
+	// subdirectories of other drivers
+	migrationFiles := func(t *testing.T, dir string) []string {
+		entries, err := fs.ReadDir(db.Migrations, dir)
+		require.NoError(t, err)
+
+		var files []string
+		for _, entry := range entries {
+			if entry.IsDir() {
+				continue
+			}
+			require.Regexp(t, name, entry.Name())
+			files = append(files, entry.Name())
+		}
+		return files
+	}
+
+	postgres := migrationFiles(t, "migrations")
+	sqlite := migrationFiles(t, "migrations/sqlite")
+	assert.Equal(t, postgres, sqlite, "every driver needs the same migrations")
+
+	for _, driver := range []string{db.DriverPostgres, db.DriverSQLite} {
+		latest, err := db.LatestMigration(driver)
+		require.NoError(t, err)
+		assert.Equal(t, uint(len(postgres)/2), latest, "%s versions must be consecutive", driver)
+	}
+
+	for i := 0; i < len(postgres); i += 2 {
+		down, up := name.FindStringSubmatch(postgres[i]), name.FindStringSubmatch(postgres[i+1])
+		assert.Equal(t, down[1], up[1], "version %s needs an up and a down migration", down[1])
+		assert.Equal(t, []string{"down", "up"}, []string{down[2], up[2]})
+	}
+}
@@ -0,0 +1,109 @@
+package testing
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"server/internal/apperr"
+	"server/internal/message"
+)
+
+type historyResponse struct {
+	Messages   []message.Message `json:"messages"`
+	NextCursor string            `json:"nextCursor"`
+	PrevCursor string            `json:"prevCursor"`
+}
+
+func TestRoomHistoryCursors(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	ctx := context.Background()
+
+	svc := message.NewService(message.NewMemoryRepository(), message.NewMemoryCache(100))
+	start := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	save := func(i int) {
+		require.NoError(t, svc.SaveMessage(ctx, &message.Message{
+			ID:        fmt.Sprintf("msg-%02d", i),
+			RoomID:    "room",
+			Content:   fmt.Sprintf("message %d", i),
+			Timestamp: start.Add(time.Duration(i) * time.Second),
+		}))
+	}
+	for i := 0; i < 5; i++ {
+		save(i)
+	}
+
+	r := gin.New()
+	r.Use(apperr.Middleware())
+	r.GET("/messages/room/:roomId", message.NewHandler(svc).GetMessages)
+
+	get := func(t *testing.T, query url.Values) (int, historyResponse) {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/messages/room/room?"+query.Encode(), nil))
+
+		var body historyResponse
+		if w.Code == http.StatusOK {
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+		}
+		return w.Code, body
+	}
+	ids := func(body historyResponse) []string {
+		var ids []string
+		for _, msg := range body.Messages {
+			ids = append(ids, msg.ID)
+		}
+		return ids
+	}
+
+	t.Run("pages walk back through history without gaps", func(t *testing.T) {
+		code, first := get(t, url.Values{"limit": {"2"}})
+		require.Equal(t, http.StatusOK, code)
+		assert.Equal(t, []string{"msg-04", "msg-03"}, ids(first))
+		assert.Empty(t, first.PrevCursor)
+		require.NotEmpty(t, first.NextCursor)
+
+		// A message arriving between requests does not shift older pages
+		save(5)
+
+		_, second := get(t, url.Values{"limit": {"2"}, "before": {first.NextCursor}})
+		assert.Equal(t, []string{"msg-02", "msg-01"}, ids(second))
+		require.NotEmpty(t, second.NextCursor)
+		require.NotEmpty(t, second.PrevCursor)
+
+		_, last := get(t, url.Values{"limit": {"2"}, "before": {second.NextCursor}})
+		assert.Equal(t, []string{"msg-00"}, ids(last))
+		assert.Empty(t, last.NextCursor)
+
+		_, back := get(t, url.Values{"limit": {"2"}, "after": {second.PrevCursor}})
+		assert.Equal(t, []string{"msg-04", "msg-03"}, ids(back))
+		assert.NotEmpty(t, back.PrevCursor, "msg-05 is newer still")
+
+		_, newest := get(t, url.Values{"limit": {"2"}, "after": {back.PrevCursor}})
+		assert.Equal(t, []string{"msg-05"}, ids(newest))
+		assert.Empty(t, newest.PrevCursor)
+	})
+
+	t.Run("offsets are still accepted", func(t *testing.T) {
+		code, body := get(t, url.Values{"limit": {"2"}, "offset": {"1"}})
+		require.Equal(t, http.StatusOK, code)
+		assert.Equal(t, []string{"msg-04", "msg-03"}, ids(body))
+	})
+
+	t.Run("bad cursors are rejected", func(t *testing.T) {
+		code, _ := get(t, url.Values{"before": {"not a cursor"}})
+		assert.Equal(t, http.StatusBadRequest, code)
+
+		cursor := message.CursorOf(&message.Message{ID: "msg-01", Timestamp: start}).Encode()
+		code, _ = get(t, url.Values{"before": {cursor}, "after": {cursor}})
+		assert.Equal(t, http.StatusBadRequest, code)
+	})
+}
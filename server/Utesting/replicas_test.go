@@ -0,0 +1,184 @@
+package testing
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+
+	"server/db"
+	"server/internal/apperr"
+	"server/internal/auth"
+	"server/internal/ws"
+	"server/router"
+)
+
+func TestReplicaSetRouting(t *testing.T) {
+	open := func(name string) *sql.DB {
+		database, err := db.NewSQLiteDatabase(filepath.Join(t.TempDir(), name+".db"))
+		require.NoError(t, err)
+		return database.GetDB()
+	}
+
+	primary := open("primary")
+	defer primary.Close()
+	replicaA, replicaB := open("a"), open("b")
+
+	replicas := db.NewReplicaSet(primary, []*sql.DB{replicaA, replicaB}, db.ReplicaConfig{
+		HealthInterval: 10 * time.Millisecond,
+	})
+	go replicas.Run()
+	defer replicas.Close()
+
+	ctx := context.Background()
+	require.Equal(t, 2, replicas.HealthyReplicas())
+
+	t.Run("reads rotate through the replicas", func(t *testing.T) {
+		seen := make(map[*sql.DB]bool)
+		for i := 0; i < 4; i++ {
+			seen[replicas.Reader(ctx)] = true
+		}
+		assert.Equal(t, map[*sql.DB]bool{replicaA: true, replicaB: true}, seen)
+	})
+
+	t.Run("pinned reads go to the primary", func(t *testing.T) {
+		assert.Same(t, primary, replicas.Reader(db.WithPrimary(ctx)))
+	})
+
+	t.Run("unhealthy replicas are skipped", func(t *testing.T) {
+		replicaA.Close()
+		require.Eventually(t, func() bool {
+			return replicas.HealthyReplicas() == 1
+		}, time.Second, 10*time.Millisecond)
+
+		for i := 0; i < 4; i++ {
+			assert.Same(t, replicaB, replicas.Reader(ctx))
+		}
+	})
+
+	t.Run("reads fall back to the primary without healthy replicas", func(t *testing.T) {
+		replicaB.Close()
+		require.Eventually(t, func() bool {
+			return replicas.HealthyReplicas() == 0
+		}, time.Second, 10*time.Millisecond)
+
+		assert.Same(t, primary, replicas.Reader(ctx))
+	})
+}
+
+// routingAuthRepository records whether each call would read from the primary
+type routingAuthRepository struct {
+	auth.Repository
+	replicas *db.ReplicaSet
+	mu       sync.Mutex
+	primary  map[string]bool
+}
+
+func (r *routingAuthRepository) record(ctx context.Context, method string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.primary[method] = r.replicas.Reader(ctx) == r.replicas.Primary()
+}
+
+func (r *routingAuthRepository) UpsertUser(ctx context.Context, user *auth.User) (*auth.User, error) {
+	r.record(ctx, "UpsertUser")
+	return r.Repository.UpsertUser(ctx, user)
+}
+
+func (r *routingAuthRepository) GetSessionByToken(ctx context.Context, token string) (*auth.Session, error) {
+	r.record(ctx, "GetSessionByToken")
+	return r.Repository.GetSessionByToken(ctx, token)
+}
+
+func TestReadYourWritesAfterOAuthLogin(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	open := func(name string) *sql.DB {
+		database, err := db.NewSQLiteDatabase(filepath.Join(t.TempDir(), name+".db"))
+		require.NoError(t, err)
+		return database.GetDB()
+	}
+	primary := open("primary")
+	defer primary.Close()
+	replicas := db.NewReplicaSet(primary, []*sql.DB{open("replica")}, db.ReplicaConfig{HealthInterval: time.Second})
+	defer replicas.Close()
+	require.Equal(t, 1, replicas.HealthyReplicas())
+
+	provider := http.NewServeMux()
+	provider.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token":"token","token_type":"Bearer"}`))
+	})
+	provider.HandleFunc("/userinfo", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id":"g1","email":"alice@example.com","name":"Alice"}`))
+	})
+	providerServer := httptest.NewServer(provider)
+	defer providerServer.Close()
+
+	repo := &routingAuthRepository{Repository: auth.NewMemoryRepository(), replicas: replicas, primary: make(map[string]bool)}
+	handler := auth.NewHandler(auth.NewService(repo), auth.OAuthConfig{
+		ClientOrigin: "http://localhost:3000",
+		Endpoint:     oauth2.Endpoint{TokenURL: providerServer.URL + "/token", AuthStyle: oauth2.AuthStyleInParams},
+		UserInfoURL:  providerServer.URL + "/userinfo",
+	})
+
+	r := gin.New()
+	r.Use(apperr.Middleware())
+	r.Use(router.ReadYourWrites(5 * time.Second))
+	r.GET("/auth/google/callback", handler.GoogleCallback)
+	r.GET("/auth/me", handler.GetMe)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/auth/google/callback?code=code", nil))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.True(t, repo.primary["UpsertUser"])
+
+	me := httptest.NewRequest(http.MethodGet, "/auth/me", nil)
+	var pinned bool
+	for _, cookie := range w.Result().Cookies() {
+		me.AddCookie(cookie)
+		pinned = pinned || cookie.Name == "read_primary"
+	}
+	require.True(t, pinned, "the callback writes over a GET and must pin the client")
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, me)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.True(t, repo.primary["GetSessionByToken"], "the new session is read from the primary, not a lagging replica")
+}
+
+func TestReadYourWritesAfterJoiningRoom(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	hub := ws.NewHub()
+	hub.Rooms["room"] = &ws.Room{ID: "room", Clients: make(map[string]*ws.Client)}
+	go hub.Run()
+
+	r := gin.New()
+	r.Use(router.ReadYourWrites(5 * time.Second))
+	r.GET("/ws/joinRoom/:roomId", ws.NewHandler(hub, nil).JoinRoom)
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	conn, resp, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/ws/joinRoom/room?userId=u1&username=alice", nil)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	var pinned bool
+	for _, cookie := range resp.Cookies() {
+		pinned = pinned || cookie.Name == "read_primary"
+	}
+	assert.True(t, pinned, "messages are written over the WebSocket, so joining pins the client")
+}
@@ -0,0 +1,112 @@
+package testing
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"server/internal/message"
+)
+
+const (
+	benchBatchSize      = 100
+	benchHistoryPage    = 50
+	benchHistoryMessage = 500
+)
+
+// postgresRepositories opens every Postgres-backed message repository on an
+// empty test database, skipping the benchmark when Postgres is not reachable
+func postgresRepositories() map[string]func(b *testing.B) message.Repository {
+	return map[string]func(b *testing.B) message.Repository{
+		"pq": func(b *testing.B) message.Repository {
+			return message.NewPostgresRepository(SetupTestDB(b))
+		},
+		"pgx": func(b *testing.B) message.Repository {
+			return message.NewPgxRepository(SetupPgxPool(b))
+		},
+	}
+}
+
+func benchMessage(roomID string, i int) *message.Message {
+	return &message.Message{
+		ID:        uuid.New().String(),
+		RoomID:    roomID,
+		UserID:    "bench-user",
+		Username:  "bench",
+		Content:   fmt.Sprintf("benchmark message %d", i),
+		Type:      "message",
+		Timestamp: time.Now().Add(time.Duration(i) * time.Millisecond),
+	}
+}
+
+func BenchmarkRepositorySaveMessage(b *testing.B) {
+	for name, newRepo := range postgresRepositories() {
+		b.Run(name, func(b *testing.B) {
+			repo := newRepo(b)
+			ctx := context.Background()
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := repo.SaveMessage(ctx, benchMessage("bench", i)); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkRepositorySaveMessages(b *testing.B) {
+	for name, newRepo := range postgresRepositories() {
+		b.Run(name, func(b *testing.B) {
+			repo := newRepo(b)
+			ctx := context.Background()
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				batch := make([]*message.Message, benchBatchSize)
+				for j := range batch {
+					batch[j] = benchMessage("bench", i*benchBatchSize+j)
+				}
+				b.StartTimer()
+
+				if err := repo.SaveMessages(ctx, batch); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkRepositoryGetMessagesByRoom(b *testing.B) {
+	for name, newRepo := range postgresRepositories() {
+		b.Run(name, func(b *testing.B) {
+			repo := newRepo(b)
+			ctx := context.Background()
+
+			history := make([]*message.Message, benchHistoryMessage)
+			for i := range history {
+				history[i] = benchMessage("bench", i)
+			}
+			if err := repo.SaveMessages(ctx, history); err != nil {
+				b.Fatal(err)
+			}
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					if _, err := repo.GetMessagesByRoom(ctx, "bench", benchHistoryPage, 0); err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,525 @@
+package testing
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"server/internal/auth"
+	"server/internal/message"
+	"server/internal/user"
+)
+
+// The contract suites below describe the behaviour every implementation of a
+// repository interface must share. Each backend runs them through the
+// Test*RepositoryContract functions at the bottom of this file.
+
+// contractTime returns a timestamp every backend stores without losing precision
+func contractTime(offset time.Duration) time.Time {
+	return time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC).Add(offset)
+}
+
+func testMessageRepositoryContract(t *testing.T, newRepo func(t *testing.T) message.Repository) {
+	ctx := context.Background()
+
+	newMessage := func(roomID string, at time.Duration) *message.Message {
+		return &message.Message{
+			ID:        uuid.New().String(),
+			RoomID:    roomID,
+			UserID:    "user-1",
+			Username:  "alice",
+			Content:   fmt.Sprintf("message at %s", at),
+			Type:      "message",
+			Timestamp: contractTime(at),
+		}
+	}
+
+	t.Run("saved messages can be read back", func(t *testing.T) {
+		repo := newRepo(t)
+		msg := newMessage("room-1", 0)
+		msg.Recipient = "bob"
+		require.NoError(t, repo.SaveMessage(ctx, msg))
+
+		got, err := repo.GetMessageByID(ctx, msg.ID)
+		require.NoError(t, err)
+		assert.Equal(t, msg.ID, got.ID)
+		assert.Equal(t, msg.RoomID, got.RoomID)
+		assert.Equal(t, msg.UserID, got.UserID)
+		assert.Equal(t, msg.Username, got.Username)
+		assert.Equal(t, msg.Content, got.Content)
+		assert.Equal(t, msg.Type, got.Type)
+		assert.Equal(t, msg.Recipient, got.Recipient)
+		assert.True(t, msg.Timestamp.Equal(got.Timestamp), "timestamp %s != %s", got.Timestamp, msg.Timestamp)
+	})
+
+	t.Run("missing messages are not found", func(t *testing.T) {
+		_, err := newRepo(t).GetMessageByID(ctx, "missing")
+		assert.ErrorIs(t, err, message.ErrMessageNotFound)
+	})
+
+	t.Run("saving the same message twice keeps one copy", func(t *testing.T) {
+		repo := newRepo(t)
+		msg := newMessage("room-1", 0)
+		require.NoError(t, repo.SaveMessage(ctx, msg))
+		require.NoError(t, repo.SaveMessages(ctx, []*message.Message{msg, newMessage("room-1", time.Second)}))
+
+		messages, err := repo.GetMessagesByRoom(ctx, "room-1", 10, 0)
+		require.NoError(t, err)
+		assert.Len(t, messages, 2)
+	})
+
+	t.Run("room history is paged newest first", func(t *testing.T) {
+		repo := newRepo(t)
+		var batch []*message.Message
+		for i := 0; i < 5; i++ {
+			batch = append(batch, newMessage("room-1", time.Duration(i)*time.Second))
+		}
+		require.NoError(t, repo.SaveMessages(ctx, batch))
+		require.NoError(t, repo.SaveMessage(ctx, newMessage("room-2", time.Hour)))
+
+		page, err := repo.GetMessagesByRoom(ctx, "room-1", 2, 1)
+		require.NoError(t, err)
+		require.Len(t, page, 2)
+		assert.Equal(t, batch[3].ID, page[0].ID)
+		assert.Equal(t, batch[2].ID, page[1].ID)
+
+		page, err = repo.GetMessagesByRoom(ctx, "room-1", 10, 5)
+		require.NoError(t, err)
+		assert.Empty(t, page)
+	})
+
+	t.Run("room history is paged with cursors", func(t *testing.T) {
+		repo := newRepo(t)
+		var batch []*message.Message
+		for i := 0; i < 5; i++ {
+			batch = append(batch, newMessage("room-1", time.Duration(i)*time.Second))
+		}
+		// Two messages in the same instant are ordered by ID
+		tied := newMessage("room-1", 2*time.Second)
+		tied.ID = batch[2].ID + "-tied"
+		batch = append(batch, tied)
+		require.NoError(t, repo.SaveMessages(ctx, batch))
+		require.NoError(t, repo.SaveMessage(ctx, newMessage("room-2", time.Hour)))
+
+		ids := func(messages []*message.Message) []string {
+			var ids []string
+			for _, msg := range messages {
+				ids = append(ids, msg.ID)
+			}
+			return ids
+		}
+
+		latest, err := repo.GetMessagesByRoomCursor(ctx, "room-1", message.HistoryQuery{Limit: 3})
+		require.NoError(t, err)
+		assert.Equal(t, []string{batch[4].ID, batch[3].ID, tied.ID}, ids(latest))
+
+		older, err := repo.GetMessagesByRoomCursor(ctx, "room-1", message.HistoryQuery{Limit: 2, Before: message.CursorOf(tied)})
+		require.NoError(t, err)
+		assert.Equal(t, []string{batch[2].ID, batch[1].ID}, ids(older))
+
+		newer, err := repo.GetMessagesByRoomCursor(ctx, "room-1", message.HistoryQuery{Limit: 2, After: message.CursorOf(batch[1])})
+		require.NoError(t, err)
+		assert.Equal(t, []string{tied.ID, batch[2].ID}, ids(newer), "paging forward returns the messages closest to the cursor")
+
+		oldest, err := repo.GetMessagesByRoomCursor(ctx, "room-1", message.HistoryQuery{Limit: 2, Before: message.CursorOf(batch[0])})
+		require.NoError(t, err)
+		assert.Empty(t, oldest)
+	})
+
+	t.Run("messages can be searched", func(t *testing.T) {
+		repo := newRepo(t)
+		post := func(roomID, userID, sender, content string, at time.Duration) *message.Message {
+			msg := newMessage(roomID, at)
+			msg.UserID = userID
+			msg.Username = sender
+			msg.Content = content
+			require.NoError(t, repo.SaveMessage(ctx, msg))
+			return msg
+		}
+		often := post("room-1", "user-1", "alice", "release release release", 0)
+		once := post("room-1", "user-bob", "bob", "the <b>release</b> notes are ready for review", time.Minute)
+		other := post("room-2", "user-bob", "bob", "release party tonight", 2*time.Minute)
+		post("room-1", "user-1", "alice", "lunch anyone?", 3*time.Minute)
+		private := newMessage("room-1", 4*time.Minute)
+		private.Content = "release secret"
+		private.Recipient = "bob"
+		private.RecipientID = "user-bob"
+		require.NoError(t, repo.SaveMessage(ctx, private))
+		post("room-1", "user-carol", "carol", "hi", 5*time.Minute)
+		post("room-1", "user-erin", "erin", "hi", 5*time.Minute)
+		post("room-2", "user-erin", "erin", "hi", 6*time.Minute)
+		post("room-1", "user-other-bob", "bob", "hi", 7*time.Minute)
+		post("room-2", "user-mallory", "carol", "hi", 8*time.Minute)
+		require.NoError(t, repo.CreateRoom(ctx, &message.Room{ID: "room-2", Name: "Room 2", OwnerID: "user-dave", Created: contractTime(0), LastActivity: contractTime(0)}))
+
+		search := func(query message.SearchQuery) []string {
+			if query.Limit == 0 {
+				query.Limit = 10
+			}
+			if query.ViewerID == "" {
+				query.ViewerID = "user-erin"
+			}
+			results, err := repo.SearchMessages(ctx, query)
+			require.NoError(t, err)
+			var ids []string
+			for _, result := range results {
+				ids = append(ids, result.Message.ID)
+			}
+			return ids
+		}
+
+		results, err := repo.SearchMessages(ctx, message.SearchQuery{Text: "release", ViewerID: "user-carol", Limit: 10})
+		require.NoError(t, err)
+		require.Len(t, results, 2, "private messages and rooms carol has not posted in are hidden")
+		assert.Equal(t, often.ID, results[0].Message.ID, "more matches rank higher")
+		assert.GreaterOrEqual(t, results[0].Rank, results[1].Rank)
+		for _, result := range results {
+			if result.Message.ID == once.ID {
+				assert.Contains(t, result.Snippet, "<mark>release</mark>")
+				assert.Contains(t, result.Snippet, "&lt;b&gt;", "snippets are escaped")
+			}
+		}
+
+		assert.Contains(t, search(message.SearchQuery{Text: "release", ViewerID: "user-bob"}), private.ID)
+		assert.Contains(t, search(message.SearchQuery{Text: "release", ViewerID: "user-1"}), private.ID)
+		assert.ElementsMatch(t, []string{often.ID, once.ID}, search(message.SearchQuery{Text: "release", ViewerID: "user-other-bob"}), "another user named bob can't read bob's private messages")
+		assert.ElementsMatch(t, []string{other.ID}, search(message.SearchQuery{Text: "release", ViewerID: "user-mallory"}), "posting under carol's name doesn't grant carol's rooms")
+		assert.ElementsMatch(t, []string{other.ID}, search(message.SearchQuery{Text: "release", ViewerID: "user-dave"}), "owners can search their rooms")
+		assert.Empty(t, search(message.SearchQuery{Text: "release", RoomID: "room-2", ViewerID: "user-carol"}))
+		assert.ElementsMatch(t, []string{often.ID, once.ID}, search(message.SearchQuery{Text: "release", RoomID: "room-1"}))
+		assert.ElementsMatch(t, []string{once.ID, other.ID}, search(message.SearchQuery{Text: "release", Sender: "bob"}))
+		assert.ElementsMatch(t, []string{once.ID}, search(message.SearchQuery{
+			Text:  "release",
+			Since: contractTime(time.Minute),
+			Until: contractTime(2 * time.Minute),
+		}))
+		assert.ElementsMatch(t, []string{once.ID}, search(message.SearchQuery{Text: "release notes"}), "every term has to match")
+		assert.Empty(t, search(message.SearchQuery{Text: "release", Type: "system"}))
+		assert.Empty(t, search(message.SearchQuery{Text: "deployment"}))
+
+		nobody, err := repo.SearchMessages(ctx, message.SearchQuery{Text: "release", Limit: 10})
+		require.NoError(t, err)
+		assert.Empty(t, nobody, "a search without a viewer matches nothing")
+
+		first, err := repo.SearchMessages(ctx, message.SearchQuery{Text: "release", ViewerID: "user-erin", Limit: 2})
+		require.NoError(t, err)
+		require.Len(t, first, 2)
+		last := first[1]
+		rest := search(message.SearchQuery{Text: "release", After: &message.SearchCursor{
+			Rank:      last.Rank,
+			Timestamp: last.Message.Timestamp,
+			ID:        last.Message.ID,
+		}})
+		assert.ElementsMatch(t, []string{often.ID, once.ID, other.ID}, append([]string{first[0].Message.ID, last.Message.ID}, rest...))
+	})
+
+	t.Run("rooms can be created once", func(t *testing.T) {
+		repo := newRepo(t)
+		room := &message.Room{
+			ID:              "room-1",
+			Name:            "general",
+			OwnerID:         "user-1",
+			Created:         contractTime(0),
+			LastActivity:    contractTime(0),
+			MaxMembers:      5,
+			AllowSpectators: true,
+		}
+		require.NoError(t, repo.CreateRoom(ctx, room))
+		assert.ErrorIs(t, repo.CreateRoom(ctx, room), message.ErrRoomExists)
+
+		got, err := repo.GetRoomByID(ctx, room.ID)
+		require.NoError(t, err)
+		assert.Equal(t, room.Name, got.Name)
+		assert.Equal(t, room.OwnerID, got.OwnerID)
+		assert.Equal(t, room.MaxMembers, got.MaxMembers)
+		assert.Equal(t, room.AllowSpectators, got.AllowSpectators)
+		assert.True(t, room.Created.Equal(got.Created))
+	})
+
+	t.Run("missing rooms are not found", func(t *testing.T) {
+		_, err := newRepo(t).GetRoomByID(ctx, "missing")
+		assert.ErrorIs(t, err, message.ErrRoomNotFound)
+	})
+
+	t.Run("rooms are listed by recent activity", func(t *testing.T) {
+		repo := newRepo(t)
+		rooms, err := repo.GetRooms(ctx)
+		require.NoError(t, err)
+		assert.Empty(t, rooms)
+
+		for i, id := range []string{"quiet", "busy", "idle"} {
+			require.NoError(t, repo.CreateRoom(ctx, &message.Room{
+				ID:           id,
+				Name:         id,
+				Created:      contractTime(0),
+				LastActivity: contractTime(time.Duration(i) * time.Minute),
+			}))
+		}
+		require.NoError(t, repo.UpdateRoomActivity(ctx, "quiet"))
+		require.NoError(t, repo.UpdateRoomActivity(ctx, "missing"))
+
+		rooms, err = repo.GetRooms(ctx)
+		require.NoError(t, err)
+		require.Len(t, rooms, 3)
+		assert.Equal(t, []string{"quiet", "idle", "busy"}, []string{rooms[0].ID, rooms[1].ID, rooms[2].ID})
+	})
+}
+
+func testAuthRepositoryContract(t *testing.T, newRepo func(t *testing.T) auth.Repository) {
+	ctx := context.Background()
+
+	newUser := func(email string) *auth.User {
+		return &auth.User{
+			ID:           uuid.New().String(),
+			Email:        email,
+			Name:         "Test User",
+			PasswordHash: "hash",
+			CreatedAt:    contractTime(0),
+		}
+	}
+
+	t.Run("users can be found by id and email", func(t *testing.T) {
+		repo := newRepo(t)
+		u := newUser("test@example.com")
+		created, err := repo.UpsertUser(ctx, u)
+		require.NoError(t, err)
+		assert.Equal(t, u.ID, created.ID)
+
+		byID, err := repo.GetUserByID(ctx, u.ID)
+		require.NoError(t, err)
+		assert.Equal(t, u.Email, byID.Email)
+		assert.Equal(t, u.PasswordHash, byID.PasswordHash)
+
+		byEmail, err := repo.GetUserByEmail(ctx, u.Email)
+		require.NoError(t, err)
+		assert.Equal(t, u.ID, byEmail.ID)
+	})
+
+	t.Run("missing users are not found", func(t *testing.T) {
+		repo := newRepo(t)
+		_, err := repo.GetUserByID(ctx, uuid.New().String())
+		assert.ErrorIs(t, err, auth.ErrUserNotFound)
+		_, err = repo.GetUserByEmail(ctx, "nobody@example.com")
+		assert.ErrorIs(t, err, auth.ErrUserNotFound)
+		_, err = repo.GetUserByGoogleID(ctx, "google-1")
+		assert.ErrorIs(t, err, auth.ErrUserNotFound)
+	})
+
+	t.Run("upsert by email links a google account", func(t *testing.T) {
+		repo := newRepo(t)
+		u := newUser("linked@example.com")
+		_, err := repo.UpsertUser(ctx, u)
+		require.NoError(t, err)
+
+		google := &auth.User{
+			ID:        uuid.New().String(),
+			Email:     u.Email,
+			Name:      "Google Name",
+			Picture:   "https://example.com/me.png",
+			GoogleID:  "google-1",
+			CreatedAt: contractTime(time.Hour),
+		}
+		linked, err := repo.UpsertUser(ctx, google)
+		require.NoError(t, err)
+		assert.Equal(t, u.ID, linked.ID)
+		assert.Equal(t, "Google Name", linked.Name)
+		assert.Equal(t, "hash", linked.PasswordHash, "an empty hash must not wipe the password")
+
+		byGoogle, err := repo.GetUserByGoogleID(ctx, "google-1")
+		require.NoError(t, err)
+		assert.Equal(t, u.ID, byGoogle.ID)
+	})
+
+	t.Run("password accounts have no google id", func(t *testing.T) {
+		repo := newRepo(t)
+		_, err := repo.UpsertUser(ctx, newUser("one@example.com"))
+		require.NoError(t, err)
+		_, err = repo.UpsertUser(ctx, newUser("two@example.com"))
+		require.NoError(t, err)
+
+		_, err = repo.GetUserByGoogleID(ctx, "")
+		assert.ErrorIs(t, err, auth.ErrUserNotFound)
+	})
+
+	t.Run("sessions can be looked up and deleted", func(t *testing.T) {
+		repo := newRepo(t)
+		u := newUser("session@example.com")
+		_, err := repo.UpsertUser(ctx, u)
+		require.NoError(t, err)
+
+		session := &auth.Session{
+			ID:        uuid.New().String(),
+			UserID:    u.ID,
+			Token:     uuid.New().String(),
+			ExpiresAt: contractTime(24 * time.Hour),
+		}
+		_, err = repo.CreateSession(ctx, session)
+		require.NoError(t, err)
+
+		got, err := repo.GetSessionByToken(ctx, session.Token)
+		require.NoError(t, err)
+		assert.Equal(t, u.ID, got.UserID)
+		assert.True(t, session.ExpiresAt.Equal(got.ExpiresAt))
+
+		require.NoError(t, repo.DeleteSession(ctx, session.Token))
+		require.NoError(t, repo.DeleteSession(ctx, session.Token))
+		_, err = repo.GetSessionByToken(ctx, session.Token)
+		assert.ErrorIs(t, err, auth.ErrSessionNotFound)
+	})
+}
+
+func testUserRepositoryContract(t *testing.T, newRepo func(t *testing.T) user.Repository) {
+	ctx := context.Background()
+
+	t.Run("created users get an id", func(t *testing.T) {
+		repo := newRepo(t)
+		created, err := repo.CreateUser(ctx, &user.User{Username: "alice", Email: "alice@example.com", Password: "hash"})
+		require.NoError(t, err)
+		assert.NotEmpty(t, created.ID)
+
+		got, err := repo.GetUserByEmail(ctx, "alice@example.com")
+		require.NoError(t, err)
+		assert.Equal(t, created.ID, got.ID)
+		assert.Equal(t, "alice", got.Username)
+		assert.Equal(t, "hash", got.Password)
+	})
+
+	t.Run("emails are unique", func(t *testing.T) {
+		repo := newRepo(t)
+		_, err := repo.CreateUser(ctx, &user.User{Username: "alice", Email: "alice@example.com", Password: "hash"})
+		require.NoError(t, err)
+		_, err = repo.CreateUser(ctx, &user.User{Username: "other", Email: "alice@example.com", Password: "hash"})
+		assert.ErrorIs(t, err, user.ErrEmailTaken)
+	})
+
+	t.Run("missing users are not found", func(t *testing.T) {
+		_, err := newRepo(t).GetUserByEmail(ctx, "nobody@example.com")
+		assert.ErrorIs(t, err, user.ErrUserNotFound)
+	})
+}
+
+func TestMessageRepositoryContract(t *testing.T) {
+	t.Run("memory", func(t *testing.T) {
+		testMessageRepositoryContract(t, func(t *testing.T) message.Repository {
+			return message.NewMemoryRepository()
+		})
+	})
+	t.Run("sqlite", func(t *testing.T) {
+		testMessageRepositoryContract(t, func(t *testing.T) message.Repository {
+			return message.NewSQLiteRepository(SetupSQLiteDB(t))
+		})
+	})
+	t.Run("postgres", func(t *testing.T) {
+		testMessageRepositoryContract(t, func(t *testing.T) message.Repository {
+			return message.NewPostgresRepository(SetupTestDB(t))
+		})
+	})
+	t.Run("pgx", func(t *testing.T) {
+		testMessageRepositoryContract(t, func(t *testing.T) message.Repository {
+			return message.NewPgxRepository(SetupPgxPool(t))
+		})
+	})
+}
+
+func TestAuthRepositoryContract(t *testing.T) {
+	t.Run("memory", func(t *testing.T) {
+		testAuthRepositoryContract(t, func(t *testing.T) auth.Repository {
+			return auth.NewMemoryRepository()
+		})
+	})
+	t.Run("sqlite", func(t *testing.T) {
+		testAuthRepositoryContract(t, func(t *testing.T) auth.Repository {
+			return auth.NewSQLiteRepository(SetupSQLiteDB(t))
+		})
+	})
+	t.Run("postgres", func(t *testing.T) {
+		testAuthRepositoryContract(t, func(t *testing.T) auth.Repository {
+			return auth.NewRepository(SetupTestDB(t))
+		})
+	})
+}
+
+func TestUserRepositoryContract(t *testing.T) {
+	t.Run("memory", func(t *testing.T) {
+		testUserRepositoryContract(t, func(t *testing.T) user.Repository {
+			return user.NewMemoryRepository()
+		})
+	})
+	t.Run("sqlite", func(t *testing.T) {
+		testUserRepositoryContract(t, func(t *testing.T) user.Repository {
+			return user.NewRepository(SetupSQLiteDB(t))
+		})
+	})
+	t.Run("postgres", func(t *testing.T) {
+		testUserRepositoryContract(t, func(t *testing.T) user.Repository {
+			return user.NewRepository(SetupTestDB(t))
+		})
+	})
+}
+
+func testDeadLetterRepositoryContract(t *testing.T, newRepo func(t *testing.T) message.DeadLetterRepository) {
+	ctx := context.Background()
+
+	newMessage := func(content string) *message.Message {
+		return &message.Message{
+			ID:        uuid.New().String(),
+			RoomID:    "room-1",
+			Content:   content,
+			Type:      "message",
+			Timestamp: contractTime(0),
+		}
+	}
+
+	t.Run("recording twice counts attempts", func(t *testing.T) {
+		repo := newRepo(t)
+		msg := newMessage("bad")
+		require.NoError(t, repo.RecordDeadLetter(ctx, msg, "first failure"))
+		require.NoError(t, repo.RecordDeadLetter(ctx, msg, "second failure"))
+
+		deadLetter, err := repo.GetDeadLetter(ctx, msg.ID)
+		require.NoError(t, err)
+		assert.Equal(t, 2, deadLetter.Attempts)
+		assert.Equal(t, "second failure", deadLetter.Error)
+		assert.Equal(t, "bad", deadLetter.Message.Content)
+		assert.False(t, deadLetter.LastFailedAt.Before(deadLetter.FirstFailedAt))
+	})
+
+	t.Run("dead letters can be edited and discarded", func(t *testing.T) {
+		repo := newRepo(t)
+		msg := newMessage("bad")
+		require.NoError(t, repo.RecordDeadLetter(ctx, msg, "failure"))
+
+		fixed := *msg
+		fixed.Content = "fixed"
+		require.NoError(t, repo.UpdateDeadLetter(ctx, &fixed))
+
+		deadLetters, err := repo.ListDeadLetters(ctx, 10, 0)
+		require.NoError(t, err)
+		require.Len(t, deadLetters, 1)
+		assert.Equal(t, "fixed", deadLetters[0].Message.Content)
+
+		require.NoError(t, repo.DeleteDeadLetter(ctx, msg.ID))
+		assert.ErrorIs(t, repo.DeleteDeadLetter(ctx, msg.ID), message.ErrDeadLetterNotFound)
+		assert.ErrorIs(t, repo.UpdateDeadLetter(ctx, &fixed), message.ErrDeadLetterNotFound)
+		_, err = repo.GetDeadLetter(ctx, msg.ID)
+		assert.ErrorIs(t, err, message.ErrDeadLetterNotFound)
+	})
+}
+
+func TestDeadLetterRepositoryContract(t *testing.T) {
+	t.Run("sqlite", func(t *testing.T) {
+		testDeadLetterRepositoryContract(t, func(t *testing.T) message.DeadLetterRepository {
+			return message.NewSQLiteDeadLetterRepository(SetupSQLiteDB(t))
+		})
+	})
+	t.Run("postgres", func(t *testing.T) {
+		testDeadLetterRepositoryContract(t, func(t *testing.T) message.DeadLetterRepository {
+			return message.NewPostgresDeadLetterRepository(SetupTestDB(t))
+		})
+	})
+}
@@ -0,0 +1,331 @@
+package testing
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/lib/pq"
+	"github.com/sony/gobreaker"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"server/internal/apperr"
+	"server/internal/message"
+	"server/internal/ws"
+)
+
+// unavailableService fails every call as if the database were down
+type unavailableService struct {
+	message.Service
+}
+
+var errDatabaseDown = errors.New("database is down")
+
+func (unavailableService) GetRooms(ctx context.Context) ([]*message.Room, error) {
+	return nil, errDatabaseDown
+}
+
+func (unavailableService) GetMessagesByRoom(ctx context.Context, roomID string, limit, offset int) ([]*message.Message, error) {
+	return nil, errDatabaseDown
+}
+
+func (unavailableService) SaveMessage(ctx context.Context, msg *message.Message) error {
+	return errDatabaseDown
+}
+
+func newTrippingService(cache message.Cache) *message.ResilientService {
+	svc := message.NewResilientService(unavailableService{}, message.CircuitBreakerConfig{
+		Name:    "test",
+		Timeout: time.Minute,
+		ReadyToTrip: func(counts gobreaker.Counts) bool {
+			return counts.ConsecutiveFailures >= 1
+		},
+	}, message.RetryConfig{
+		MaxElapsedTime:  time.Second,
+		MaxInterval:     100 * time.Millisecond,
+		InitialInterval: 100 * time.Millisecond,
+	})
+	svc.UseStaleCache(cache)
+	return svc
+}
+
+func TestStaleReadsWhileBreakerOpen(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	ctx := context.Background()
+
+	cache := message.NewMemoryCache(100)
+	require.NoError(t, cache.CacheRoomList(ctx, []*message.Room{{ID: "room", Name: "room"}}))
+	svc := newTrippingService(cache)
+
+	_, err := svc.GetMessagesByRoom(ctx, "room", 10, 0)
+	require.Error(t, err)
+	require.Equal(t, gobreaker.StateOpen, svc.MessageBreakerState())
+	_, err = svc.GetRooms(message.WithStaleTracking(ctx))
+	require.NoError(t, err, "the first failure trips the room breaker and serves the cached list")
+	require.Equal(t, gobreaker.StateOpen, svc.RoomBreakerState())
+
+	t.Run("reads are served from the cache and flagged", func(t *testing.T) {
+		r := gin.New()
+		r.Use(apperr.Middleware())
+		r.GET("/rooms", message.NewHandler(svc).GetRooms)
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/rooms", nil))
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "true", w.Header().Get(message.StaleHeader))
+
+		var body struct {
+			Rooms []message.Room `json:"rooms"`
+			Stale bool           `json:"stale"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+		assert.True(t, body.Stale)
+		assert.Equal(t, "room", body.Rooms[0].ID)
+	})
+
+	t.Run("uncached reads still fail", func(t *testing.T) {
+		_, err := svc.GetMessagesByRoom(ctx, "room", 10, 0)
+		assert.ErrorIs(t, err, gobreaker.ErrOpenState)
+	})
+
+	t.Run("writes fail fast", func(t *testing.T) {
+		start := time.Now()
+		err := svc.SaveMessage(ctx, &message.Message{RoomID: "room", Content: "hello"})
+		assert.ErrorIs(t, err, gobreaker.ErrOpenState)
+		assert.Less(t, time.Since(start), 50*time.Millisecond, "an open breaker should not be retried")
+	})
+
+	t.Run("fresh reads are not flagged", func(t *testing.T) {
+		ctx := message.WithStaleTracking(ctx)
+		_, err := message.NewService(NewMockMessageRepository(), cache).GetRooms(ctx)
+		require.NoError(t, err)
+		assert.False(t, message.IsStale(ctx))
+	})
+}
+
+// countingService fails message and room lookups with a fixed error and counts attempts
+type countingService struct {
+	message.Service
+	err      error
+	attempts int32
+}
+
+func (s *countingService) GetMessageByID(ctx context.Context, id string) (*message.Message, error) {
+	atomic.AddInt32(&s.attempts, 1)
+	return nil, s.err
+}
+
+func (s *countingService) GetRoomByID(ctx context.Context, id string) (*message.Room, error) {
+	atomic.AddInt32(&s.attempts, 1)
+	return nil, s.err
+}
+
+func newCountingResilientService(inner *countingService) *message.ResilientService {
+	return message.NewResilientService(inner, message.CircuitBreakerConfig{
+		Name: "test",
+		ReadyToTrip: func(counts gobreaker.Counts) bool {
+			return counts.ConsecutiveFailures >= 3
+		},
+	}, message.RetryConfig{
+		MaxElapsedTime:  time.Second,
+		MaxInterval:     time.Millisecond,
+		InitialInterval: time.Millisecond,
+	})
+}
+
+func TestRetryClassification(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("classifies errors", func(t *testing.T) {
+		assert.False(t, message.IsRetryable(sql.ErrNoRows))
+		assert.False(t, message.IsRetryable(&pq.Error{Code: "23505"}))
+		assert.False(t, message.IsRetryable(&pq.Error{Code: "42P01"}))
+		assert.False(t, message.IsRetryable(context.Canceled))
+		assert.False(t, message.IsRetryable(gobreaker.ErrOpenState))
+		assert.True(t, message.IsRetryable(&pq.Error{Code: "08006"}))
+		assert.True(t, message.IsRetryable(&pq.Error{Code: "4"}), "codes without a class are retried like other errors")
+		assert.True(t, message.IsRetryable(errDatabaseDown))
+	})
+
+	t.Run("permanent errors are returned at once and do not trip the breaker", func(t *testing.T) {
+		inner := &countingService{err: sql.ErrNoRows}
+		svc := newCountingResilientService(inner)
+
+		for i := 0; i < 5; i++ {
+			_, err := svc.GetMessageByID(ctx, "missing")
+			assert.ErrorIs(t, err, sql.ErrNoRows)
+		}
+
+		assert.Equal(t, int32(5), atomic.LoadInt32(&inner.attempts))
+		assert.Equal(t, gobreaker.StateClosed, svc.MessageBreakerState())
+	})
+
+	t.Run("retry budgets are configurable per operation", func(t *testing.T) {
+		inner := &countingService{err: errDatabaseDown}
+		svc := newCountingResilientService(inner)
+		svc.UseRetryConfig(message.OpGetRoomByID, message.RetryConfig{
+			MaxElapsedTime:  time.Second,
+			MaxInterval:     time.Millisecond,
+			InitialInterval: time.Millisecond,
+			MaxRetries:      1,
+		})
+
+		_, err := svc.GetRoomByID(ctx, "room")
+		assert.ErrorIs(t, err, errDatabaseDown)
+		assert.Equal(t, int32(2), atomic.LoadInt32(&inner.attempts), "one attempt plus one retry")
+	})
+}
+
+// blockingHistoryService holds history queries until released or cancelled
+type blockingHistoryService struct {
+	message.Service
+	started chan struct{}
+	release chan struct{}
+	saved   int32
+}
+
+func (s *blockingHistoryService) GetMessagesByRoom(ctx context.Context, roomID string, limit, offset int) ([]*message.Message, error) {
+	s.started <- struct{}{}
+	select {
+	case <-s.release:
+		return []*message.Message{}, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (s *blockingHistoryService) SaveMessage(ctx context.Context, msg *message.Message) error {
+	atomic.AddInt32(&s.saved, 1)
+	return nil
+}
+
+func TestBulkheads(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	ctx := context.Background()
+
+	inner := &blockingHistoryService{started: make(chan struct{}, 10), release: make(chan struct{})}
+	svc := message.NewResilientService(inner, message.CircuitBreakerConfig{Name: "test"}, message.RetryConfig{
+		MaxElapsedTime:  time.Second,
+		MaxInterval:     time.Millisecond,
+		InitialInterval: time.Millisecond,
+	})
+	svc.UseBulkhead(message.GroupHistory, message.BulkheadConfig{MaxConcurrent: 2})
+	svc.UseBulkhead(message.GroupWrites, message.BulkheadConfig{MaxConcurrent: 2})
+
+	done := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			_, err := svc.GetMessagesByRoom(ctx, "room", 10, 0)
+			done <- err
+		}()
+		<-inner.started
+	}
+	assert.Equal(t, 2, svc.Bulkhead(message.GroupHistory).InFlight())
+
+	t.Run("a full group rejects further calls", func(t *testing.T) {
+		_, err := svc.GetMessagesByRoom(ctx, "room", 10, 0)
+		assert.ErrorIs(t, err, message.ErrOverloaded)
+	})
+
+	t.Run("rejections map to 503", func(t *testing.T) {
+		r := gin.New()
+		r.Use(apperr.Middleware())
+		r.GET("/rooms/:roomId/messages", message.NewHandler(svc).GetMessages)
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/rooms/room/messages", nil))
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	})
+
+	t.Run("other groups are unaffected", func(t *testing.T) {
+		require.NoError(t, svc.SaveMessage(ctx, &message.Message{RoomID: "room"}))
+		assert.Equal(t, int32(1), atomic.LoadInt32(&inner.saved))
+	})
+
+	close(inner.release)
+	for i := 0; i < 2; i++ {
+		assert.NoError(t, <-done)
+	}
+	assert.Equal(t, 0, svc.Bulkhead(message.GroupHistory).InFlight())
+
+	t.Run("calls are cut off at the group deadline", func(t *testing.T) {
+		inner := &blockingHistoryService{started: make(chan struct{}, 10), release: make(chan struct{})}
+		svc := message.NewResilientService(inner, message.CircuitBreakerConfig{Name: "test"}, message.RetryConfig{
+			MaxElapsedTime:  time.Second,
+			MaxInterval:     time.Millisecond,
+			InitialInterval: time.Millisecond,
+		})
+		svc.UseBulkhead(message.GroupHistory, message.BulkheadConfig{Timeout: 20 * time.Millisecond})
+
+		start := time.Now()
+		_, err := svc.GetMessagesByRoom(ctx, "room", 10, 0)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.Less(t, time.Since(start), 500*time.Millisecond)
+	})
+}
+
+// overloadedWriteService turns every batch away as if the write bulkhead
+// were full
+type overloadedWriteService struct {
+	message.Service
+}
+
+func (overloadedWriteService) SaveMessages(ctx context.Context, messages []*message.Message) error {
+	return message.ErrOverloaded
+}
+
+func (overloadedWriteService) UpdateRoomActivity(ctx context.Context, roomID string) error {
+	return nil
+}
+
+func TestOverloadedWriteBehindErrorFrame(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	wb := message.NewWriteBehind(overloadedWriteService{}, message.WriteBehindConfig{
+		QueueSize:     10,
+		BatchSize:     1,
+		FlushInterval: time.Minute,
+	})
+	go wb.Run()
+	defer wb.Close()
+
+	require.Eventually(t, func() bool {
+		return errors.Is(wb.Enqueue(&message.Message{RoomID: "room", Type: "message"}), message.ErrOverloaded)
+	}, time.Second, 5*time.Millisecond, "a rejected flush turns further messages away")
+
+	hub := ws.NewHub()
+	hub.Rooms["room"] = &ws.Room{ID: "room", Clients: make(map[string]*ws.Client)}
+	go hub.Run()
+
+	r := gin.New()
+	r.GET("/ws/:roomId", ws.NewHandler(hub, ws.NewWriteBehindAdapter(overloadedWriteService{}, wb)).JoinRoom)
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/ws/room?userId=u1&username=alice", nil)
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+
+	require.NoError(t, conn.WriteJSON(map[string]string{"type": "chat", "content": "hello"}))
+	for {
+		var received map[string]interface{}
+		require.NoError(t, conn.ReadJSON(&received))
+		assert.NotEqual(t, "hello", received["content"], "an overloaded message is not fanned out")
+		if received["type"] == "error" {
+			assert.Equal(t, ws.ErrorCodeOverloaded, received["code"])
+			break
+		}
+	}
+}
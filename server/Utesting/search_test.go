@@ -0,0 +1,213 @@
+package testing
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"server/internal/apperr"
+	"server/internal/auth"
+	"server/internal/message"
+	"server/internal/ws"
+)
+
+func TestSearchMessagesHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	ctx := context.Background()
+
+	authSvc := auth.NewService(auth.NewMemoryRepository())
+	bob, err := authSvc.Signup(ctx, &auth.SignupRequest{Email: "bob@example.com", Name: "bob", Password: "password"})
+	require.NoError(t, err)
+	session, err := authSvc.CreateSession(ctx, bob.ID)
+	require.NoError(t, err)
+	otherBob, err := authSvc.Signup(ctx, &auth.SignupRequest{Email: "bob@example.org", Name: "bob", Password: "password"})
+	require.NoError(t, err)
+	otherSession, err := authSvc.CreateSession(ctx, otherBob.ID)
+	require.NoError(t, err)
+
+	svc := message.NewService(message.NewMemoryRepository(), nil)
+	_, err = svc.CreateRoom(ctx, "random", "Random", bob.ID, message.RoomCapacity{})
+	require.NoError(t, err)
+	at := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	for i, msg := range []*message.Message{
+		{RoomID: "general", UserID: bob.ID, Username: "bob", Content: "A new user has joined the room"},
+		{RoomID: "general", UserID: otherBob.ID, Username: "bob", Content: "A new user has joined the room"},
+		{RoomID: "general", Username: "alice", Content: "standup moved to ten"},
+		{RoomID: "random", Username: "alice", Content: "standup snacks"},
+		{RoomID: "general", Username: "alice", Content: "standup notes for bob", Recipient: "bob", RecipientID: bob.ID},
+		{RoomID: "general", Username: "alice", Content: "standup notes for carol", Recipient: "carol", RecipientID: "carol-id"},
+		{RoomID: "secret", Username: "bob", Content: "A new user has joined the room"},
+		{RoomID: "secret", Username: "alice", Content: "standup without bob"},
+	} {
+		msg.Type = "message"
+		msg.Timestamp = at.Add(time.Duration(i) * time.Minute)
+		require.NoError(t, svc.SaveMessage(ctx, msg))
+	}
+
+	authHandler := auth.NewHandler(authSvc, auth.OAuthConfig{})
+	messageHandler := message.NewHandler(svc)
+	r := gin.New()
+	r.Use(apperr.Middleware())
+	r.GET("/search", authHandler.RequireSession, messageHandler.SearchMessages)
+	r.GET("/room/:roomId/search", authHandler.RequireSession, messageHandler.SearchMessages)
+
+	searchAs := func(t *testing.T, target string, session *auth.Session) (int, message.SearchPage) {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		if session != nil {
+			req.AddCookie(&http.Cookie{Name: "session_token", Value: session.Token})
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		var page message.SearchPage
+		if w.Code == http.StatusOK {
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &page))
+		}
+		return w.Code, page
+	}
+	search := func(t *testing.T, target string, signedIn bool) (int, message.SearchPage) {
+		if signedIn {
+			return searchAs(t, target, session)
+		}
+		return searchAs(t, target, nil)
+	}
+	contents := func(page message.SearchPage) []string {
+		var contents []string
+		for _, result := range page.Results {
+			contents = append(contents, result.Message.Content)
+		}
+		return contents
+	}
+
+	t.Run("searching requires a session", func(t *testing.T) {
+		code, _ := search(t, "/search?q=standup", false)
+		assert.Equal(t, http.StatusUnauthorized, code)
+	})
+
+	t.Run("only rooms the user owns or joined are searched", func(t *testing.T) {
+		code, page := search(t, "/search?q=standup", true)
+		require.Equal(t, http.StatusOK, code)
+		assert.ElementsMatch(t, []string{"standup moved to ten", "standup snacks", "standup notes for bob"}, contents(page))
+
+		code, page = search(t, "/room/secret/search?q=standup", true)
+		require.Equal(t, http.StatusOK, code)
+		assert.Empty(t, page.Results, "a message posted under bob's name is not bob joining")
+	})
+
+	t.Run("another account with the same name sees none of it", func(t *testing.T) {
+		code, page := searchAs(t, "/search?q=standup", otherSession)
+		require.Equal(t, http.StatusOK, code)
+		assert.Equal(t, []string{"standup moved to ten"}, contents(page))
+	})
+
+	t.Run("searches can be limited to one room", func(t *testing.T) {
+		_, page := search(t, "/room/random/search?q=standup", true)
+		assert.Equal(t, []string{"standup snacks"}, contents(page))
+	})
+
+	t.Run("results are paged with a cursor", func(t *testing.T) {
+		_, first := search(t, "/search?q=standup&limit=2", true)
+		require.Len(t, first.Results, 2)
+		require.NotEmpty(t, first.NextCursor)
+
+		_, second := search(t, "/search?q=standup&limit=2&cursor="+first.NextCursor, true)
+		assert.Len(t, second.Results, 1)
+		assert.Empty(t, second.NextCursor)
+		assert.ElementsMatch(t, []string{"standup moved to ten", "standup snacks", "standup notes for bob"}, append(contents(first), contents(second)...))
+	})
+
+	t.Run("bad requests are rejected", func(t *testing.T) {
+		for _, target := range []string{"/search", "/search?q=standup&since=yesterday", "/search?q=standup&cursor=nope"} {
+			code, _ := search(t, target, true)
+			assert.Equal(t, http.StatusBadRequest, code, target)
+		}
+	})
+}
+
+func TestSQLiteSearchFollowsEdits(t *testing.T) {
+	ctx := context.Background()
+	conn := SetupSQLiteDB(t)
+	repo := message.NewSQLiteRepository(conn)
+
+	msg := &message.Message{ID: "msg-1", RoomID: "room", UserID: "alice-id", Username: "alice", Content: "meet at noon", Type: "message", Timestamp: contractTime(0)}
+	require.NoError(t, repo.SaveMessage(ctx, msg))
+
+	_, err := conn.ExecContext(ctx, `UPDATE messages SET content = 'meet at midnight' WHERE id = ?`, msg.ID)
+	require.NoError(t, err)
+
+	results, err := repo.SearchMessages(ctx, message.SearchQuery{Text: "noon", ViewerID: "alice-id", Limit: 10})
+	require.NoError(t, err)
+	assert.Empty(t, results)
+
+	results, err = repo.SearchMessages(ctx, message.SearchQuery{Text: "midnight", ViewerID: "alice-id", Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Equal(t, "meet at <mark>midnight</mark>", results[0].Snippet)
+}
+
+func TestSearchAccessFollowsTheJoiningSession(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	ctx := context.Background()
+
+	authSvc := auth.NewService(auth.NewMemoryRepository())
+	bob, err := authSvc.Signup(ctx, &auth.SignupRequest{Email: "bob@example.com", Name: "bob", Password: "password"})
+	require.NoError(t, err)
+	session, err := authSvc.CreateSession(ctx, bob.ID)
+	require.NoError(t, err)
+
+	svc := message.NewService(message.NewMemoryRepository(), nil)
+	hub := ws.NewHub()
+	hub.Rooms["room"] = &ws.Room{ID: "room", Clients: make(map[string]*ws.Client)}
+	go hub.Run()
+
+	router := gin.New()
+	router.Use(apperr.Middleware())
+	router.GET("/ws/joinRoom/:roomId", auth.NewHandler(authSvc, auth.OAuthConfig{}).OptionalSession, ws.NewHandler(hub, ws.NewMessageServiceAdapter(svc)).JoinRoom)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	join := func(username string, header http.Header) *websocket.Conn {
+		conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/ws/joinRoom/room?userId=c-"+username+"&username="+username, header)
+		require.NoError(t, err)
+		return conn
+	}
+	say := func(conn *websocket.Conn, msg map[string]string) {
+		require.NoError(t, conn.WriteJSON(msg))
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		for {
+			var received map[string]interface{}
+			require.NoError(t, conn.ReadJSON(&received))
+			if received["content"] == msg["content"] {
+				return
+			}
+		}
+	}
+	searchAs := func(viewerID string) []string {
+		page, err := svc.SearchMessages(ctx, message.SearchQuery{Text: "deploy", ViewerID: viewerID, Limit: 10})
+		require.NoError(t, err)
+		var contents []string
+		for _, result := range page.Results {
+			contents = append(contents, result.Message.Content)
+		}
+		return contents
+	}
+
+	impostor := join("bob", nil)
+	defer impostor.Close()
+	say(impostor, map[string]string{"content": "deploy at noon", "userId": bob.ID, "username": "bob"})
+	assert.Empty(t, searchAs(bob.ID), "neither the name nor a claimed user ID makes bob a member")
+
+	signedIn := join("bobby", http.Header{"Cookie": {"session_token=" + session.Token}})
+	defer signedIn.Close()
+	say(signedIn, map[string]string{"content": "deploy after lunch"})
+	assert.ElementsMatch(t, []string{"deploy at noon", "deploy after lunch"}, searchAs(bob.ID))
+}
@@ -0,0 +1,93 @@
+package testing
+
+import (
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"server/internal/ws"
+)
+
+func TestShardedHub(t *testing.T) {
+	hub := ws.NewShardedHub(4)
+	assert.Len(t, hub.Shards(), 4)
+
+	t.Run("routes a room to the same shard", func(t *testing.T) {
+		assert.Same(t, hub.ShardFor("room-1"), hub.ShardFor("room-1"))
+	})
+
+	t.Run("spreads rooms across shards", func(t *testing.T) {
+		used := make(map[*ws.Hub]bool)
+		for i := 0; i < 100; i++ {
+			used[hub.ShardFor(fmt.Sprintf("room-%d", i))] = true
+		}
+		assert.Len(t, used, 4)
+	})
+
+	t.Run("single hub is its own shard", func(t *testing.T) {
+		single := ws.NewHub()
+		assert.Same(t, single, single.ShardFor("any"))
+		assert.Equal(t, []*ws.Hub{single}, single.Shards())
+	})
+}
+
+func TestShardedHubKeepsMessagesInTheJoinedRoom(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	hub := ws.NewShardedHub(2)
+
+	// Find two rooms owned by different shards
+	home, away := "room-0", ""
+	for i := 1; away == ""; i++ {
+		if room := fmt.Sprintf("room-%d", i); hub.ShardFor(room) != hub.ShardFor(home) {
+			away = room
+		}
+	}
+	for _, room := range []string{home, away} {
+		hub.ShardFor(room).Rooms[room] = &ws.Room{ID: room, Clients: make(map[string]*ws.Client)}
+	}
+	go hub.Run()
+
+	router := gin.New()
+	router.GET("/ws/joinRoom/:roomId", ws.NewHandler(hub, nil).JoinRoom)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	join := func(room, username string) *websocket.Conn {
+		conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/ws/joinRoom/"+room+"?userId="+username+"&username="+username, nil)
+		require.NoError(t, err)
+		return conn
+	}
+	alice := join(home, "alice")
+	defer alice.Close()
+	bob := join(away, "bob")
+	defer bob.Close()
+	require.NoError(t, hub.Ping(context.Background()))
+
+	require.NoError(t, alice.WriteJSON(map[string]string{"content": "hello", "roomId": away}))
+	alice.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		var received ws.Message
+		require.NoError(t, alice.ReadJSON(&received))
+		if received.Content == "hello" {
+			assert.Equal(t, home, received.RoomID, "the message stays in the room alice joined")
+			break
+		}
+	}
+
+	bob.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	for {
+		var received ws.Message
+		if err := bob.ReadJSON(&received); err != nil {
+			break
+		}
+		assert.NotEqual(t, "hello", received.Content, "clients can't post into rooms they did not join")
+	}
+}
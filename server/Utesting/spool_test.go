@@ -0,0 +1,66 @@
+package testing
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"server/internal/message"
+)
+
+func TestSpool(t *testing.T) {
+	dir := t.TempDir()
+
+	spool, err := message.NewSpool(dir)
+	assert.NoError(t, err)
+
+	err = spool.Append(
+		&message.Message{ID: "1", RoomID: "room", Content: "first"},
+		&message.Message{ID: "2", RoomID: "room", Content: "second"},
+		&message.Message{ID: "1", RoomID: "room", Content: "first"},
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, spool.Depth())
+
+	t.Run("survives a restart", func(t *testing.T) {
+		assert.NoError(t, spool.Close())
+
+		spool, err = message.NewSpool(dir)
+		assert.NoError(t, err)
+		assert.Equal(t, 3, spool.Depth())
+	})
+
+	t.Run("failed replay keeps messages", func(t *testing.T) {
+		_, err := spool.Replay(10, func([]*message.Message) error {
+			return errors.New("database unavailable")
+		})
+		assert.Error(t, err)
+		assert.Equal(t, 3, spool.Depth())
+	})
+
+	t.Run("replay deduplicates and drains", func(t *testing.T) {
+		assert.NoError(t, spool.Append(&message.Message{ID: "3", RoomID: "room", Content: "third"}))
+
+		var replayed []*message.Message
+		replay := func(batch []*message.Message) error {
+			replayed = append(replayed, batch...)
+			return nil
+		}
+
+		n, err := spool.Replay(10, replay)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, n)
+
+		n, err = spool.Replay(10, replay)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, n)
+
+		assert.Equal(t, 0, spool.Depth())
+		assert.Len(t, replayed, 3)
+		assert.Equal(t, "first", replayed[0].Content)
+		assert.Equal(t, "third", replayed[2].Content)
+	})
+
+	assert.NoError(t, spool.Close())
+}
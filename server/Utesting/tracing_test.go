@@ -0,0 +1,273 @@
+package testing
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+
+	"server/internal/message"
+	"server/internal/tracing"
+	"server/internal/ws"
+)
+
+var (
+	spanExporter     *tracetest.InMemoryExporter
+	spanExporterOnce sync.Once
+)
+
+// recordSpans installs a tracer provider that keeps finished spans in memory
+// and forgets the spans of earlier tests. The global provider can only be
+// set once, since tracers created before hand over to the first one.
+func recordSpans(t *testing.T) *tracetest.InMemoryExporter {
+	spanExporterOnce.Do(func() {
+		spanExporter = tracetest.NewInMemoryExporter()
+		otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(spanExporter)))
+	})
+	spanExporter.Reset()
+	return spanExporter
+}
+
+// spansNamed returns the finished spans with the given name
+func spansNamed(exporter *tracetest.InMemoryExporter, name string) []tracetest.SpanStub {
+	var spans []tracetest.SpanStub
+	for _, span := range exporter.GetSpans() {
+		if span.Name == name {
+			spans = append(spans, span)
+		}
+	}
+	return spans
+}
+
+func spanAttribute(span tracetest.SpanStub, key attribute.Key) attribute.Value {
+	for _, attr := range span.Attributes {
+		if attr.Key == key {
+			return attr.Value
+		}
+	}
+	return attribute.Value{}
+}
+
+func TestTracingRetries(t *testing.T) {
+	exporter := recordSpans(t)
+
+	inner := &countingService{err: errDatabaseDown}
+	svc := newCountingResilientService(inner)
+	svc.UseRetryConfig(message.OpGetRoomByID, message.RetryConfig{
+		MaxElapsedTime:  time.Second,
+		MaxInterval:     time.Millisecond,
+		InitialInterval: time.Millisecond,
+		MaxRetries:      2,
+	})
+
+	_, err := svc.GetRoomByID(context.Background(), "room")
+	require.Error(t, err)
+
+	spans := spansNamed(exporter, "message.Resilient/GetRoomByID")
+	require.Len(t, spans, 1)
+	span := spans[0]
+	assert.Equal(t, codes.Error, span.Status.Code)
+	assert.Equal(t, int64(3), spanAttribute(span, "chat.attempts").AsInt64())
+
+	var retries int
+	for _, event := range span.Events {
+		if event.Name == "retry" {
+			retries++
+		}
+	}
+	assert.Equal(t, 2, retries, "the first attempt is not a retry")
+
+	attempts := spansNamed(exporter, "message.Resilient/attempt")
+	require.Len(t, attempts, 3)
+	for _, attempt := range attempts {
+		assert.Equal(t, span.SpanContext.SpanID(), attempt.Parent.SpanID())
+	}
+}
+
+func TestTracingWriteBehindLinksMessages(t *testing.T) {
+	exporter := recordSpans(t)
+
+	svc := &batchRecordingService{}
+	wb := message.NewWriteBehind(svc, message.WriteBehindConfig{
+		QueueSize:     10,
+		BatchSize:     2,
+		FlushInterval: time.Hour,
+		FlushTimeout:  time.Second,
+		HighWatermark: 1,
+		LowWatermark:  0,
+	})
+	go wb.Run()
+
+	var received []trace.SpanContext
+	for i := 0; i < 2; i++ {
+		_, span := otel.Tracer("test").Start(context.Background(), "ws.receive")
+		require.NoError(t, wb.Enqueue(&message.Message{RoomID: "room", Content: "hi", Type: "message", Trace: span.SpanContext()}))
+		span.End()
+		received = append(received, span.SpanContext())
+	}
+	wb.Close()
+
+	flushes := spansNamed(exporter, "message.WriteBehind/flush")
+	require.Len(t, flushes, 1)
+	flush := flushes[0]
+	assert.False(t, flush.Parent.IsValid(), "a batch of many traces starts its own")
+	require.Len(t, flush.Links, 2)
+	for i, link := range flush.Links {
+		assert.Equal(t, received[i], link.SpanContext)
+	}
+}
+
+func TestTracingFollowsMessageFromSendToFanout(t *testing.T) {
+	exporter := recordSpans(t)
+	gin.SetMode(gin.TestMode)
+	ctx := context.Background()
+
+	svc := message.NewTracedService(message.NewService(message.NewSQLiteRepository(SetupSQLiteDB(t)), message.NewMemoryCache(100)))
+	_, err := svc.CreateRoom(ctx, "room", "Room", "owner", message.RoomCapacity{})
+	require.NoError(t, err)
+
+	hub := ws.NewHub()
+	hub.Rooms["room"] = &ws.Room{ID: "room", Clients: make(map[string]*ws.Client)}
+	go hub.Run()
+
+	router := gin.New()
+	router.GET("/ws/:roomId", ws.NewHandler(hub, ws.NewMessageServiceAdapter(svc)).JoinRoom)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/ws/room?userId=u1&username=alice", nil)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	require.NoError(t, conn.WriteJSON(map[string]string{"type": "chat", "content": "hello"}))
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		var received map[string]interface{}
+		require.NoError(t, conn.ReadJSON(&received))
+		if received["content"] == "hello" {
+			break
+		}
+	}
+
+	var fanout tracetest.SpanStub
+	require.Eventually(t, func() bool {
+		spans := spansNamed(exporter, "hub.broadcast")
+		if len(spans) == 0 {
+			return false
+		}
+		fanout = spans[0]
+		return true
+	}, time.Second, 10*time.Millisecond)
+
+	receives := spansNamed(exporter, "ws.receive")
+	require.Len(t, receives, 1)
+	receive := receives[0]
+	traceID := receive.SpanContext.TraceID()
+	assert.Equal(t, "chat", spanAttribute(receive, "chat.message_type").AsString())
+
+	assert.Equal(t, traceID, fanout.SpanContext.TraceID())
+	assert.Equal(t, receive.SpanContext.SpanID(), fanout.Parent.SpanID())
+
+	var saves []tracetest.SpanStub
+	for _, span := range spansNamed(exporter, "message.Service/SaveMessage") {
+		if span.SpanContext.TraceID() == traceID {
+			saves = append(saves, span)
+		}
+	}
+	require.Len(t, saves, 1, "the join message is saved in a trace of its own")
+	assert.Equal(t, receive.SpanContext.SpanID(), saves[0].Parent.SpanID())
+
+	var queries int
+	for _, span := range exporter.GetSpans() {
+		if span.SpanContext.TraceID() == traceID && spanAttribute(span, "db.system").AsString() == "sqlite" {
+			queries++
+		}
+	}
+	assert.Positive(t, queries, "the SQL of the save belongs to the message's trace")
+}
+
+func TestTracingPrivateMessagesRecordDeliveredRecipients(t *testing.T) {
+	exporter := recordSpans(t)
+	gin.SetMode(gin.TestMode)
+
+	hub := ws.NewHub()
+	hub.Rooms["room"] = &ws.Room{ID: "room", Clients: make(map[string]*ws.Client)}
+	go hub.Run()
+
+	router := gin.New()
+	router.GET("/ws/:roomId", ws.NewHandler(hub, nil).JoinRoom)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	dial := func(id, username string) *websocket.Conn {
+		conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/ws/room?userId="+id+"&username="+username, nil)
+		require.NoError(t, err)
+		return conn
+	}
+	alice := dial("u1", "alice")
+	defer alice.Close()
+	bob := dial("u2", "bob")
+	defer bob.Close()
+	require.NoError(t, hub.Ping(context.Background()))
+
+	recipients := func(to string) int64 {
+		exporter.Reset()
+		require.NoError(t, alice.WriteJSON(map[string]string{"type": "private", "recipient": to, "content": "psst"}))
+		var span tracetest.SpanStub
+		require.Eventually(t, func() bool {
+			spans := spansNamed(exporter, "hub.private")
+			if len(spans) == 0 {
+				return false
+			}
+			span = spans[0]
+			return true
+		}, time.Second, 10*time.Millisecond)
+		return spanAttribute(span, "chat.recipients").AsInt64()
+	}
+
+	assert.Equal(t, int64(2), recipients("bob"), "the recipient and the sender")
+	assert.Equal(t, int64(0), recipients("carol"), "nobody when the recipient is not in the room")
+}
+
+func TestTracingRedisHook(t *testing.T) {
+	exporter := recordSpans(t)
+
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer client.Close()
+	client.AddHook(tracing.RedisHook{})
+
+	ctx, parent := otel.Tracer("test").Start(context.Background(), "parent")
+	require.NoError(t, client.Set(ctx, "key", "value", 0).Err())
+	require.ErrorIs(t, client.Get(ctx, "missing").Err(), redis.Nil)
+	_, err := client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Incr(ctx, "counter")
+		pipe.Incr(ctx, "counter")
+		return nil
+	})
+	require.NoError(t, err)
+	parent.End()
+
+	for _, name := range []string{"redis set", "redis get", "redis pipeline"} {
+		spans := spansNamed(exporter, name)
+		require.Len(t, spans, 1, name)
+		assert.Equal(t, parent.SpanContext().SpanID(), spans[0].Parent.SpanID(), name)
+		assert.Equal(t, codes.Unset, spans[0].Status.Code, "%s: a missing key is not an error", name)
+	}
+	assert.Equal(t, int64(2), spanAttribute(spansNamed(exporter, "redis pipeline")[0], "db.redis.pipeline_length").AsInt64())
+}
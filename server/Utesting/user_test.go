@@ -12,7 +12,7 @@ import (
 
 func TestUserRepository(t *testing.T) {
 
-	repo := NewMockAuthRepository()
+	repo := auth.NewMemoryRepository()
 
 	t.Run("create and get user", func(t *testing.T) {
 		hashedPassword, err := bcrypt.GenerateFromPassword([]byte("testpass"), bcrypt.DefaultCost)
@@ -81,6 +81,6 @@ func TestUserRepository(t *testing.T) {
 
 	t.Run("get non-existent user", func(t *testing.T) {
 		_, err := repo.GetUserByID(context.Background(), uuid.New().String())
-		assert.Nil(t, err)
+		assert.ErrorIs(t, err, auth.ErrUserNotFound)
 	})
 }
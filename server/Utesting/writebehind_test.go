@@ -0,0 +1,103 @@
+package testing
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"server/internal/message"
+)
+
+// batchRecordingService records the batches handed to SaveMessages
+type batchRecordingService struct {
+	message.Service
+	mu      sync.Mutex
+	batches [][]*message.Message
+	block   chan struct{}
+}
+
+func (s *batchRecordingService) SaveMessages(ctx context.Context, messages []*message.Message) error {
+	if s.block != nil {
+		<-s.block
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.batches = append(s.batches, messages)
+	return nil
+}
+
+func (s *batchRecordingService) saved() []*message.Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var all []*message.Message
+	for _, batch := range s.batches {
+		all = append(all, batch...)
+	}
+	return all
+}
+
+func TestWriteBehind(t *testing.T) {
+	t.Run("flushes in batches and preserves order", func(t *testing.T) {
+		svc := &batchRecordingService{}
+		wb := message.NewWriteBehind(svc, message.WriteBehindConfig{
+			QueueSize:     100,
+			BatchSize:     3,
+			FlushInterval: 10 * time.Millisecond,
+		})
+		go wb.Run()
+
+		for _, content := range []string{"1", "2", "3", "4", "5"} {
+			err := wb.Enqueue(&message.Message{RoomID: "room", Content: content})
+			assert.NoError(t, err)
+		}
+		wb.Close()
+
+		saved := svc.saved()
+		assert.Len(t, saved, 5)
+		for i, msg := range saved {
+			assert.Equal(t, string(rune('1'+i)), msg.Content)
+			assert.NotEmpty(t, msg.ID)
+			assert.False(t, msg.Timestamp.IsZero())
+		}
+		assert.Len(t, svc.batches[0], 3)
+	})
+
+	t.Run("rejects and signals backpressure when full", func(t *testing.T) {
+		svc := &batchRecordingService{block: make(chan struct{})}
+		wb := message.NewWriteBehind(svc, message.WriteBehindConfig{
+			QueueSize:     4,
+			BatchSize:     1,
+			FlushInterval: time.Hour,
+			HighWatermark: 0.75,
+			LowWatermark:  0.25,
+		})
+
+		var mu sync.Mutex
+		var signals []bool
+		wb.OnBackpressure(func(saturated bool) {
+			mu.Lock()
+			defer mu.Unlock()
+			signals = append(signals, saturated)
+		})
+
+		for i := 0; i < 4; i++ {
+			assert.NoError(t, wb.Enqueue(&message.Message{RoomID: "room"}))
+		}
+		assert.ErrorIs(t, wb.Enqueue(&message.Message{RoomID: "room"}), message.ErrWriteQueueFull)
+		assert.True(t, wb.Saturated())
+
+		go wb.Run()
+		close(svc.block)
+		wb.Close()
+
+		assert.False(t, wb.Saturated())
+		assert.Len(t, svc.saved(), 4)
+
+		mu.Lock()
+		defer mu.Unlock()
+		assert.Equal(t, []bool{true, false}, signals)
+	})
+}
@@ -12,6 +12,7 @@ import (
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
 	"server/internal/auth"
 	"server/internal/message"
@@ -57,7 +58,7 @@ func (m *MockMessageService) GetMessagesByRoom(ctx context.Context, roomID strin
 	return roomMessages[offset:end], nil
 }
 
-func (m *MockMessageService) CreateRoom(ctx context.Context, name, ownerID, roomType string) (interface{}, error) {
+func (m *MockMessageService) CreateRoom(ctx context.Context, name, ownerID, roomType string, capacity message.RoomCapacity) (interface{}, error) {
 	room := &message.Room{
 		ID:      uuid.New().String(),
 		Name:    name,
@@ -84,7 +85,7 @@ func (m *MockMessageService) UpdateRoomActivity(ctx context.Context, roomID stri
 func TestWebSocket(t *testing.T) {
 
 	// Create repositories and services
-	mockAuthRepo := NewMockAuthRepository()
+	mockAuthRepo := auth.NewMemoryRepository()
 	mockMessageService := NewMockMessageService()
 
 	// Create test user
@@ -180,3 +181,63 @@ func TestWebSocket(t *testing.T) {
 		assert.Equal(t, http.StatusBadRequest, w.Code)
 	})
 }
+
+func TestTypingStatus(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	hub := ws.NewHub()
+	hub.Rooms["room"] = &ws.Room{ID: "room", Clients: make(map[string]*ws.Client)}
+	go hub.Run()
+
+	router := gin.New()
+	router.GET("/ws/:roomId", ws.NewHandler(hub, nil).JoinRoom)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	dial := func(username string) *websocket.Conn {
+		conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/ws/room?userId="+username+"&username="+username, nil)
+		require.NoError(t, err)
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		return conn
+	}
+	alice := dial("alice")
+	defer alice.Close()
+	bob := dial("bob")
+	defer bob.Close()
+
+	for _, typing := range []string{"true", "false"} {
+		require.NoError(t, alice.WriteJSON(map[string]string{"type": "typing", "content": typing}))
+		for {
+			var received map[string]interface{}
+			require.NoError(t, bob.ReadJSON(&received))
+			if received["type"] == "typing" {
+				assert.Equal(t, "alice", received["username"])
+				assert.Equal(t, typing, received["content"])
+				break
+			}
+		}
+	}
+}
+
+func TestBackpressureNoticeSkipsFullClients(t *testing.T) {
+	hub := ws.NewHub()
+	// Nobody reads from slow, so any send to it blocks
+	slow := &ws.Client{ID: "slow", RoomID: "room", Username: "slow", Message: make(chan *ws.Message)}
+	ready := &ws.Client{ID: "ready", RoomID: "room", Username: "ready", Message: make(chan *ws.Message, 1)}
+	hub.Rooms["room"] = &ws.Room{ID: "room", Clients: map[string]*ws.Client{slow.ID: slow, ready.ID: ready}}
+	go hub.Run()
+
+	hub.SignalBackpressure(true)
+
+	select {
+	case notice := <-ready.Message:
+		assert.Equal(t, ws.MessageTypeSystem, notice.Type)
+		assert.Equal(t, "room", notice.RoomID)
+	case <-time.After(time.Second):
+		t.Fatal("clients with room in their buffer should get the notice")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	assert.NoError(t, hub.Ping(ctx), "a full client must not stall the hub loop")
+}
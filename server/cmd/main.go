@@ -1,10 +1,18 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"log"
+	"os"
+	"runtime"
+	"server/config"
 	"server/db"
+	"server/internal/health"
 	"server/internal/message"
+	"server/internal/metrics"
 	"server/internal/oauth"
+	"server/internal/tracing"
 	"server/internal/user"
 	"server/internal/ws"
 	"server/router"
@@ -21,71 +29,294 @@ func main() {
 		log.Println("Warning: No .env file found or failed to load .env")
 	}
 
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrate(os.Args[2:]); err != nil {
+			log.Fatalf("migrate: %v", err)
+		}
+		return
+	}
+
+	cfg, err := config.Load(os.Args[1:])
+	if err != nil {
+		log.Fatalf("could not load configuration: %v", err)
+	}
+	log.Printf("Effective configuration:\n%s", cfg)
+
+	shutdownTracing, err := tracing.Setup(context.Background(), tracing.Config{
+		Exporter:    cfg.Tracing.Exporter,
+		Endpoint:    cfg.Tracing.Endpoint,
+		Insecure:    cfg.Tracing.Insecure,
+		SampleRatio: cfg.Tracing.SampleRatio,
+		ServiceName: cfg.Tracing.ServiceName,
+	})
+	if err != nil {
+		log.Fatalf("could not set up tracing: %v", err)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(ctx); err != nil {
+			log.Printf("Error flushing traces: %v", err)
+		}
+	}()
+
 	log.Println("Starting the application...")
-	dbConn, err := db.NewDatabase()
+	dbConn, err := openDatabase(cfg.Database)
 	if err != nil {
 		log.Fatalf("could not initialize database connection: %v", err)
 	}
 	log.Println("Database connection established")
 
-	redisClient, err := db.NewRedisClient("localhost:6379", "", 0)
-	if err != nil {
-		log.Printf("Warning: Redis connection failed: %v. Proceeding without caching.", err)
+	if cfg.Database.CheckSchema {
+		if err := db.CheckSchema(dbConn.GetDB(), dbConn.Driver()); err != nil {
+			log.Fatalf("could not verify database schema: %v", err)
+		}
+	}
+
+	checker := health.NewChecker(health.Config{
+		Timeout:       cfg.Health.CheckTimeout,
+		SlowThreshold: cfg.Health.SlowThreshold,
+	})
+	checker.Register(dbConn.Driver(), true, dbConn.GetDB().PingContext)
+
+	var (
+		authRepo       auth.Repository
+		messageRepo    message.Repository
+		deadLetterRepo message.DeadLetterRepository
+	)
+	switch dbConn.Driver() {
+	case db.DriverSQLite:
+		authRepo = auth.NewSQLiteRepository(dbConn.GetDB())
+		messageRepo = message.NewSQLiteRepository(dbConn.GetDB())
+		deadLetterRepo = message.NewSQLiteDeadLetterRepository(dbConn.GetDB())
+	default:
+		pgAuthRepo := auth.NewRepository(dbConn.GetDB())
+		pgMessageRepo := message.NewPostgresRepository(dbConn.GetDB())
+		if len(cfg.Database.Replicas) > 0 {
+			replicas, err := db.OpenReplicaSet(dbConn.GetDB(), cfg.Database.Replicas, db.ReplicaConfig{
+				HealthInterval: cfg.Database.ReplicaHealthInterval,
+				MaxLag:         cfg.Database.ReplicaMaxLag,
+			})
+			if err != nil {
+				log.Fatalf("could not open read replicas: %v", err)
+			}
+			go replicas.Run()
+			defer replicas.Close()
+			log.Printf("Routing reads to %d read replicas, %d healthy", len(cfg.Database.Replicas), replicas.HealthyReplicas())
+
+			pgAuthRepo.UseReplicas(replicas)
+			pgMessageRepo.UseReplicas(replicas)
+			checker.Register("replicas", false, func(ctx context.Context) error {
+				if healthy := replicas.HealthyReplicas(); healthy < len(cfg.Database.Replicas) {
+					return health.Degraded(fmt.Errorf("%d of %d read replicas are healthy", healthy, len(cfg.Database.Replicas)))
+				}
+				return nil
+			})
+		}
+		authRepo = pgAuthRepo
+		messageRepo = pgMessageRepo
+		if cfg.Database.Pgx.Enabled {
+			pool, err := db.NewPgxPool(context.Background(), cfg.Database.DSN(), db.PoolConfig{
+				MaxConns:          cfg.Database.Pgx.MaxConns,
+				MinConns:          cfg.Database.Pgx.MinConns,
+				MaxConnLifetime:   cfg.Database.Pgx.MaxConnLifetime,
+				MaxConnIdleTime:   cfg.Database.Pgx.MaxConnIdleTime,
+				HealthCheckPeriod: cfg.Database.Pgx.HealthCheckPeriod,
+			}, message.PreparePgxStatements)
+			if err != nil {
+				log.Fatalf("could not open pgx connection pool: %v", err)
+			}
+			defer pool.Close()
+			log.Printf("Serving messages from a pgx pool of up to %d connections", pool.Config().MaxConns)
+
+			messageRepo = message.NewPgxRepository(pool)
+			checker.Register("postgres-pgx", true, pool.Ping)
+		}
+		deadLetterRepo = message.NewPostgresDeadLetterRepository(dbConn.GetDB())
+	}
+
+	var redisClient *db.RedisClient
+	if cfg.Redis.Addr == "" {
+		log.Println("Redis not configured. Proceeding with in-process caching.")
+	} else if redisClient, err = db.NewRedisClient(cfg.Redis.Addr, cfg.Redis.Password, cfg.Redis.DB); err != nil {
+		log.Printf("Warning: Redis connection failed: %v. Proceeding with in-process caching.", err)
 	} else {
 		log.Println("Redis connection established")
 		defer redisClient.Close()
+		redisClient.GetClient().AddHook(metrics.RedisHook{})
+		redisClient.GetClient().AddHook(tracing.RedisHook{})
+		checker.Register("redis", false, func(ctx context.Context) error {
+			return redisClient.GetClient().Ping(ctx).Err()
+		})
 	}
 
-	oauth.InitGoogleOAuth()
+	oauth.InitGoogleOAuth(cfg.OAuth.GoogleClientID, cfg.OAuth.GoogleClientSecret, cfg.OAuth.GoogleRedirectURL)
 
-	authRepo := auth.NewRepository(dbConn.GetDB())
 	authService := auth.NewService(authRepo)
-	authHandler := auth.NewHandler(authService)
+	authHandler := auth.NewHandler(authService, auth.OAuthConfig{
+		ClientID:     cfg.OAuth.GoogleClientID,
+		ClientSecret: cfg.OAuth.GoogleClientSecret,
+		RedirectURL:  cfg.OAuth.GoogleRedirectURL,
+		ClientOrigin: cfg.OAuth.ClientOrigin,
+	})
 
 	userRep := user.NewRepository(dbConn.GetDB())
-	userSvc := user.NewService(userRep)
+	userSvc := user.NewService(userRep, cfg.Auth.JWTSecret)
 	userHandler := user.NewHandler(userSvc)
 
-	messageRepo := message.NewPostgresRepository(dbConn.GetDB())
-	var messageCache message.Cache
-	if redisClient != nil {
-		messageCache = message.NewRedisCache("localhost:6379", "", 0)
-	}
-	baseSvc := message.NewService(messageRepo, messageCache)
-
 	cbConfig := message.CircuitBreakerConfig{
 		Name:        "chat-service",
-		MaxRequests: 3,
-		Interval:    10 * time.Second,
-		Timeout:     30 * time.Second,
+		MaxRequests: cfg.Breaker.MaxRequests,
+		Interval:    cfg.Breaker.Interval,
+		Timeout:     cfg.Breaker.Timeout,
 		ReadyToTrip: func(counts gobreaker.Counts) bool {
 			failureRatio := float64(counts.TotalFailures) / float64(counts.Requests)
-			return counts.Requests >= 3 && failureRatio >= 0.6
+			return counts.Requests >= cfg.Breaker.MinRequests && failureRatio >= cfg.Breaker.FailureRatio
 		},
 		OnStateChange: func(name string, from gobreaker.State, to gobreaker.State) {
 			log.Printf("Circuit breaker %s state changed from %s to %s", name, from, to)
+			metrics.SetBreakerState(name, to)
 		},
 	}
 
-	retryConfig := message.RetryConfig{
-		MaxElapsedTime:  1 * time.Minute,
-		MaxInterval:     5 * time.Second,
-		InitialInterval: 100 * time.Millisecond,
+	var messageCache message.Cache
+	var tieredCache *message.TieredCache
+	var resilientCache *message.ResilientCache
+	if redisClient != nil {
+		l1 := message.NewMemoryCacheWithTTL(cfg.Cache.L1Size, cfg.Cache.L1TTL)
+		redisCache := message.NewRedisCache(cfg.Redis.Addr, cfg.Redis.Password, cfg.Redis.DB)
+		redisCache.AddHook(metrics.RedisHook{})
+		redisCache.AddHook(tracing.RedisHook{})
+		tieredCache = message.NewTieredCache(l1, redisCache)
+		if err := tieredCache.Start(context.Background()); err != nil {
+			log.Printf("Warning: %v. Local cache entries expire after %s.", err, cfg.Cache.L1TTL)
+		}
+
+		// L1 is bypassed along with Redis while the breaker is open, since
+		// invalidations from other replicas are not arriving either
+		resilientCache = message.NewResilientCache(tieredCache, cbConfig, cfg.Cache.OpTimeout)
+		messageCache = resilientCache
+		metrics.SetBreakerState(cbConfig.Name+"-cache", resilientCache.BreakerState())
+
+		metrics.ObserveCacheTier("l1", func() (uint64, uint64) {
+			stats := tieredCache.Stats()
+			return stats.L1.Hits, stats.L1.Misses
+		})
+		metrics.ObserveCacheTier("l2", func() (uint64, uint64) {
+			stats := tieredCache.Stats()
+			return stats.L2.Hits, stats.L2.Misses
+		})
+	} else {
+		messageCache = message.NewMemoryCache(cfg.Cache.L1Size)
+	}
+	baseSvc := message.NewTracedService(message.NewService(message.NewInstrumentedRepository(messageRepo), messageCache))
+
+	messageSvc := message.NewResilientService(baseSvc, cbConfig, retryConfig(cfg.Retry))
+	metrics.SetBreakerState(cbConfig.Name+"-messages", messageSvc.MessageBreakerState())
+	metrics.SetBreakerState(cbConfig.Name+"-rooms", messageSvc.RoomBreakerState())
+
+	// Reads answer a waiting HTTP client, so they get a much smaller budget
+	// than writes, which are usually flushed in the background
+	readRetryConfig := retryConfig(cfg.ReadRetry)
+	for _, op := range []message.Operation{
+		message.OpGetMessagesByRoom,
+		message.OpGetRoomHistory,
+		message.OpGetMessageByID,
+		message.OpSearchMessages,
+		message.OpGetRooms,
+		message.OpGetRoomByID,
+	} {
+		messageSvc.UseRetryConfig(op, readRetryConfig)
 	}
 
-	messageSvc := message.NewResilientService(baseSvc, cbConfig, retryConfig)
+	// Bulkheads keep slow history queries from taking every pooled connection
+	// away from message writes
+	messageSvc.UseBulkhead(message.GroupWrites, bulkheadConfig(cfg.Bulkheads.Writes))
+	messageSvc.UseBulkhead(message.GroupHistory, bulkheadConfig(cfg.Bulkheads.History))
+	messageSvc.UseBulkhead(message.GroupRooms, bulkheadConfig(cfg.Bulkheads.Rooms))
+	messageSvc.UseStaleCache(messageCache)
 	messageHandler := message.NewHandler(messageSvc)
+	checker.Register("messages-breaker", false, health.Breaker(messageSvc.MessageBreakerState))
+	checker.Register("rooms-breaker", false, health.Breaker(messageSvc.RoomBreakerState))
+	if resilientCache != nil {
+		checker.Register("cache-breaker", false, health.Breaker(resilientCache.BreakerState))
+	}
+
+	hubShards := cfg.Hub.Shards
+	if hubShards == 0 {
+		hubShards = runtime.GOMAXPROCS(0)
+	}
+	hub := ws.NewShardedHub(hubShards)
+	log.Printf("Hub running with %d shard loops", hubShards)
+
+	spool, err := message.NewSpool(cfg.Spool.Dir)
+	if err != nil {
+		log.Fatalf("could not open message spool: %v", err)
+	}
+	defer spool.Close()
+
+	deadLetterSvc := message.NewDeadLetterService(deadLetterRepo, messageSvc)
+
+	replayCtx, stopReplay := context.WithCancel(context.Background())
+	defer stopReplay()
+	replayer := message.NewSpoolReplayer(spool, messageSvc, func() bool {
+		return messageSvc.MessageBreakerState() != gobreaker.StateOpen
+	}, cfg.Spool.ReplayInterval, cfg.WriteBehind.BatchSize)
+	replayer.UseDeadLetters(deadLetterSvc)
+	go replayer.Run(replayCtx)
 
-	hub := ws.NewHub()
+	writeBehindConfig := message.DefaultWriteBehindConfig()
+	writeBehindConfig.QueueSize = cfg.WriteBehind.QueueSize
+	writeBehindConfig.BatchSize = cfg.WriteBehind.BatchSize
+	writeBehindConfig.FlushInterval = cfg.WriteBehind.FlushInterval
+	writeBehind := message.NewWriteBehind(messageSvc, writeBehindConfig)
+	writeBehind.OnBackpressure(hub.SignalBackpressure)
+	writeBehind.UseSpool(spool)
+	writeBehind.UseDeadLetters(deadLetterSvc)
+	go writeBehind.Run()
+	defer writeBehind.Close()
 
-	messageAdapter := ws.NewMessageServiceAdapter(messageSvc)
+	messageAdapter := ws.NewWriteBehindAdapter(messageSvc, writeBehind)
 	wsHandler := ws.NewHandler(hub, messageAdapter)
 
 	go hub.Run()
+	metrics.Registry.MustRegister(ws.NewQueueCollector(hub))
+	checker.Register("hub", true, hub.Ping)
 
-	router.InitRouter(userHandler, wsHandler, messageHandler, authHandler)
-	log.Println("Starting server on :8080")
-	if err := router.Start(":8080"); err != nil {
+	adminHandler := message.NewAdminHandler(spool, deadLetterSvc)
+	if tieredCache != nil {
+		adminHandler.UseCache(tieredCache)
+		adminHandler.UseCacheBreaker(resilientCache)
+	}
+
+	router.InitRouter(cfg, userHandler, wsHandler, messageHandler, authHandler, adminHandler, health.NewHandler(checker))
+	log.Printf("Starting server on %s", cfg.Server.Addr)
+	if err := router.Start(cfg.Server.Addr); err != nil {
 		log.Fatalf("could not start server: %v", err)
 	}
 }
+
+func retryConfig(c config.RetryConfig) message.RetryConfig {
+	return message.RetryConfig{
+		MaxElapsedTime:  c.MaxElapsedTime,
+		MaxInterval:     c.MaxInterval,
+		InitialInterval: c.InitialInterval,
+		MaxRetries:      c.MaxRetries,
+	}
+}
+
+func bulkheadConfig(c config.BulkheadConfig) message.BulkheadConfig {
+	return message.BulkheadConfig{
+		MaxConcurrent: c.MaxConcurrent,
+		MaxWait:       c.MaxWait,
+		Timeout:       c.Timeout,
+	}
+}
+
+// openDatabase connects to the configured database backend
+func openDatabase(cfg config.DatabaseConfig) (*db.Database, error) {
+	if cfg.Driver == db.DriverSQLite {
+		return db.NewSQLiteDatabase(cfg.SQLitePath)
+	}
+	return db.NewDatabase(cfg.DSN())
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+
+	"server/config"
+	"server/db"
+)
+
+const migrateUsage = "usage: server migrate up|down [steps]|status [config flags]"
+
+// runMigrate implements `server migrate`. Down rolls back one migration unless
+// a number of steps is given.
+func runMigrate(args []string) error {
+	if len(args) == 0 {
+		return errors.New(migrateUsage)
+	}
+	action, args := args[0], args[1:]
+	if action != "up" && action != "down" && action != "status" {
+		return errors.New(migrateUsage)
+	}
+
+	steps := 1
+	if action == "down" && len(args) > 0 {
+		if n, err := strconv.Atoi(args[0]); err == nil {
+			if n <= 0 {
+				return errors.New("steps must be positive")
+			}
+			steps, args = n, args[1:]
+		}
+	}
+
+	cfg, err := config.Parse(args)
+	if err != nil {
+		return err
+	}
+	if err := cfg.Database.Validate(); err != nil {
+		return err
+	}
+
+	dbConn, err := openDatabase(cfg.Database)
+	if err != nil {
+		return fmt.Errorf("could not connect to database: %w", err)
+	}
+	defer dbConn.Close()
+
+	switch action {
+	case "up":
+		if err := db.MigrateUp(dbConn.GetDB(), dbConn.Driver()); err != nil {
+			return err
+		}
+	case "down":
+		if err := db.MigrateDown(dbConn.GetDB(), dbConn.Driver(), steps); err != nil {
+			return err
+		}
+	}
+
+	status, err := db.GetMigrationStatus(dbConn.GetDB(), dbConn.Driver())
+	if err != nil {
+		return err
+	}
+	log.Printf("Schema version %d of %d (dirty: %t)", status.Version, status.Latest, status.Dirty)
+	return nil
+}
@@ -0,0 +1,375 @@
+// Package config loads the server configuration. Values come from built-in
+// defaults, then an optional YAML or TOML file, then environment variables and
+// finally command-line flags, each layer overriding the previous one.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+type Config struct {
+	Server      ServerConfig      `yaml:"server"`
+	Database    DatabaseConfig    `yaml:"database"`
+	Redis       RedisConfig       `yaml:"redis"`
+	Auth        AuthConfig        `yaml:"auth"`
+	OAuth       OAuthConfig       `yaml:"oauth"`
+	CORS        CORSConfig        `yaml:"cors"`
+	Admin       AdminConfig       `yaml:"admin"`
+	Breaker     BreakerConfig     `yaml:"breaker"`
+	Retry       RetryConfig       `yaml:"retry" env:"RETRY"`
+	ReadRetry   RetryConfig       `yaml:"read_retry" env:"READ_RETRY"`
+	Bulkheads   BulkheadsConfig   `yaml:"bulkheads"`
+	Cache       CacheConfig       `yaml:"cache"`
+	Hub         HubConfig         `yaml:"hub"`
+	Spool       SpoolConfig       `yaml:"spool"`
+	WriteBehind WriteBehindConfig `yaml:"write_behind"`
+	Health      HealthConfig      `yaml:"health"`
+	Tracing     TracingConfig     `yaml:"tracing"`
+}
+
+type ServerConfig struct {
+	Addr string `yaml:"addr" env:"SERVER_ADDR"`
+}
+
+type DatabaseConfig struct {
+	// Driver selects the backend, either postgres or sqlite
+	Driver     string `yaml:"driver" env:"DB_DRIVER"`
+	SQLitePath string `yaml:"sqlite_path" env:"DB_SQLITE_PATH"`
+
+	Host     string `yaml:"host" env:"DB_HOST"`
+	Port     int    `yaml:"port" env:"DB_PORT"`
+	User     string `yaml:"user" env:"DB_USER"`
+	Password string `yaml:"password" env:"DB_PASSWORD" secret:"true"`
+	Name     string `yaml:"name" env:"DB_NAME"`
+	SSLMode  string `yaml:"ssl_mode" env:"DB_SSLMODE"`
+	// CheckSchema refuses to start against a database that is not fully migrated
+	CheckSchema bool `yaml:"check_schema" env:"DB_CHECK_SCHEMA"`
+
+	// Replicas are Postgres read replica DSNs; all queries go to the primary
+	// when empty
+	Replicas              []string      `yaml:"replicas" env:"DB_REPLICAS" secret:"true"`
+	ReplicaHealthInterval time.Duration `yaml:"replica_health_interval" env:"DB_REPLICA_HEALTH_INTERVAL"`
+	// ReplicaMaxLag skips replicas further behind the primary; zero disables the check
+	ReplicaMaxLag time.Duration `yaml:"replica_max_lag" env:"DB_REPLICA_MAX_LAG"`
+	// ReadYourWritesWindow sends a client's reads to the primary for this long
+	// after it wrote
+	ReadYourWritesWindow time.Duration `yaml:"read_your_writes_window" env:"DB_READ_YOUR_WRITES_WINDOW"`
+
+	// Pgx serves the message repository from a pgx connection pool instead
+	// of database/sql
+	Pgx PgxConfig `yaml:"pgx"`
+}
+
+// PgxConfig sizes the pgx connection pool; zero values keep the pgx defaults
+type PgxConfig struct {
+	Enabled           bool          `yaml:"enabled" env:"DB_PGX_ENABLED"`
+	MaxConns          int32         `yaml:"max_conns" env:"DB_PGX_MAX_CONNS"`
+	MinConns          int32         `yaml:"min_conns" env:"DB_PGX_MIN_CONNS"`
+	MaxConnLifetime   time.Duration `yaml:"max_conn_lifetime" env:"DB_PGX_MAX_CONN_LIFETIME"`
+	MaxConnIdleTime   time.Duration `yaml:"max_conn_idle_time" env:"DB_PGX_MAX_CONN_IDLE_TIME"`
+	HealthCheckPeriod time.Duration `yaml:"health_check_period" env:"DB_PGX_HEALTH_CHECK_PERIOD"`
+}
+
+// DSN returns the Postgres connection string
+func (c DatabaseConfig) DSN() string {
+	u := url.URL{
+		Scheme:   "postgresql",
+		User:     url.UserPassword(c.User, c.Password),
+		Host:     fmt.Sprintf("%s:%d", c.Host, c.Port),
+		Path:     c.Name,
+		RawQuery: "sslmode=" + url.QueryEscape(c.SSLMode),
+	}
+	return u.String()
+}
+
+type RedisConfig struct {
+	// Addr may be empty to run with in-process caching only
+	Addr     string `yaml:"addr" env:"REDIS_ADDR"`
+	Password string `yaml:"password" env:"REDIS_PASSWORD" secret:"true"`
+	DB       int    `yaml:"db" env:"REDIS_DB"`
+}
+
+type AuthConfig struct {
+	JWTSecret string `yaml:"jwt_secret" env:"JWT_SECRET" secret:"true"`
+}
+
+type OAuthConfig struct {
+	GoogleClientID     string `yaml:"google_client_id" env:"GOOGLE_OAUTH_CLIENT_ID"`
+	GoogleClientSecret string `yaml:"google_client_secret" env:"GOOGLE_OAUTH_CLIENT_SECRET" secret:"true"`
+	GoogleRedirectURL  string `yaml:"google_redirect_url" env:"GOOGLE_OAUTH_REDIRECT_URL"`
+	// ClientOrigin is the frontend origin the OAuth popup reports back to
+	ClientOrigin string `yaml:"client_origin" env:"OAUTH_CLIENT_ORIGIN"`
+}
+
+type CORSConfig struct {
+	AllowedOrigins []string `yaml:"allowed_origins" env:"CORS_ALLOWED_ORIGINS"`
+}
+
+type AdminConfig struct {
+	// Token guards the admin API, which is disabled while it is empty
+	Token string `yaml:"token" env:"ADMIN_TOKEN" secret:"true"`
+}
+
+type BreakerConfig struct {
+	MaxRequests  uint32        `yaml:"max_requests" env:"BREAKER_MAX_REQUESTS"`
+	Interval     time.Duration `yaml:"interval" env:"BREAKER_INTERVAL"`
+	Timeout      time.Duration `yaml:"timeout" env:"BREAKER_TIMEOUT"`
+	MinRequests  uint32        `yaml:"min_requests" env:"BREAKER_MIN_REQUESTS"`
+	FailureRatio float64       `yaml:"failure_ratio" env:"BREAKER_FAILURE_RATIO"`
+}
+
+type RetryConfig struct {
+	MaxElapsedTime  time.Duration `yaml:"max_elapsed_time" env:"MAX_ELAPSED_TIME"`
+	MaxInterval     time.Duration `yaml:"max_interval" env:"MAX_INTERVAL"`
+	InitialInterval time.Duration `yaml:"initial_interval" env:"INITIAL_INTERVAL"`
+	MaxRetries      uint64        `yaml:"max_retries" env:"MAX_RETRIES"`
+}
+
+type BulkheadsConfig struct {
+	Writes  BulkheadConfig `yaml:"writes" env:"BULKHEAD_WRITES"`
+	History BulkheadConfig `yaml:"history" env:"BULKHEAD_HISTORY"`
+	Rooms   BulkheadConfig `yaml:"rooms" env:"BULKHEAD_ROOMS"`
+}
+
+type BulkheadConfig struct {
+	MaxConcurrent int           `yaml:"max_concurrent" env:"MAX_CONCURRENT"`
+	MaxWait       time.Duration `yaml:"max_wait" env:"MAX_WAIT"`
+	Timeout       time.Duration `yaml:"timeout" env:"TIMEOUT"`
+}
+
+type CacheConfig struct {
+	L1Size    int           `yaml:"l1_size" env:"CACHE_L1_SIZE"`
+	L1TTL     time.Duration `yaml:"l1_ttl" env:"CACHE_L1_TTL"`
+	OpTimeout time.Duration `yaml:"op_timeout" env:"CACHE_OP_TIMEOUT"`
+}
+
+type HealthConfig struct {
+	// CheckTimeout bounds every dependency check of /readyz and /status
+	CheckTimeout time.Duration `yaml:"check_timeout" env:"HEALTH_CHECK_TIMEOUT"`
+	// SlowThreshold reports dependencies answering slower as degraded
+	SlowThreshold time.Duration `yaml:"slow_threshold" env:"HEALTH_SLOW_THRESHOLD"`
+}
+
+type TracingConfig struct {
+	// Exporter is none, stdout or otlp
+	Exporter string `yaml:"exporter" env:"TRACING_EXPORTER"`
+	// Endpoint is the host:port of the OTLP/HTTP collector
+	Endpoint    string  `yaml:"endpoint" env:"TRACING_ENDPOINT"`
+	Insecure    bool    `yaml:"insecure" env:"TRACING_INSECURE"`
+	SampleRatio float64 `yaml:"sample_ratio" env:"TRACING_SAMPLE_RATIO"`
+	ServiceName string  `yaml:"service_name" env:"TRACING_SERVICE_NAME"`
+}
+
+type HubConfig struct {
+	// Shards is the number of hub event loops; zero uses GOMAXPROCS
+	Shards int `yaml:"shards" env:"HUB_SHARDS"`
+}
+
+type SpoolConfig struct {
+	Dir            string        `yaml:"dir" env:"MESSAGE_SPOOL_DIR"`
+	ReplayInterval time.Duration `yaml:"replay_interval" env:"SPOOL_REPLAY_INTERVAL"`
+}
+
+type WriteBehindConfig struct {
+	QueueSize     int           `yaml:"queue_size" env:"WRITE_BEHIND_QUEUE_SIZE"`
+	BatchSize     int           `yaml:"batch_size" env:"WRITE_BEHIND_BATCH_SIZE"`
+	FlushInterval time.Duration `yaml:"flush_interval" env:"WRITE_BEHIND_FLUSH_INTERVAL"`
+}
+
+// Default returns the configuration used for anything not set elsewhere
+func Default() *Config {
+	return &Config{
+		Server: ServerConfig{Addr: ":8080"},
+		Database: DatabaseConfig{
+			Driver:      "postgres",
+			SQLitePath:  "data/chat.db",
+			Host:        "localhost",
+			Port:        5432,
+			User:        "postgres",
+			Name:        "go-chat",
+			SSLMode:     "disable",
+			CheckSchema: true,
+
+			ReplicaHealthInterval: 5 * time.Second,
+			ReplicaMaxLag:         30 * time.Second,
+			ReadYourWritesWindow:  5 * time.Second,
+		},
+		Redis: RedisConfig{Addr: "localhost:6379"},
+		OAuth: OAuthConfig{ClientOrigin: "http://localhost:3000"},
+		CORS: CORSConfig{
+			AllowedOrigins: []string{"http://localhost:3000", "http://localhost:8081"},
+		},
+		Breaker: BreakerConfig{
+			MaxRequests:  3,
+			Interval:     10 * time.Second,
+			Timeout:      30 * time.Second,
+			MinRequests:  3,
+			FailureRatio: 0.6,
+		},
+		Retry: RetryConfig{
+			MaxElapsedTime:  1 * time.Minute,
+			MaxInterval:     5 * time.Second,
+			InitialInterval: 100 * time.Millisecond,
+		},
+		ReadRetry: RetryConfig{
+			MaxElapsedTime:  3 * time.Second,
+			MaxInterval:     1 * time.Second,
+			InitialInterval: 100 * time.Millisecond,
+			MaxRetries:      3,
+		},
+		Bulkheads: BulkheadsConfig{
+			Writes:  BulkheadConfig{MaxConcurrent: 20, MaxWait: 500 * time.Millisecond, Timeout: 30 * time.Second},
+			History: BulkheadConfig{MaxConcurrent: 10, MaxWait: 100 * time.Millisecond, Timeout: 5 * time.Second},
+			Rooms:   BulkheadConfig{MaxConcurrent: 10, MaxWait: 100 * time.Millisecond, Timeout: 5 * time.Second},
+		},
+		Cache: CacheConfig{
+			L1Size:    10000,
+			L1TTL:     30 * time.Second,
+			OpTimeout: 100 * time.Millisecond,
+		},
+		Spool: SpoolConfig{
+			Dir:            "data/spool",
+			ReplayInterval: 5 * time.Second,
+		},
+		WriteBehind: WriteBehindConfig{
+			QueueSize:     4096,
+			BatchSize:     200,
+			FlushInterval: 250 * time.Millisecond,
+		},
+		Health: HealthConfig{
+			CheckTimeout:  2 * time.Second,
+			SlowThreshold: 500 * time.Millisecond,
+		},
+		Tracing: TracingConfig{
+			Exporter:    "none",
+			Endpoint:    "localhost:4318",
+			Insecure:    true,
+			SampleRatio: 1,
+			ServiceName: "chat-server",
+		},
+	}
+}
+
+// Validate reports every invalid database setting at once
+func (c DatabaseConfig) Validate() error {
+	switch c.Driver {
+	case "postgres":
+	case "sqlite":
+		if c.SQLitePath == "" {
+			return errors.New("database.sqlite_path is required for the sqlite driver")
+		}
+		if len(c.Replicas) > 0 {
+			return errors.New("database.replicas are only supported by the postgres driver")
+		}
+		if c.Pgx.Enabled {
+			return errors.New("database.pgx is only supported by the postgres driver")
+		}
+		return nil
+	default:
+		return fmt.Errorf("database.driver must be postgres or sqlite, not %q", c.Driver)
+	}
+
+	var errs []error
+	if c.Host == "" {
+		errs = append(errs, errors.New("database.host is required"))
+	}
+	if c.Port <= 0 || c.Port >= 65536 {
+		errs = append(errs, errors.New("database.port must be between 1 and 65535"))
+	}
+	if c.User == "" {
+		errs = append(errs, errors.New("database.user is required"))
+	}
+	if c.Name == "" {
+		errs = append(errs, errors.New("database.name is required"))
+	}
+	if len(c.Replicas) > 0 {
+		if c.ReplicaHealthInterval <= 0 {
+			errs = append(errs, errors.New("database.replica_health_interval must be positive"))
+		}
+		if c.ReplicaMaxLag < 0 || c.ReadYourWritesWindow < 0 {
+			errs = append(errs, errors.New("database.replica_max_lag and database.read_your_writes_window must not be negative"))
+		}
+	}
+	if c.Pgx.Enabled {
+		if len(c.Replicas) > 0 {
+			errs = append(errs, errors.New("database.pgx cannot be combined with database.replicas"))
+		}
+		if c.Pgx.MaxConns < 0 || c.Pgx.MinConns < 0 || (c.Pgx.MaxConns > 0 && c.Pgx.MinConns > c.Pgx.MaxConns) {
+			errs = append(errs, errors.New("database.pgx.min_conns must be between 0 and database.pgx.max_conns"))
+		}
+		if c.Pgx.MaxConnLifetime < 0 || c.Pgx.MaxConnIdleTime < 0 || c.Pgx.HealthCheckPeriod < 0 {
+			errs = append(errs, errors.New("database.pgx durations must not be negative"))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Validate reports every invalid setting at once
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(c.Server.Addr != "", "server.addr is required")
+	if err := c.Database.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+	check(c.Redis.DB >= 0, "redis.db must not be negative")
+	check(c.Auth.JWTSecret != "", "auth.jwt_secret is required")
+
+	for _, origin := range c.CORS.AllowedOrigins {
+		u, err := url.Parse(origin)
+		check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "",
+			"cors.allowed_origins: %q is not an http(s) origin", origin)
+	}
+
+	check(c.Breaker.MinRequests > 0, "breaker.min_requests must be positive")
+	check(c.Breaker.FailureRatio > 0 && c.Breaker.FailureRatio <= 1, "breaker.failure_ratio must be in (0, 1]")
+	check(c.Breaker.Timeout > 0, "breaker.timeout must be positive")
+
+	for name, retry := range map[string]RetryConfig{"retry": c.Retry, "read_retry": c.ReadRetry} {
+		check(retry.InitialInterval > 0, "%s.initial_interval must be positive", name)
+		check(retry.MaxInterval >= retry.InitialInterval, "%s.max_interval must not be below initial_interval", name)
+	}
+
+	for name, bulkhead := range map[string]BulkheadConfig{
+		"writes":  c.Bulkheads.Writes,
+		"history": c.Bulkheads.History,
+		"rooms":   c.Bulkheads.Rooms,
+	} {
+		check(bulkhead.MaxConcurrent >= 0, "bulkheads.%s.max_concurrent must not be negative", name)
+		check(bulkhead.MaxWait >= 0 && bulkhead.Timeout >= 0, "bulkheads.%s durations must not be negative", name)
+	}
+
+	check(c.Cache.L1Size > 0, "cache.l1_size must be positive")
+	check(c.Cache.L1TTL > 0, "cache.l1_ttl must be positive")
+	check(c.Cache.OpTimeout > 0, "cache.op_timeout must be positive")
+	check(c.Hub.Shards >= 0, "hub.shards must not be negative")
+	check(c.Spool.Dir != "", "spool.dir is required")
+	check(c.Spool.ReplayInterval > 0, "spool.replay_interval must be positive")
+	check(c.WriteBehind.QueueSize > 0, "write_behind.queue_size must be positive")
+	check(c.WriteBehind.BatchSize > 0, "write_behind.batch_size must be positive")
+	check(c.WriteBehind.FlushInterval > 0, "write_behind.flush_interval must be positive")
+	check(c.Health.CheckTimeout > 0, "health.check_timeout must be positive")
+	check(c.Health.SlowThreshold >= 0, "health.slow_threshold must not be negative")
+	switch c.Tracing.Exporter {
+	case "none", "stdout":
+	case "otlp":
+		check(c.Tracing.Endpoint != "", "tracing.endpoint is required for the otlp exporter")
+	default:
+		check(false, "tracing.exporter must be none, stdout or otlp, not %q", c.Tracing.Exporter)
+	}
+	check(c.Tracing.SampleRatio >= 0 && c.Tracing.SampleRatio <= 1, "tracing.sample_ratio must be in [0, 1]")
+	check(c.Tracing.ServiceName != "", "tracing.service_name is required")
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid configuration: %w", errors.Join(errs...))
+	}
+	return nil
+}
@@ -0,0 +1,262 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// field is a single setting, addressed by its dotted path such as redis.addr
+type field struct {
+	path   string
+	env    string
+	secret bool
+	value  reflect.Value
+}
+
+// fields lists every setting of cfg in declaration order
+func fields(cfg *Config) []field {
+	var out []field
+	var walk func(v reflect.Value, path, env string)
+	walk = func(v reflect.Value, path, env string) {
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			sf := t.Field(i)
+			name := strings.Split(sf.Tag.Get("yaml"), ",")[0]
+			fieldPath := join(path, name, ".")
+			fieldEnv := join(env, sf.Tag.Get("env"), "_")
+
+			if sf.Type.Kind() == reflect.Struct && sf.Type != reflect.TypeOf(time.Duration(0)) {
+				walk(v.Field(i), fieldPath, fieldEnv)
+				continue
+			}
+
+			out = append(out, field{
+				path:   fieldPath,
+				env:    fieldEnv,
+				secret: sf.Tag.Get("secret") == "true",
+				value:  v.Field(i),
+			})
+		}
+	}
+	walk(reflect.ValueOf(cfg).Elem(), "", "")
+	return out
+}
+
+func join(prefix, name, sep string) string {
+	if prefix == "" || name == "" {
+		return prefix + name
+	}
+	return prefix + sep + name
+}
+
+// set parses raw into the setting's type
+func (f field) set(raw string) error {
+	v := f.value
+	switch {
+	case v.Type() == reflect.TypeOf(time.Duration(0)):
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return fmt.Errorf("%s: %w", f.path, err)
+		}
+		v.SetInt(int64(d))
+	case v.Kind() == reflect.String:
+		v.SetString(raw)
+	case v.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("%s: %w", f.path, err)
+		}
+		v.SetBool(b)
+	case v.Kind() >= reflect.Int && v.Kind() <= reflect.Int64:
+		n, err := strconv.ParseInt(raw, 10, v.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("%s: %w", f.path, err)
+		}
+		v.SetInt(n)
+	case v.Kind() >= reflect.Uint && v.Kind() <= reflect.Uint64:
+		n, err := strconv.ParseUint(raw, 10, v.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("%s: %w", f.path, err)
+		}
+		v.SetUint(n)
+	case v.Kind() == reflect.Float64:
+		n, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return fmt.Errorf("%s: %w", f.path, err)
+		}
+		v.SetFloat(n)
+	case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.String:
+		var items []string
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		v.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("%s: unsupported setting type %s", f.path, v.Type())
+	}
+	return nil
+}
+
+// String formats the setting the way set parses it
+func (f field) String() string {
+	switch v := f.value.Interface().(type) {
+	case []string:
+		return strings.Join(v, ",")
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// Load builds the configuration from defaults, the file named by -config or
+// CONFIG_FILE, environment variables and flags, then validates it. Every
+// setting has a flag named after its path, for example -redis.addr.
+func Load(args []string) (*Config, error) {
+	cfg, err := Parse(args)
+	if err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// Parse layers the configuration like Load but leaves validation to the
+// caller, for commands that only need part of it
+func Parse(args []string) (*Config, error) {
+	cfg := Default()
+	settings := fields(cfg)
+
+	fs := flag.NewFlagSet("server", flag.ContinueOnError)
+	configFile := fs.String("config", os.Getenv("CONFIG_FILE"), "path to a YAML or TOML config file")
+	flagValues := make(map[string]*string, len(settings))
+	for _, f := range settings {
+		usage := "overrides " + f.path
+		if f.env != "" {
+			usage += " and $" + f.env
+		}
+		flagValues[f.path] = fs.String(f.path, "", usage)
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if *configFile != "" {
+		if err := loadFile(*configFile, settings); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, f := range settings {
+		if raw, ok := os.LookupEnv(f.env); ok && f.env != "" {
+			if err := f.set(raw); err != nil {
+				return nil, fmt.Errorf("$%s: %w", f.env, err)
+			}
+		}
+	}
+
+	var flagErr error
+	fs.Visit(func(fl *flag.Flag) {
+		for _, f := range settings {
+			if f.path == fl.Name && flagErr == nil {
+				flagErr = f.set(*flagValues[f.path])
+			}
+		}
+	})
+	if flagErr != nil {
+		return nil, flagErr
+	}
+	return cfg, nil
+}
+
+// loadFile applies a YAML or TOML file, chosen by extension. Unknown keys are
+// rejected so typos do not silently fall back to defaults.
+func loadFile(path string, settings []field) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	doc := make(map[string]interface{})
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &doc)
+	case ".toml":
+		err = toml.Unmarshal(data, &doc)
+	default:
+		return fmt.Errorf("config file %s must be .yaml, .yml or .toml", path)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+
+	values := make(map[string]string)
+	flatten(doc, "", values)
+
+	byPath := make(map[string]field, len(settings))
+	for _, f := range settings {
+		byPath[f.path] = f
+	}
+
+	for key, raw := range values {
+		f, ok := byPath[key]
+		if !ok {
+			return fmt.Errorf("config file %s: unknown setting %s", path, key)
+		}
+		if err := f.set(raw); err != nil {
+			return fmt.Errorf("config file: %w", err)
+		}
+	}
+	return nil
+}
+
+// flatten turns nested file sections into dotted paths. Lists are joined with
+// commas, matching how list settings are written in env vars and flags.
+func flatten(doc map[string]interface{}, prefix string, out map[string]string) {
+	for key, value := range doc {
+		path := join(prefix, key, ".")
+		switch v := value.(type) {
+		case map[string]interface{}:
+			flatten(v, path, out)
+		case []interface{}:
+			items := make([]string, len(v))
+			for i, item := range v {
+				items[i] = fmt.Sprint(item)
+			}
+			out[path] = strings.Join(items, ",")
+		default:
+			out[path] = fmt.Sprint(v)
+		}
+	}
+}
+
+// String prints the effective configuration one setting per line, with
+// secrets redacted
+func (c *Config) String() string {
+	settings := fields(c)
+	sort.SliceStable(settings, func(i, j int) bool {
+		return settings[i].path < settings[j].path
+	})
+
+	var b strings.Builder
+	for _, f := range settings {
+		value := f.String()
+		if f.secret && value != "" {
+			value = "[redacted]"
+		}
+		fmt.Fprintf(&b, "%s = %s\n", f.path, value)
+	}
+	return b.String()
+}
@@ -2,27 +2,26 @@ package db
 
 import (
 	"database/sql"
-	"fmt"
 	"log"
-	"os"
 
 	_ "github.com/lib/pq"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+// Supported database drivers
+const (
+	DriverPostgres = "postgres"
+	DriverSQLite   = "sqlite"
 )
 
 type Database struct {
-	db *sql.DB
+	db     *sql.DB
+	driver string
 }
 
-func NewDatabase() (*Database, error) {
+func NewDatabase(dsn string) (*Database, error) {
 	log.Printf("Connecting to PostgreSQL...")
-	connStr := fmt.Sprintf("postgresql://%s:%s@%s:%s/%s?sslmode=disable",
-		os.Getenv("DB_USER"),
-		os.Getenv("DB_PASSWORD"),
-		os.Getenv("DB_HOST"),
-		os.Getenv("DB_PORT"),
-		os.Getenv("DB_NAME"))
-
-	db, err := sql.Open("postgres", connStr)
+	db, err := openTraced("postgres", dsn, semconv.DBSystemPostgreSQL)
 	if err != nil {
 		log.Printf("Error opening database: %v", err)
 		return nil, err
@@ -34,7 +33,7 @@ func NewDatabase() (*Database, error) {
 		return nil, err
 	}
 
-	return &Database{db: db}, nil
+	return &Database{db: db, driver: DriverPostgres}, nil
 }
 
 func (d *Database) Close() {
@@ -44,3 +43,8 @@ func (d *Database) Close() {
 func (d *Database) GetDB() *sql.DB {
 	return d.db
 }
+
+// Driver returns DriverPostgres or DriverSQLite
+func (d *Database) Driver() string {
+	return d.driver
+}
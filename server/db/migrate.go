@@ -0,0 +1,181 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/database"
+	"github.com/golang-migrate/migrate/v4/database/postgres"
+	"github.com/golang-migrate/migrate/v4/database/sqlite3"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+)
+
+// Migrations holds the versioned schema migrations compiled into the binary.
+// Postgres migrations live in migrations, SQLite ones in migrations/sqlite.
+//
+//go:embed migrations/*.sql migrations/sqlite/*.sql
+var Migrations embed.FS
+
+// migrationsDir returns the directory holding the migrations of a driver
+func migrationsDir(driver string) (string, error) {
+	switch driver {
+	case DriverPostgres:
+		return "migrations", nil
+	case DriverSQLite:
+		return "migrations/sqlite", nil
+	default:
+		return "", fmt.Errorf("unsupported database driver %q", driver)
+	}
+}
+
+// ErrSchemaOutdated is returned when the database has not been migrated to
+// the schema this binary was built for
+var ErrSchemaOutdated = errors.New("database schema is outdated, run `server migrate up`")
+
+// MigrationStatus describes the schema version of a database
+type MigrationStatus struct {
+	Version uint // applied version, zero if nothing was applied yet
+	Latest  uint // newest version embedded in the binary
+	Dirty   bool // a migration failed halfway and needs manual repair
+}
+
+// withMigrator runs fn with a migrator for the embedded migrations of driver.
+// Postgres migrations run on their own connection, which is returned to the
+// pool after fn.
+func withMigrator(db *sql.DB, driver string, fn func(m *migrate.Migrate) error) error {
+	dir, err := migrationsDir(driver)
+	if err != nil {
+		return err
+	}
+	source, err := iofs.New(Migrations, dir)
+	if err != nil {
+		return fmt.Errorf("failed to read embedded migrations: %w", err)
+	}
+
+	var target database.Driver
+	switch driver {
+	case DriverPostgres:
+		ctx := context.Background()
+		conn, err := db.Conn(ctx)
+		if err != nil {
+			return err
+		}
+		target, err = postgres.WithConnection(ctx, conn, &postgres.Config{})
+		if err != nil {
+			conn.Close()
+			return fmt.Errorf("failed to create migration driver: %w", err)
+		}
+	case DriverSQLite:
+		target, err = sqlite3.WithInstance(db, &sqlite3.Config{})
+		if err != nil {
+			return fmt.Errorf("failed to create migration driver: %w", err)
+		}
+	}
+
+	m, err := migrate.NewWithInstance("iofs", source, driver, target)
+	if err != nil {
+		if driver == DriverPostgres {
+			target.Close()
+		}
+		return err
+	}
+	// Closing the SQLite driver would close db itself
+	if driver == DriverPostgres {
+		defer m.Close()
+	} else {
+		defer source.Close()
+	}
+
+	return fn(m)
+}
+
+// MigrateUp applies every pending migration
+func MigrateUp(db *sql.DB, driver string) error {
+	return withMigrator(db, driver, func(m *migrate.Migrate) error {
+		if err := m.Up(); err != nil && !errors.Is(err, migrate.ErrNoChange) {
+			return err
+		}
+		return nil
+	})
+}
+
+// MigrateDown rolls back the given number of applied migrations
+func MigrateDown(db *sql.DB, driver string, steps int) error {
+	return withMigrator(db, driver, func(m *migrate.Migrate) error {
+		if err := m.Steps(-steps); err != nil && !errors.Is(err, migrate.ErrNoChange) {
+			return err
+		}
+		return nil
+	})
+}
+
+// GetMigrationStatus reports the applied and latest schema versions
+func GetMigrationStatus(db *sql.DB, driver string) (*MigrationStatus, error) {
+	latest, err := LatestMigration(driver)
+	if err != nil {
+		return nil, err
+	}
+
+	status := &MigrationStatus{Latest: latest}
+	err = withMigrator(db, driver, func(m *migrate.Migrate) error {
+		var err error
+		status.Version, status.Dirty, err = m.Version()
+		if errors.Is(err, migrate.ErrNilVersion) {
+			return nil
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return status, nil
+}
+
+// CheckSchema fails unless every embedded migration has been applied cleanly
+func CheckSchema(db *sql.DB, driver string) error {
+	status, err := GetMigrationStatus(db, driver)
+	if err != nil {
+		return err
+	}
+
+	if status.Dirty {
+		return fmt.Errorf("database schema version %d is dirty and needs manual repair", status.Version)
+	}
+	if status.Version < status.Latest {
+		return fmt.Errorf("%w: at version %d, want %d", ErrSchemaOutdated, status.Version, status.Latest)
+	}
+	return nil
+}
+
+// LatestMigration returns the newest version among the embedded migrations of driver
+func LatestMigration(driver string) (uint, error) {
+	dir, err := migrationsDir(driver)
+	if err != nil {
+		return 0, err
+	}
+	source, err := iofs.New(Migrations, dir)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read embedded migrations: %w", err)
+	}
+	defer source.Close()
+
+	version, err := source.First()
+	if err != nil {
+		return 0, err
+	}
+	for {
+		next, err := source.Next(version)
+		if errors.Is(err, fs.ErrNotExist) {
+			return version, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		version = next
+	}
+}
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS rooms (
+    id               VARCHAR(255) PRIMARY KEY,
+    name             VARCHAR(255) NOT NULL,
+    owner_id         VARCHAR(255) NOT NULL DEFAULT '',
+    created          TIMESTAMPTZ NOT NULL DEFAULT now(),
+    last_activity    TIMESTAMPTZ NOT NULL DEFAULT now(),
+    max_members      INTEGER NOT NULL DEFAULT 0,
+    allow_spectators BOOLEAN NOT NULL DEFAULT FALSE
+);
+
+CREATE INDEX IF NOT EXISTS rooms_last_activity_idx ON rooms (last_activity DESC);
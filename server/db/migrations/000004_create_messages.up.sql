@@ -0,0 +1,14 @@
+-- Rooms created over the websocket live only in the hub, so room_id is not a
+-- foreign key
+CREATE TABLE IF NOT EXISTS messages (
+    id        VARCHAR(255) PRIMARY KEY,
+    room_id   VARCHAR(255) NOT NULL,
+    user_id   VARCHAR(255) NOT NULL DEFAULT '',
+    username  VARCHAR(255) NOT NULL DEFAULT '',
+    content   TEXT NOT NULL DEFAULT '',
+    type      VARCHAR(50) NOT NULL DEFAULT '',
+    timestamp TIMESTAMPTZ NOT NULL,
+    recipient VARCHAR(255) NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS messages_room_id_timestamp_idx ON messages (room_id, timestamp DESC);
@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS dead_letters (
+    message_id      VARCHAR(255) PRIMARY KEY,
+    room_id         VARCHAR(255) NOT NULL,
+    user_id         VARCHAR(255) NOT NULL DEFAULT '',
+    username        VARCHAR(255) NOT NULL DEFAULT '',
+    content         TEXT NOT NULL DEFAULT '',
+    type            VARCHAR(50) NOT NULL DEFAULT '',
+    timestamp       TIMESTAMPTZ NOT NULL,
+    recipient       VARCHAR(255) NOT NULL DEFAULT '',
+    error           TEXT NOT NULL,
+    attempts        INTEGER NOT NULL DEFAULT 1,
+    first_failed_at TIMESTAMPTZ NOT NULL,
+    last_failed_at  TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS dead_letters_last_failed_at_idx ON dead_letters (last_failed_at DESC);
@@ -0,0 +1,5 @@
+-- Keyset pagination orders room history by (timestamp, id), so the index
+-- includes the id to break ties between messages sent in the same instant
+CREATE INDEX IF NOT EXISTS messages_room_id_timestamp_id_idx ON messages (room_id, timestamp DESC, id DESC);
+
+DROP INDEX IF EXISTS messages_room_id_timestamp_idx;
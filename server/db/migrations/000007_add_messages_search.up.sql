@@ -0,0 +1,6 @@
+-- The search vector is generated from the content, so Postgres keeps it and
+-- its index up to date on every insert and edit
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS search_vector tsvector
+    GENERATED ALWAYS AS (to_tsvector('english', content)) STORED;
+
+CREATE INDEX IF NOT EXISTS messages_search_vector_idx ON messages USING GIN (search_vector);
@@ -0,0 +1,3 @@
+ALTER TABLE rooms
+    DROP COLUMN IF EXISTS allow_spectators,
+    DROP COLUMN IF EXISTS max_members;
@@ -0,0 +1,5 @@
+-- Rooms tables created before the schema was versioned lack the capacity
+-- columns, which 000003 skips because the table already exists
+ALTER TABLE rooms
+    ADD COLUMN IF NOT EXISTS max_members      INTEGER NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS allow_spectators BOOLEAN NOT NULL DEFAULT FALSE;
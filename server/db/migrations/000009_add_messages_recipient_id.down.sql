@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS messages_room_id_user_id_idx;
+
+ALTER TABLE dead_letters DROP COLUMN IF EXISTS recipient_id;
+ALTER TABLE messages DROP COLUMN IF EXISTS recipient_id;
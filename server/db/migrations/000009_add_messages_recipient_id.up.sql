@@ -0,0 +1,7 @@
+-- Private messages are addressed to a user ID as well, since usernames are
+-- chosen by clients and are not unique
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS recipient_id VARCHAR(255) NOT NULL DEFAULT '';
+ALTER TABLE dead_letters ADD COLUMN IF NOT EXISTS recipient_id VARCHAR(255) NOT NULL DEFAULT '';
+
+-- Search looks up whether the searching user has posted in a room
+CREATE INDEX IF NOT EXISTS messages_room_id_user_id_idx ON messages (room_id, user_id);
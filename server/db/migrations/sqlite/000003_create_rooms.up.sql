@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS rooms (
+    id               TEXT PRIMARY KEY,
+    name             TEXT NOT NULL,
+    owner_id         TEXT NOT NULL DEFAULT '',
+    created          TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    last_activity    TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    max_members      INTEGER NOT NULL DEFAULT 0,
+    allow_spectators BOOLEAN NOT NULL DEFAULT FALSE
+);
+
+CREATE INDEX IF NOT EXISTS rooms_last_activity_idx ON rooms (last_activity DESC);
@@ -0,0 +1,14 @@
+-- Rooms created over the websocket live only in the hub, so room_id is not a
+-- foreign key
+CREATE TABLE IF NOT EXISTS messages (
+    id        TEXT PRIMARY KEY,
+    room_id   TEXT NOT NULL,
+    user_id   TEXT NOT NULL DEFAULT '',
+    username  TEXT NOT NULL DEFAULT '',
+    content   TEXT NOT NULL DEFAULT '',
+    type      TEXT NOT NULL DEFAULT '',
+    timestamp TIMESTAMP NOT NULL,
+    recipient TEXT NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS messages_room_id_timestamp_idx ON messages (room_id, timestamp DESC);
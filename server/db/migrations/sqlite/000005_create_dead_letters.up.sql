@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS dead_letters (
+    message_id      TEXT PRIMARY KEY,
+    room_id         TEXT NOT NULL,
+    user_id         TEXT NOT NULL DEFAULT '',
+    username        TEXT NOT NULL DEFAULT '',
+    content         TEXT NOT NULL DEFAULT '',
+    type            TEXT NOT NULL DEFAULT '',
+    timestamp       TIMESTAMP NOT NULL,
+    recipient       TEXT NOT NULL DEFAULT '',
+    error           TEXT NOT NULL,
+    attempts        INTEGER NOT NULL DEFAULT 1,
+    first_failed_at TIMESTAMP NOT NULL,
+    last_failed_at  TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS dead_letters_last_failed_at_idx ON dead_letters (last_failed_at DESC);
@@ -0,0 +1,3 @@
+CREATE INDEX IF NOT EXISTS messages_room_id_timestamp_idx ON messages (room_id, timestamp DESC);
+
+DROP INDEX IF EXISTS messages_room_id_timestamp_id_idx;
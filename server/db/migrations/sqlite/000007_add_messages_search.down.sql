@@ -0,0 +1,6 @@
+DROP TRIGGER IF EXISTS messages_fts_delete;
+DROP TRIGGER IF EXISTS messages_fts_after_update;
+DROP TRIGGER IF EXISTS messages_fts_before_update;
+DROP TRIGGER IF EXISTS messages_fts_insert;
+
+DROP TABLE IF EXISTS messages_fts;
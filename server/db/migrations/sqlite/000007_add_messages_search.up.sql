@@ -0,0 +1,23 @@
+-- Full-text index over message content. It only stores the index and reads
+-- the text from the messages table; the triggers keep it up to date on every
+-- insert, edit and delete.
+CREATE VIRTUAL TABLE IF NOT EXISTS messages_fts USING fts4(content='messages', content, tokenize=porter);
+
+CREATE TRIGGER IF NOT EXISTS messages_fts_insert AFTER INSERT ON messages BEGIN
+    INSERT INTO messages_fts (docid, content) VALUES (new.rowid, new.content);
+END;
+
+CREATE TRIGGER IF NOT EXISTS messages_fts_before_update BEFORE UPDATE OF content ON messages BEGIN
+    DELETE FROM messages_fts WHERE docid = old.rowid;
+END;
+
+CREATE TRIGGER IF NOT EXISTS messages_fts_after_update AFTER UPDATE OF content ON messages BEGIN
+    INSERT INTO messages_fts (docid, content) VALUES (new.rowid, new.content);
+END;
+
+CREATE TRIGGER IF NOT EXISTS messages_fts_delete BEFORE DELETE ON messages BEGIN
+    DELETE FROM messages_fts WHERE docid = old.rowid;
+END;
+
+-- Index the messages stored before this migration
+INSERT INTO messages_fts (messages_fts) VALUES ('rebuild');
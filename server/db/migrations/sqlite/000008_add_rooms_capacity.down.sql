@@ -0,0 +1 @@
+-- SQLite rooms tables have had the capacity columns since 000003
@@ -0,0 +1,217 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+// replicaLagQuery returns how far a replica's replay is behind, in seconds.
+// A replica that has replayed everything it received is not lagging, even if
+// the primary has been idle since its last transaction.
+const replicaLagQuery = `
+	SELECT CASE
+		WHEN pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn() THEN 0
+		ELSE COALESCE(EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp()), 0)
+	END
+`
+
+var errReplicaLagging = errors.New("replica lag exceeds the limit")
+
+// ReplicaConfig configures the read replicas of a ReplicaSet
+type ReplicaConfig struct {
+	HealthInterval time.Duration // How often replicas are checked
+	MaxLag         time.Duration // Replicas further behind are skipped; zero disables the check
+}
+
+type replica struct {
+	db      *sql.DB
+	name    string
+	healthy atomic.Bool
+}
+
+// ReplicaSet routes read-only queries to healthy Postgres read replicas and
+// everything else to the primary. Reads fall back to the primary when no
+// replica is healthy, and when the context asks for it with WithPrimary.
+type ReplicaSet struct {
+	primary  *sql.DB
+	replicas []*replica
+	config   ReplicaConfig
+	next     atomic.Uint32
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewReplicaSet routes reads to the given replica pools, which it closes on
+// Close. Replicas that cannot be reached yet start out unhealthy instead of
+// failing startup.
+func NewReplicaSet(primary *sql.DB, replicas []*sql.DB, config ReplicaConfig) *ReplicaSet {
+	s := &ReplicaSet{
+		primary: primary,
+		config:  config,
+		stop:    make(chan struct{}),
+	}
+	for i, pool := range replicas {
+		s.replicas = append(s.replicas, &replica{db: pool, name: fmt.Sprintf("replica-%d", i)})
+	}
+	s.checkHealth()
+
+	return s
+}
+
+// OpenReplicaSet opens a Postgres pool for every replica DSN
+func OpenReplicaSet(primary *sql.DB, dsns []string, config ReplicaConfig) (*ReplicaSet, error) {
+	var replicas []*sql.DB
+	for _, dsn := range dsns {
+		pool, err := openTraced("postgres", dsn, semconv.DBSystemPostgreSQL)
+		if err != nil {
+			for _, opened := range replicas {
+				opened.Close()
+			}
+			return nil, err
+		}
+		replicas = append(replicas, pool)
+	}
+
+	return NewReplicaSet(primary, replicas, config), nil
+}
+
+// Primary returns the pool of the primary, which takes every write
+func (s *ReplicaSet) Primary() *sql.DB {
+	return s.primary
+}
+
+// Reader returns the pool a read-only query should run on, rotating through
+// the healthy replicas
+func (s *ReplicaSet) Reader(ctx context.Context) *sql.DB {
+	if primaryOnly(ctx) || len(s.replicas) == 0 {
+		return s.primary
+	}
+
+	start := s.next.Add(1)
+	for i := range s.replicas {
+		r := s.replicas[(int(start)+i)%len(s.replicas)]
+		if r.healthy.Load() {
+			return r.db
+		}
+	}
+	return s.primary
+}
+
+// HealthyReplicas returns the number of replicas currently serving reads
+func (s *ReplicaSet) HealthyReplicas() int {
+	healthy := 0
+	for _, r := range s.replicas {
+		if r.healthy.Load() {
+			healthy++
+		}
+	}
+	return healthy
+}
+
+// Run checks the replicas every HealthInterval until Close is called
+func (s *ReplicaSet) Run() {
+	ticker := time.NewTicker(s.config.HealthInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.checkHealth()
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// checkHealth pings every replica and, if MaxLag is set, checks its lag
+func (s *ReplicaSet) checkHealth() {
+	for _, r := range s.replicas {
+		healthy := s.check(r) == nil
+		if was := r.healthy.Swap(healthy); was != healthy {
+			if healthy {
+				log.Printf("Read replica %s is healthy", r.name)
+			} else {
+				log.Printf("Read replica %s is unhealthy, reads fall back to other replicas or the primary", r.name)
+			}
+		}
+	}
+}
+
+func (s *ReplicaSet) check(r *replica) error {
+	timeout := s.config.HealthInterval
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if err := r.db.PingContext(ctx); err != nil {
+		return err
+	}
+	if s.config.MaxLag <= 0 {
+		return nil
+	}
+
+	var lag float64
+	if err := r.db.QueryRowContext(ctx, replicaLagQuery).Scan(&lag); err != nil {
+		return err
+	}
+	if lag > s.config.MaxLag.Seconds() {
+		return errReplicaLagging
+	}
+	return nil
+}
+
+// Close stops the health checks and closes the replica pools. The primary
+// belongs to its Database and is left open.
+func (s *ReplicaSet) Close() {
+	s.stopOnce.Do(func() {
+		close(s.stop)
+		for _, r := range s.replicas {
+			r.db.Close()
+		}
+	})
+}
+
+type primaryKey struct{}
+
+// WithPrimary marks a context whose reads must see the latest writes, so
+// they go to the primary instead of a replica that may lag behind
+func WithPrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, primaryKey{}, true)
+}
+
+func primaryOnly(ctx context.Context) bool {
+	only, _ := ctx.Value(primaryKey{}).(bool)
+	return only
+}
+
+type pinnerKey struct{}
+
+// WithPinner installs the function PinPrimary calls to keep the client of a
+// request on the primary for its following requests, e.g. by setting a
+// cookie
+func WithPinner(ctx context.Context, pin func()) context.Context {
+	return context.WithValue(ctx, pinnerKey{}, pin)
+}
+
+// PinPrimary marks a write: the returned context reads from the primary and
+// the client's following requests are pinned to it as well, if the request
+// carries a pinner. Writes that arrive over GET, such as an OAuth callback or
+// a WebSocket connection, must call it since they cannot be told apart from
+// reads by their method.
+func PinPrimary(ctx context.Context) context.Context {
+	if pin, ok := ctx.Value(pinnerKey{}).(func()); ok {
+		pin()
+	}
+	return WithPrimary(ctx)
+}
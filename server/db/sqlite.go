@@ -0,0 +1,76 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/mattn/go-sqlite3"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+// sqliteDriver is the SQLite driver extended with the functions message
+// search relies on
+const sqliteDriver = "sqlite3_chat"
+
+func init() {
+	sql.Register(sqliteDriver, &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			return conn.RegisterFunc("search_rank", searchRank, true)
+		},
+	})
+}
+
+// searchRank scores a full-text match from matchinfo(..., 'pcx'). The hits of
+// every phrase in the row are weighed by its hits in all rows, so rare terms
+// count for more.
+func searchRank(matchinfo []byte) float64 {
+	info := make([]uint32, len(matchinfo)/4)
+	for i := range info {
+		info[i] = binary.NativeEndian.Uint32(matchinfo[i*4:])
+	}
+	if len(info) < 2 {
+		return 0
+	}
+
+	phrases, columns := int(info[0]), int(info[1])
+	score := 0.0
+	for p := 0; p < phrases; p++ {
+		for c := 0; c < columns; c++ {
+			hits := 2 + 3*(p*columns+c)
+			if hits+1 < len(info) && info[hits+1] > 0 {
+				score += float64(info[hits]) / float64(info[hits+1])
+			}
+		}
+	}
+	return score
+}
+
+// NewSQLiteDatabase opens the SQLite database file at path, creating it and
+// its directory if needed. SQLite allows a single writer, so the pool is
+// limited to one connection and waits for locks instead of failing.
+func NewSQLiteDatabase(path string) (*Database, error) {
+	log.Printf("Opening SQLite database %s...", path)
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, fmt.Errorf("failed to create database directory: %w", err)
+		}
+	}
+
+	db, err := openTraced(sqliteDriver, "file:"+path+"?_foreign_keys=on&_busy_timeout=5000&_journal_mode=WAL", semconv.DBSystemSqlite)
+	if err != nil {
+		log.Printf("Error opening database: %v", err)
+		return nil, err
+	}
+	db.SetMaxOpenConns(1)
+
+	if err := db.Ping(); err != nil {
+		log.Printf("Error pinging database: %v", err)
+		return nil, err
+	}
+
+	return &Database{db: db, driver: DriverSQLite}, nil
+}
@@ -0,0 +1,42 @@
+package apperr
+
+import (
+	"errors"
+	"log"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Middleware renders the last error a handler attached with c.Error as
+// {"error": message, "code": code}, unless the handler already responded.
+// Errors without a kind are logged and rendered as a generic internal error.
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+
+		if len(c.Errors) == 0 || c.Writer.Written() {
+			return
+		}
+
+		err := c.Errors.Last().Err
+		var appErr *Error
+		if !errors.As(err, &appErr) {
+			appErr = &Error{Kind: KindInternal, Code: "internal", Message: "internal server error", Err: err}
+		}
+
+		if appErr.Kind == KindInternal {
+			log.Printf("%s %s: %v", c.Request.Method, c.Request.URL.Path, appErr)
+		}
+		if appErr.Kind == KindUnavailable {
+			c.Header("Retry-After", "1")
+		}
+
+		c.JSON(appErr.Kind.HTTPStatus(), gin.H{"error": appErr.Message, "code": appErr.Code})
+	}
+}
+
+// Abort attaches err to the request and stops the handler chain
+func Abort(c *gin.Context, err error) {
+	c.Error(err)
+	c.Abort()
+}
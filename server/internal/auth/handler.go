@@ -4,32 +4,60 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
-	"os"
+
+	"server/db"
+	"server/internal/apperr"
 
 	"github.com/gin-gonic/gin"
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
 )
 
+// googleUserInfoURL returns the profile of the user a Google token belongs to
+const googleUserInfoURL = "https://www.googleapis.com/oauth2/v2/userinfo"
+
 type Handler struct {
 	googleOauthConfig *oauth2.Config
+	userInfoURL       string
+	clientOrigin      string
 	service          Service
 }
 
-func NewHandler(service Service) *Handler {
+// OAuthConfig holds the Google OAuth client and the frontend origin the
+// login popup reports back to
+type OAuthConfig struct {
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	ClientOrigin string
+	// Endpoint and UserInfoURL default to Google's
+	Endpoint    oauth2.Endpoint
+	UserInfoURL string
+}
+
+func NewHandler(service Service, oauthConfig OAuthConfig) *Handler {
 	config := &oauth2.Config{
-		ClientID:     os.Getenv("GOOGLE_OAUTH_CLIENT_ID"),
-		ClientSecret: os.Getenv("GOOGLE_OAUTH_CLIENT_SECRET"),
-		RedirectURL:  os.Getenv("GOOGLE_OAUTH_REDIRECT_URL"),
+		ClientID:     oauthConfig.ClientID,
+		ClientSecret: oauthConfig.ClientSecret,
+		RedirectURL:  oauthConfig.RedirectURL,
 		Scopes: []string{
 			"https://www.googleapis.com/auth/userinfo.email",
 			"https://www.googleapis.com/auth/userinfo.profile",
 		},
 		Endpoint: google.Endpoint,
 	}
+	if oauthConfig.Endpoint.TokenURL != "" {
+		config.Endpoint = oauthConfig.Endpoint
+	}
+	userInfoURL := oauthConfig.UserInfoURL
+	if userInfoURL == "" {
+		userInfoURL = googleUserInfoURL
+	}
 
 	return &Handler{
 		googleOauthConfig: config,
+		userInfoURL:       userInfoURL,
+		clientOrigin:      oauthConfig.ClientOrigin,
 		service:          service,
 	}
 }
@@ -37,19 +65,19 @@ func NewHandler(service Service) *Handler {
 func (h *Handler) Signup(c *gin.Context) {
 	var req SignupRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		c.Error(apperr.Validation("invalid_request", "Invalid request"))
 		return
 	}
 
 	user, err := h.service.Signup(c.Request.Context(), &req)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		c.Error(apperr.Internal(err, "signup_failed", "Failed to sign up"))
 		return
 	}
 
 	session, err := h.service.CreateSession(c.Request.Context(), user.ID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create session"})
+		c.Error(apperr.Internal(err, "session_create_failed", "Failed to create session"))
 		return
 	}
 
@@ -60,19 +88,19 @@ func (h *Handler) Signup(c *gin.Context) {
 func (h *Handler) Login(c *gin.Context) {
 	var req LoginRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		c.Error(apperr.Validation("invalid_request", "Invalid request"))
 		return
 	}
 
 	user, err := h.service.Login(c.Request.Context(), &req)
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		c.Error(apperr.Internal(err, "login_failed", "Failed to log in"))
 		return
 	}
 
 	session, err := h.service.CreateSession(c.Request.Context(), user.ID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create session"})
+		c.Error(apperr.Internal(err, "session_create_failed", "Failed to create session"))
 		return
 	}
 
@@ -89,14 +117,14 @@ func (h *Handler) GoogleCallback(c *gin.Context) {
 	code := c.Query("code")
 	token, err := h.googleOauthConfig.Exchange(c, code)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to exchange token"})
+		c.Error(apperr.Unauthorized("oauth_exchange_failed", "Failed to exchange token").Wrap(err))
 		return
 	}
 
 	client := h.googleOauthConfig.Client(c, token)
-	resp, err := client.Get("https://www.googleapis.com/oauth2/v2/userinfo")
+	resp, err := client.Get(h.userInfoURL)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get user info"})
+		c.Error(apperr.Internal(err, "oauth_userinfo_failed", "Failed to get user info"))
 		return
 	}
 	defer resp.Body.Close()
@@ -109,11 +137,14 @@ func (h *Handler) GoogleCallback(c *gin.Context) {
 	}
 
 	if err := json.NewDecoder(resp.Body).Decode(&userInfo); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to decode user info"})
+		c.Error(apperr.Internal(err, "oauth_userinfo_failed", "Failed to decode user info"))
 		return
 	}
 
-	user, err := h.service.UpsertUser(c, &User{
+	// The login is written over a GET, so the client's next requests must
+	// read the new session from the primary
+	ctx := db.PinPrimary(c.Request.Context())
+	user, err := h.service.UpsertUser(ctx, &User{
 		Email:    userInfo.Email,
 		Name:     userInfo.Name,
 		Picture:  userInfo.Picture,
@@ -121,14 +152,14 @@ func (h *Handler) GoogleCallback(c *gin.Context) {
 	})
 
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create/update user"})
+		c.Error(apperr.Internal(err, "user_upsert_failed", "Failed to create/update user"))
 		return
 	}
 
 	// Create session
-	session, err := h.service.CreateSession(c, user.ID)
+	session, err := h.service.CreateSession(ctx, user.ID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create session"})
+		c.Error(apperr.Internal(err, "session_create_failed", "Failed to create session"))
 		return
 	}
 
@@ -136,7 +167,12 @@ func (h *Handler) GoogleCallback(c *gin.Context) {
 
 	userJSON, err := json.Marshal(user)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to marshal user data"})
+		c.Error(apperr.Internal(err, "internal", "Failed to marshal user data"))
+		return
+	}
+	originJSON, err := json.Marshal(h.clientOrigin)
+	if err != nil {
+		c.Error(apperr.Internal(err, "internal", "Failed to marshal client origin"))
 		return
 	}
 
@@ -144,12 +180,12 @@ func (h *Handler) GoogleCallback(c *gin.Context) {
 		<html>
 		<body>
 			<script>
-				window.opener.postMessage({ type: 'oauth_success', user: %s }, 'http://localhost:3000');
+				window.opener.postMessage({ type: 'oauth_success', user: %s }, %s);
 				window.close();
 			</script>
 		</body>
 		</html>
-	`, string(userJSON))
+	`, string(userJSON), string(originJSON))
 
 	c.Header("Content-Type", "text/html")
 	c.String(http.StatusOK, html)
@@ -158,29 +194,78 @@ func (h *Handler) GoogleCallback(c *gin.Context) {
 func (h *Handler) GetMe(c *gin.Context) {
 	token, err := c.Cookie("session_token")
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "No session found"})
+		c.Error(apperr.Unauthorized("no_session", "No session found"))
 		return
 	}
 
 	user, err := h.service.GetUserBySession(c.Request.Context(), token)
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid session"})
+		c.Error(apperr.Internal(err, "session_lookup_failed", "Failed to look up session"))
 		return
 	}
 
 	c.JSON(http.StatusOK, user)
 }
 
+// userContextKey stores the signed-in user in the gin context
+const userContextKey = "auth.user"
+
+// RequireSession rejects requests without a valid session and makes the
+// signed-in user available through CurrentUser
+func (h *Handler) RequireSession(c *gin.Context) {
+	token, err := c.Cookie("session_token")
+	if err != nil {
+		apperr.Abort(c, apperr.Unauthorized("no_session", "No session found"))
+		return
+	}
+
+	user, err := h.service.GetUserBySession(c.Request.Context(), token)
+	if err != nil {
+		apperr.Abort(c, apperr.Internal(err, "session_lookup_failed", "Failed to look up session"))
+		return
+	}
+
+	c.Set(userContextKey, user)
+	c.Next()
+}
+
+// OptionalSession makes the signed-in user available through CurrentUser when
+// the request carries a valid session and lets anonymous requests through
+func (h *Handler) OptionalSession(c *gin.Context) {
+	token, err := c.Cookie("session_token")
+	if err != nil {
+		c.Next()
+		return
+	}
+
+	user, err := h.service.GetUserBySession(c.Request.Context(), token)
+	switch {
+	case err == nil:
+		c.Set(userContextKey, user)
+	case apperr.KindOf(err) != apperr.KindUnauthorized:
+		apperr.Abort(c, apperr.Internal(err, "session_lookup_failed", "Failed to look up session"))
+		return
+	}
+	c.Next()
+}
+
+// CurrentUser returns the user RequireSession or OptionalSession admitted, or nil
+func CurrentUser(c *gin.Context) *User {
+	user, _ := c.Get(userContextKey)
+	u, _ := user.(*User)
+	return u
+}
+
 func (h *Handler) Logout(c *gin.Context) {
 	token, err := c.Cookie("session_token")
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "No session found"})
+		c.Error(apperr.Validation("no_session", "No session found"))
 		return
 	}
 
 	err = h.service.DeleteSession(c.Request.Context(), token)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete session"})
+		c.Error(apperr.Internal(err, "session_delete_failed", "Failed to delete session"))
 		return
 	}
 
@@ -191,13 +276,13 @@ func (h *Handler) Logout(c *gin.Context) {
 func (h *Handler) GetCurrentUser(c *gin.Context) {
 	sessionToken, err := c.Cookie("session_token")
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "No session found"})
+		c.Error(apperr.Unauthorized("no_session", "No session found"))
 		return
 	}
 
 	user, err := h.service.GetUserBySession(c, sessionToken)
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid session"})
+		c.Error(apperr.Internal(err, "session_lookup_failed", "Failed to look up session"))
 		return
 	}
 
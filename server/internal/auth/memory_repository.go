@@ -0,0 +1,114 @@
+package auth
+
+import (
+	"context"
+	"sync"
+)
+
+// MemoryRepository implements Repository in process memory with the same
+// semantics as PostgresRepository, for tests and runs without a database
+type MemoryRepository struct {
+	mu       sync.RWMutex
+	users    map[string]*User // keyed by ID
+	sessions map[string]*Session
+}
+
+// NewMemoryRepository creates an empty in-memory repository
+func NewMemoryRepository() *MemoryRepository {
+	return &MemoryRepository{
+		users:    make(map[string]*User),
+		sessions: make(map[string]*Session),
+	}
+}
+
+// UpsertUser creates a user or updates the one with the same email. Empty
+// password hashes and Google IDs keep the stored value.
+func (r *MemoryRepository) UpsertUser(ctx context.Context, user *User) (*User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, existing := range r.users {
+		if existing.Email != user.Email {
+			continue
+		}
+		existing.Name = user.Name
+		existing.Picture = user.Picture
+		if user.PasswordHash != "" {
+			existing.PasswordHash = user.PasswordHash
+		}
+		if user.GoogleID != "" {
+			existing.GoogleID = user.GoogleID
+		}
+		result := *existing
+		return &result, nil
+	}
+
+	stored := *user
+	r.users[stored.ID] = &stored
+	result := stored
+	return &result, nil
+}
+
+// GetUserByID returns a user or ErrUserNotFound
+func (r *MemoryRepository) GetUserByID(ctx context.Context, id string) (*User, error) {
+	return r.findUser(func(u *User) bool { return u.ID == id })
+}
+
+// GetUserByEmail returns a user or ErrUserNotFound
+func (r *MemoryRepository) GetUserByEmail(ctx context.Context, email string) (*User, error) {
+	return r.findUser(func(u *User) bool { return u.Email == email })
+}
+
+// GetUserByGoogleID returns a user or ErrUserNotFound
+func (r *MemoryRepository) GetUserByGoogleID(ctx context.Context, googleID string) (*User, error) {
+	if googleID == "" {
+		return nil, ErrUserNotFound
+	}
+	return r.findUser(func(u *User) bool { return u.GoogleID == googleID })
+}
+
+func (r *MemoryRepository) findUser(match func(u *User) bool) (*User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, stored := range r.users {
+		if match(stored) {
+			user := *stored
+			return &user, nil
+		}
+	}
+	return nil, ErrUserNotFound
+}
+
+// CreateSession stores a session
+func (r *MemoryRepository) CreateSession(ctx context.Context, session *Session) (*Session, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored := *session
+	r.sessions[stored.Token] = &stored
+	result := stored
+	return &result, nil
+}
+
+// GetSessionByToken returns a session or ErrSessionNotFound
+func (r *MemoryRepository) GetSessionByToken(ctx context.Context, token string) (*Session, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	stored, ok := r.sessions[token]
+	if !ok {
+		return nil, ErrSessionNotFound
+	}
+	session := *stored
+	return &session, nil
+}
+
+// DeleteSession removes a session if it exists
+func (r *MemoryRepository) DeleteSession(ctx context.Context, token string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.sessions, token)
+	return nil
+}
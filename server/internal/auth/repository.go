@@ -3,6 +3,15 @@ package auth
 import (
 	"context"
 	"database/sql"
+	"errors"
+
+	"server/db"
+	"server/internal/apperr"
+)
+
+var (
+	ErrUserNotFound    = apperr.NotFound("user_not_found", "user not found")
+	ErrSessionNotFound = apperr.NotFound("session_not_found", "session not found")
 )
 
 type Repository interface {
@@ -16,19 +25,34 @@ type Repository interface {
 }
 
 type PostgresRepository struct {
-	db *sql.DB
+	db       *sql.DB
+	replicas *db.ReplicaSet
 }
 
-func NewRepository(db *sql.DB) Repository {
+func NewRepository(db *sql.DB) *PostgresRepository {
 	return &PostgresRepository{db: db}
 }
 
+// UseReplicas sends session lookups to read replicas. Everything else,
+// including the lookups done before a user is created, stays on the primary.
+func (r *PostgresRepository) UseReplicas(replicas *db.ReplicaSet) {
+	r.replicas = replicas
+}
+
+// reader returns the pool a read-only query runs on
+func (r *PostgresRepository) reader(ctx context.Context) *sql.DB {
+	if r.replicas == nil {
+		return r.db
+	}
+	return r.replicas.Reader(ctx)
+}
+
 func (r *PostgresRepository) UpsertUser(ctx context.Context, user *User) (*User, error) {
 	query := `
 		INSERT INTO users (id, email, name, picture, password_hash, google_id, created_at)
 		VALUES ($1, $2, $3, $4, $5, $6, $7)
 		ON CONFLICT (email) DO UPDATE
-		SET name = $3, picture = $4, password_hash = COALESCE($5, users.password_hash), google_id = COALESCE($6, users.google_id)
+		SET name = $3, picture = $4, password_hash = COALESCE(NULLIF($5, ''), users.password_hash), google_id = COALESCE(NULLIF($6, ''), users.google_id)
 		RETURNING id, email, name, picture, password_hash, google_id, created_at
 	`
 
@@ -68,7 +92,7 @@ func (r *PostgresRepository) GetUserByID(ctx context.Context, id string) (*User,
 	`
 
 	var user User
-	err := r.db.QueryRowContext(ctx, query, id).Scan(
+	err := r.reader(ctx).QueryRowContext(ctx, query, id).Scan(
 		&user.ID,
 		&user.Email,
 		&user.Name,
@@ -78,6 +102,9 @@ func (r *PostgresRepository) GetUserByID(ctx context.Context, id string) (*User,
 		&user.CreatedAt,
 	)
 
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrUserNotFound
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -103,6 +130,9 @@ func (r *PostgresRepository) GetUserByEmail(ctx context.Context, email string) (
 		&user.CreatedAt,
 	)
 
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrUserNotFound
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -114,7 +144,7 @@ func (r *PostgresRepository) GetUserByGoogleID(ctx context.Context, googleID str
 	query := `
 		SELECT id, email, name, picture, password_hash, google_id, created_at
 		FROM users
-		WHERE google_id = $1
+		WHERE google_id = $1 AND google_id <> ''
 	`
 
 	var user User
@@ -128,6 +158,9 @@ func (r *PostgresRepository) GetUserByGoogleID(ctx context.Context, googleID str
 		&user.CreatedAt,
 	)
 
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrUserNotFound
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -172,13 +205,16 @@ func (r *PostgresRepository) GetSessionByToken(ctx context.Context, token string
 	`
 
 	var session Session
-	err := r.db.QueryRowContext(ctx, query, token).Scan(
+	err := r.reader(ctx).QueryRowContext(ctx, query, token).Scan(
 		&session.ID,
 		&session.UserID,
 		&session.Token,
 		&session.ExpiresAt,
 	)
 
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrSessionNotFound
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -2,9 +2,12 @@ package auth
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"time"
 
+	"server/internal/apperr"
+
 	"github.com/google/uuid"
 	"golang.org/x/crypto/bcrypt"
 )
@@ -37,6 +40,13 @@ type Session struct {
 	ExpiresAt time.Time `json:"expires_at"`
 }
 
+var (
+	ErrEmailTaken         = apperr.Conflict("email_taken", "a user with this email already exists")
+	ErrInvalidCredentials = apperr.Unauthorized("invalid_credentials", "invalid email or password")
+	ErrInvalidSession     = apperr.Unauthorized("invalid_session", "invalid session")
+	ErrSessionExpired     = apperr.Unauthorized("session_expired", "session expired")
+)
+
 type Service interface {
 	Signup(ctx context.Context, req *SignupRequest) (*User, error)
 	Login(ctx context.Context, req *LoginRequest) (*User, error)
@@ -60,7 +70,10 @@ func (s *DefaultService) Signup(ctx context.Context, req *SignupRequest) (*User,
 	// Check if user already exists
 	existingUser, err := s.repo.GetUserByEmail(ctx, req.Email)
 	if err == nil && existingUser != nil {
-		return nil, fmt.Errorf("user with email %s already exists", req.Email)
+		return nil, ErrEmailTaken
+	}
+	if err != nil && !errors.Is(err, ErrUserNotFound) {
+		return nil, err
 	}
 
 	// Hash password
@@ -84,14 +97,17 @@ func (s *DefaultService) Signup(ctx context.Context, req *SignupRequest) (*User,
 func (s *DefaultService) Login(ctx context.Context, req *LoginRequest) (*User, error) {
 	// Get user by email
 	user, err := s.repo.GetUserByEmail(ctx, req.Email)
+	if errors.Is(err, ErrUserNotFound) || (err == nil && user == nil) {
+		return nil, ErrInvalidCredentials
+	}
 	if err != nil {
-		return nil, fmt.Errorf("invalid email or password")
+		return nil, err
 	}
 
 	// Check password
 	err = bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.Password))
 	if err != nil {
-		return nil, fmt.Errorf("invalid email or password")
+		return nil, ErrInvalidCredentials
 	}
 
 	return user, nil
@@ -125,16 +141,23 @@ func (s *DefaultService) CreateSession(ctx context.Context, userID string) (*Ses
 
 func (s *DefaultService) GetUserBySession(ctx context.Context, token string) (*User, error) {
 	session, err := s.repo.GetSessionByToken(ctx, token)
+	if errors.Is(err, ErrSessionNotFound) || (err == nil && session == nil) {
+		return nil, ErrInvalidSession
+	}
 	if err != nil {
 		return nil, err
 	}
 
 	if time.Now().After(session.ExpiresAt) {
 		s.repo.DeleteSession(ctx, token)
-		return nil, err
+		return nil, ErrSessionExpired
 	}
 
-	return s.repo.GetUserByID(ctx, session.UserID)
+	user, err := s.repo.GetUserByID(ctx, session.UserID)
+	if errors.Is(err, ErrUserNotFound) || (err == nil && user == nil) {
+		return nil, ErrInvalidSession
+	}
+	return user, err
 }
 
 func (s *DefaultService) DeleteSession(ctx context.Context, token string) error {
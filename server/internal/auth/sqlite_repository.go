@@ -0,0 +1,135 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+)
+
+// SQLiteRepository implements Repository using SQLite. Times are stored in UTC.
+type SQLiteRepository struct {
+	db *sql.DB
+}
+
+func NewSQLiteRepository(db *sql.DB) Repository {
+	return &SQLiteRepository{db: db}
+}
+
+const sqliteUserColumns = `id, email, name, picture, password_hash, google_id, created_at`
+
+func (r *SQLiteRepository) UpsertUser(ctx context.Context, user *User) (*User, error) {
+	query := `
+		INSERT INTO users (` + sqliteUserColumns + `)
+		VALUES (?1, ?2, ?3, ?4, ?5, ?6, ?7)
+		ON CONFLICT (email) DO UPDATE
+		SET name = ?3, picture = ?4, password_hash = COALESCE(NULLIF(?5, ''), users.password_hash), google_id = COALESCE(NULLIF(?6, ''), users.google_id)
+		RETURNING ` + sqliteUserColumns
+
+	return scanUser(r.db.QueryRowContext(
+		ctx,
+		query,
+		user.ID,
+		user.Email,
+		user.Name,
+		user.Picture,
+		user.PasswordHash,
+		user.GoogleID,
+		user.CreatedAt.UTC(),
+	))
+}
+
+func (r *SQLiteRepository) GetUserByID(ctx context.Context, id string) (*User, error) {
+	return r.getUser(ctx, `SELECT `+sqliteUserColumns+` FROM users WHERE id = ?`, id)
+}
+
+func (r *SQLiteRepository) GetUserByEmail(ctx context.Context, email string) (*User, error) {
+	return r.getUser(ctx, `SELECT `+sqliteUserColumns+` FROM users WHERE email = ?`, email)
+}
+
+func (r *SQLiteRepository) GetUserByGoogleID(ctx context.Context, googleID string) (*User, error) {
+	return r.getUser(ctx, `SELECT `+sqliteUserColumns+` FROM users WHERE google_id = ? AND google_id <> ''`, googleID)
+}
+
+func (r *SQLiteRepository) getUser(ctx context.Context, query string, arg string) (*User, error) {
+	user, err := scanUser(r.db.QueryRowContext(ctx, query, arg))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrUserNotFound
+	}
+	return user, err
+}
+
+func scanUser(row *sql.Row) (*User, error) {
+	var user User
+	err := row.Scan(
+		&user.ID,
+		&user.Email,
+		&user.Name,
+		&user.Picture,
+		&user.PasswordHash,
+		&user.GoogleID,
+		&user.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (r *SQLiteRepository) CreateSession(ctx context.Context, session *Session) (*Session, error) {
+	query := `
+		INSERT INTO sessions (id, user_id, token, expires_at)
+		VALUES (?, ?, ?, ?)
+		RETURNING id, user_id, token, expires_at
+	`
+
+	var result Session
+	err := r.db.QueryRowContext(
+		ctx,
+		query,
+		session.ID,
+		session.UserID,
+		session.Token,
+		session.ExpiresAt.UTC(),
+	).Scan(
+		&result.ID,
+		&result.UserID,
+		&result.Token,
+		&result.ExpiresAt,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+func (r *SQLiteRepository) GetSessionByToken(ctx context.Context, token string) (*Session, error) {
+	query := `
+		SELECT id, user_id, token, expires_at
+		FROM sessions
+		WHERE token = ?
+	`
+
+	var session Session
+	err := r.db.QueryRowContext(ctx, query, token).Scan(
+		&session.ID,
+		&session.UserID,
+		&session.Token,
+		&session.ExpiresAt,
+	)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrSessionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &session, nil
+}
+
+func (r *SQLiteRepository) DeleteSession(ctx context.Context, token string) error {
+	_, err := r.db.ExecContext(ctx, `DELETE FROM sessions WHERE token = ?`, token)
+	return err
+}
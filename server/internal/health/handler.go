@@ -0,0 +1,45 @@
+package health
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Handler serves the liveness, readiness and status endpoints
+type Handler struct {
+	checker *Checker
+}
+
+func NewHandler(checker *Checker) *Handler {
+	return &Handler{checker: checker}
+}
+
+// Live reports that the process is up and serving HTTP. It checks no
+// dependencies, so a supervisor only restarts the server when it hangs.
+func (h *Handler) Live(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": StatusUp})
+}
+
+// Ready reports whether the server can serve traffic, answering 503 while a
+// critical dependency is down so load balancers route around it
+func (h *Handler) Ready(c *gin.Context) {
+	report := h.checker.Run(c.Request.Context())
+
+	checks := make(map[string]Status, len(report.Checks))
+	for _, result := range report.Checks {
+		checks[result.Name] = result.Status
+	}
+
+	code := http.StatusOK
+	if !report.Ready() {
+		code = http.StatusServiceUnavailable
+	}
+	c.JSON(code, gin.H{"status": report.Status, "checks": checks})
+}
+
+// Status reports the latency, status and error of every dependency for
+// operators
+func (h *Handler) Status(c *gin.Context) {
+	c.JSON(http.StatusOK, h.checker.Run(c.Request.Context()))
+}
@@ -0,0 +1,166 @@
+// Package health probes the dependencies of the server for the liveness,
+// readiness and status endpoints.
+package health
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/sony/gobreaker"
+)
+
+// Status is the health of a single dependency or of the whole server
+type Status string
+
+const (
+	StatusUp       Status = "up"
+	StatusDegraded Status = "degraded" // Working, but slow or with reduced capacity
+	StatusDown     Status = "down"
+)
+
+// Check probes a dependency. An error wrapped with Degraded reports the
+// dependency as degraded instead of down.
+type Check func(ctx context.Context) error
+
+type degradedError struct {
+	err error
+}
+
+func (e *degradedError) Error() string { return e.err.Error() }
+func (e *degradedError) Unwrap() error { return e.err }
+
+// Degraded marks a check error as reduced capacity rather than an outage
+func Degraded(err error) error {
+	return &degradedError{err: err}
+}
+
+// Config configures a Checker
+type Config struct {
+	Timeout       time.Duration // Deadline of every check
+	SlowThreshold time.Duration // Checks answering slower are degraded; zero disables
+}
+
+type dependency struct {
+	name     string
+	critical bool
+	check    Check
+}
+
+// Checker runs the registered checks of the server's dependencies
+type Checker struct {
+	config       Config
+	dependencies []dependency
+}
+
+// NewChecker creates a checker without any dependencies
+func NewChecker(config Config) *Checker {
+	return &Checker{config: config}
+}
+
+// Register adds a dependency. The server is not ready while a critical
+// dependency is down; other dependencies only degrade it. Register must be
+// called before the checker is used.
+func (c *Checker) Register(name string, critical bool, check Check) {
+	c.dependencies = append(c.dependencies, dependency{name: name, critical: critical, check: check})
+}
+
+// Result is the outcome of one check
+type Result struct {
+	Name      string  `json:"name"`
+	Status    Status  `json:"status"`
+	Critical  bool    `json:"critical"`
+	LatencyMS float64 `json:"latencyMs"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// Report is the outcome of every check
+type Report struct {
+	Status    Status    `json:"status"`
+	CheckedAt time.Time `json:"checkedAt"`
+	Checks    []Result  `json:"checks"`
+}
+
+// Ready reports whether every critical dependency is up or degraded
+func (r *Report) Ready() bool {
+	return r.Status != StatusDown
+}
+
+// Run checks every dependency concurrently. The server is down when a
+// critical dependency is down, and degraded when any dependency is not up.
+func (c *Checker) Run(ctx context.Context) *Report {
+	report := &Report{
+		Status:    StatusUp,
+		CheckedAt: time.Now().UTC(),
+		Checks:    make([]Result, len(c.dependencies)),
+	}
+
+	var wg sync.WaitGroup
+	for i, dep := range c.dependencies {
+		wg.Add(1)
+		go func(i int, dep dependency) {
+			defer wg.Done()
+			report.Checks[i] = c.run(ctx, dep)
+		}(i, dep)
+	}
+	wg.Wait()
+
+	for _, result := range report.Checks {
+		switch {
+		case result.Status == StatusDown && result.Critical:
+			report.Status = StatusDown
+		case result.Status != StatusUp && report.Status == StatusUp:
+			report.Status = StatusDegraded
+		}
+	}
+	return report
+}
+
+func (c *Checker) run(ctx context.Context, dep dependency) Result {
+	if c.config.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.config.Timeout)
+		defer cancel()
+	}
+
+	start := time.Now()
+	err := dep.check(ctx)
+	latency := time.Since(start)
+
+	result := Result{
+		Name:      dep.name,
+		Status:    StatusUp,
+		Critical:  dep.critical,
+		LatencyMS: float64(latency.Microseconds()) / 1000,
+	}
+	var degraded *degradedError
+	switch {
+	case errors.As(err, &degraded):
+		result.Status = StatusDegraded
+		result.Error = err.Error()
+	case err != nil:
+		result.Status = StatusDown
+		result.Error = err.Error()
+	case c.config.SlowThreshold > 0 && latency > c.config.SlowThreshold:
+		result.Status = StatusDegraded
+		result.Error = "slower than " + c.config.SlowThreshold.String()
+	}
+	return result
+}
+
+var errBreakerOpen = errors.New("circuit breaker is open")
+
+// Breaker checks a circuit breaker: an open breaker is down and a half-open
+// one is degraded
+func Breaker(state func() gobreaker.State) Check {
+	return func(ctx context.Context) error {
+		switch state() {
+		case gobreaker.StateOpen:
+			return errBreakerOpen
+		case gobreaker.StateHalfOpen:
+			return Degraded(errors.New("circuit breaker is half-open"))
+		}
+		return nil
+	}
+}
@@ -0,0 +1,139 @@
+package message
+
+import (
+	"net/http"
+	"strconv"
+
+	"server/internal/apperr"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AdminHandler exposes operational endpoints for message persistence
+type AdminHandler struct {
+	spool       *Spool
+	deadLetters *DeadLetterService
+	cache       *TieredCache
+	cacheGuard  *ResilientCache
+}
+
+func NewAdminHandler(spool *Spool, deadLetters *DeadLetterService) *AdminHandler {
+	return &AdminHandler{
+		spool:       spool,
+		deadLetters: deadLetters,
+	}
+}
+
+// UseCache exposes the two-tier cache counters through GetCache
+func (h *AdminHandler) UseCache(cache *TieredCache) {
+	h.cache = cache
+}
+
+// UseCacheBreaker exposes the cache circuit breaker state through GetCache
+func (h *AdminHandler) UseCacheBreaker(cache *ResilientCache) {
+	h.cacheGuard = cache
+}
+
+// GetCache reports hit and miss counters for each cache tier and whether the
+// cache is bypassed because its circuit breaker tripped
+func (h *AdminHandler) GetCache(c *gin.Context) {
+	res := gin.H{"cache": TieredCacheStats{}, "enabled": h.cache != nil, "degraded": false}
+	if h.cache != nil {
+		res["cache"] = h.cache.Stats()
+	}
+	if h.cacheGuard != nil {
+		res["breaker"] = h.cacheGuard.BreakerState().String()
+		res["degraded"] = h.cacheGuard.Degraded()
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+// GetSpool reports how many messages are waiting in the on-disk spool
+func (h *AdminHandler) GetSpool(c *gin.Context) {
+	if h.spool == nil {
+		c.JSON(http.StatusOK, gin.H{"spool": SpoolStats{}, "enabled": false})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"spool": h.spool.Stats(), "enabled": true})
+}
+
+// ListDeadLetters retrieves a page of dead-lettered messages
+func (h *AdminHandler) ListDeadLetters(c *gin.Context) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil {
+		limit = 50
+	}
+
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil {
+		offset = 0
+	}
+
+	deadLetters, err := h.deadLetters.List(c.Request.Context(), limit, offset)
+	if err != nil {
+		c.Error(apperr.Internal(err, "dead_letters_unavailable", "failed to retrieve dead letters"))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"deadLetters": deadLetters})
+}
+
+// GetDeadLetter retrieves a single dead-lettered message
+func (h *AdminHandler) GetDeadLetter(c *gin.Context) {
+	deadLetter, err := h.deadLetters.Get(c.Request.Context(), c.Param("messageId"))
+	if err != nil {
+		c.Error(apperr.Internal(err, "dead_letter_unavailable", "failed to retrieve dead letter"))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"deadLetter": deadLetter})
+}
+
+// UpdateDeadLetter edits a dead-lettered message before it is replayed. Only
+// the fields present in the request body are changed.
+func (h *AdminHandler) UpdateDeadLetter(c *gin.Context) {
+	messageID := c.Param("messageId")
+
+	deadLetter, err := h.deadLetters.Get(c.Request.Context(), messageID)
+	if err != nil {
+		c.Error(apperr.Internal(err, "dead_letter_unavailable", "failed to retrieve dead letter"))
+		return
+	}
+
+	message := deadLetter.Message
+	if err := c.ShouldBindJSON(&message); err != nil {
+		c.Error(apperr.Validation("invalid_request", err.Error()))
+		return
+	}
+
+	deadLetter, err = h.deadLetters.Update(c.Request.Context(), messageID, &message)
+	if err != nil {
+		c.Error(apperr.Internal(err, "dead_letter_update_failed", "failed to update dead letter"))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"deadLetter": deadLetter})
+}
+
+// ReplayDeadLetter persists a dead-lettered message again
+func (h *AdminHandler) ReplayDeadLetter(c *gin.Context) {
+	message, err := h.deadLetters.Replay(c.Request.Context(), c.Param("messageId"))
+	if err != nil {
+		c.Error(apperr.Internal(err, "dead_letter_replay_failed", "failed to replay dead letter"))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": message})
+}
+
+// DiscardDeadLetter drops a dead-lettered message for good
+func (h *AdminHandler) DiscardDeadLetter(c *gin.Context) {
+	if err := h.deadLetters.Discard(c.Request.Context(), c.Param("messageId")); err != nil {
+		c.Error(apperr.Internal(err, "dead_letter_discard_failed", "failed to discard dead letter"))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "dead letter discarded"})
+}
@@ -0,0 +1,107 @@
+package message
+
+import (
+	"context"
+	"time"
+
+	"server/internal/apperr"
+)
+
+// ErrOverloaded is returned when an operation group has no free slot and the
+// call was rejected instead of queued behind the others
+var ErrOverloaded = apperr.Unavailable("overloaded", "service is overloaded")
+
+// OperationGroup is a set of operations sharing one bulkhead
+type OperationGroup string
+
+const (
+	GroupWrites  OperationGroup = "writes"
+	GroupHistory OperationGroup = "history"
+	GroupRooms   OperationGroup = "rooms"
+)
+
+// operationGroups assigns every operation to the bulkhead it draws from
+var operationGroups = map[Operation]OperationGroup{
+	OpSaveMessage:        GroupWrites,
+	OpSaveMessages:       GroupWrites,
+	OpCreateRoom:         GroupWrites,
+	OpUpdateRoomActivity: GroupWrites,
+	OpGetMessagesByRoom:  GroupHistory,
+	OpGetRoomHistory:     GroupHistory,
+	OpGetMessageByID:     GroupHistory,
+	OpSearchMessages:     GroupHistory,
+	OpGetRooms:           GroupRooms,
+	OpGetRoomByID:        GroupRooms,
+}
+
+// BulkheadConfig limits the calls of one operation group
+type BulkheadConfig struct {
+	MaxConcurrent int           // calls in flight at once; zero means unlimited
+	MaxWait       time.Duration // how long a call waits for a free slot
+	Timeout       time.Duration // deadline of a whole call, retries included; zero means none
+}
+
+// Bulkhead caps the number of concurrent calls so one group of operations
+// cannot take every database connection from the others
+type Bulkhead struct {
+	slots   chan struct{}
+	maxWait time.Duration
+	timeout time.Duration
+}
+
+// NewBulkhead creates a bulkhead from its configuration
+func NewBulkhead(config BulkheadConfig) *Bulkhead {
+	b := &Bulkhead{
+		maxWait: config.MaxWait,
+		timeout: config.Timeout,
+	}
+	if config.MaxConcurrent > 0 {
+		b.slots = make(chan struct{}, config.MaxConcurrent)
+	}
+	return b
+}
+
+// InFlight returns the number of calls currently holding a slot
+func (b *Bulkhead) InFlight() int {
+	return len(b.slots)
+}
+
+// acquire takes a slot, waiting at most maxWait for one to free up. The
+// returned release func must be called once the call is done.
+func (b *Bulkhead) acquire(ctx context.Context) (func(), error) {
+	if b.slots == nil {
+		return func() {}, nil
+	}
+
+	release := func() { <-b.slots }
+
+	select {
+	case b.slots <- struct{}{}:
+		return release, nil
+	default:
+	}
+
+	if b.maxWait <= 0 {
+		return nil, ErrOverloaded
+	}
+
+	timer := time.NewTimer(b.maxWait)
+	defer timer.Stop()
+
+	select {
+	case b.slots <- struct{}{}:
+		return release, nil
+	case <-timer.C:
+		return nil, ErrOverloaded
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// withDeadline applies the bulkhead's call timeout to ctx
+func (b *Bulkhead) withDeadline(ctx context.Context) (context.Context, context.CancelFunc) {
+	if b.timeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, b.timeout)
+}
@@ -3,7 +3,9 @@ package message
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"strconv"
 	"time"
 
 	"github.com/go-redis/redis/v8"
@@ -20,15 +22,28 @@ const (
 	defaultMessageExpiration = 24 * time.Hour
 	defaultRoomExpiration    = 72 * time.Hour
 	defaultSessionExpiration = 24 * time.Hour
+
+	// defaultRoomWindowSize is the number of most recent messages kept per room
+	defaultRoomWindowSize = 200
 )
 
+// ErrCacheMiss is returned when the requested entry is not cached
+var ErrCacheMiss = errors.New("cache miss")
+
 // Cache defines the interface for caching message data
 type Cache interface {
 	// Message operations
 	CacheMessage(ctx context.Context, message *Message) error
 	GetCachedMessage(ctx context.Context, id string) (*Message, error)
-	CacheRoomMessages(ctx context.Context, roomID string, messages []*Message) error
-	GetCachedRoomMessages(ctx context.Context, roomID string) ([]*Message, error)
+
+	// Room message window operations. A window holds the most recent messages
+	// of a room, newest first. CacheRoomWindow loads it from the database, with
+	// complete set when the messages are the room's entire history.
+	// GetRoomWindow returns ErrCacheMiss if no window is loaded and covered is
+	// false when the requested page lies outside the window.
+	CacheRoomWindow(ctx context.Context, roomID string, messages []*Message, complete bool) error
+	AppendRoomMessage(ctx context.Context, message *Message) error
+	GetRoomWindow(ctx context.Context, roomID string, limit, offset int) (messages []*Message, covered bool, err error)
 	
 	// Room operations
 	CacheRoom(ctx context.Context, room *Room) error
@@ -48,7 +63,7 @@ type RedisCache struct {
 }
 
 // NewRedisCache creates a new Redis cache
-func NewRedisCache(addr string, password string, db int) Cache {
+func NewRedisCache(addr string, password string, db int) *RedisCache {
 	client := redis.NewClient(&redis.Options{
 		Addr:     addr,
 		Password: password,
@@ -60,6 +75,11 @@ func NewRedisCache(addr string, password string, db int) Cache {
 	}
 }
 
+// AddHook adds a hook to the Redis client, e.g. to observe command latency
+func (c *RedisCache) AddHook(hook redis.Hook) {
+	c.client.AddHook(hook)
+}
+
 // CacheMessage stores a message in Redis
 func (c *RedisCache) CacheMessage(ctx context.Context, message *Message) error {
 	data, err := json.Marshal(message)
@@ -87,31 +107,134 @@ func (c *RedisCache) GetCachedMessage(ctx context.Context, id string) (*Message,
 	return &message, nil
 }
 
-// CacheRoomMessages stores a list of messages for a room in Redis
-func (c *RedisCache) CacheRoomMessages(ctx context.Context, roomID string, messages []*Message) error {
-	data, err := json.Marshal(messages)
+// roomWindowKey returns the sorted set holding a room's message window
+func roomWindowKey(roomID string) string {
+	return fmt.Sprintf("%s%s:window", roomKeyPrefix, roomID)
+}
+
+// roomWindowMetaKey returns the hash describing a room's message window
+func roomWindowMetaKey(roomID string) string {
+	return fmt.Sprintf("%s%s:window:meta", roomKeyPrefix, roomID)
+}
+
+// windowMember encodes a message as a sorted set member. Timestamps are
+// normalised to the precision Postgres stores so a message appended on save and
+// the same message loaded from the database encode identically.
+func windowMember(message *Message) (string, float64, error) {
+	normalised := *message
+	normalised.Timestamp = message.Timestamp.UTC().Truncate(time.Microsecond)
+
+	data, err := json.Marshal(&normalised)
+	if err != nil {
+		return "", 0, err
+	}
+
+	return string(data), float64(normalised.Timestamp.UnixMicro()), nil
+}
+
+// CacheRoomWindow merges messages loaded from the database into the room's
+// window and marks it as loaded
+func (c *RedisCache) CacheRoomWindow(ctx context.Context, roomID string, messages []*Message, complete bool) error {
+	members := make([]*redis.Z, 0, len(messages))
+	for _, message := range messages {
+		member, score, err := windowMember(message)
+		if err != nil {
+			return err
+		}
+		members = append(members, &redis.Z{Score: score, Member: member})
+	}
+
+	key := roomWindowKey(roomID)
+	metaKey := roomWindowMetaKey(roomID)
+
+	var trimmed *redis.IntCmd
+	_, err := c.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		if len(members) > 0 {
+			pipe.ZAdd(ctx, key, members...)
+		}
+		trimmed = pipe.ZRemRangeByRank(ctx, key, 0, -defaultRoomWindowSize-1)
+		pipe.HSet(ctx, metaKey, "loaded", 1, "complete", complete)
+		pipe.Expire(ctx, key, defaultMessageExpiration)
+		pipe.Expire(ctx, metaKey, defaultMessageExpiration)
+		return nil
+	})
 	if err != nil {
 		return err
 	}
-	
-	key := fmt.Sprintf("%s%s:messages", roomKeyPrefix, roomID)
-	return c.client.Set(ctx, key, data, defaultMessageExpiration).Err()
+
+	if complete && trimmed.Val() > 0 {
+		return c.client.HSet(ctx, metaKey, "complete", false).Err()
+	}
+	return nil
 }
 
-// GetCachedRoomMessages retrieves messages for a room from Redis
-func (c *RedisCache) GetCachedRoomMessages(ctx context.Context, roomID string) ([]*Message, error) {
-	key := fmt.Sprintf("%s%s:messages", roomKeyPrefix, roomID)
-	data, err := c.client.Get(ctx, key).Bytes()
+// AppendRoomMessage adds a newly saved message to the room's window, dropping
+// the oldest messages beyond the window size
+func (c *RedisCache) AppendRoomMessage(ctx context.Context, message *Message) error {
+	member, score, err := windowMember(message)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	
-	var messages []*Message
-	if err := json.Unmarshal(data, &messages); err != nil {
-		return nil, err
+
+	key := roomWindowKey(message.RoomID)
+	metaKey := roomWindowMetaKey(message.RoomID)
+
+	var trimmed *redis.IntCmd
+	_, err = c.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZAdd(ctx, key, &redis.Z{Score: score, Member: member})
+		trimmed = pipe.ZRemRangeByRank(ctx, key, 0, -defaultRoomWindowSize-1)
+		pipe.Expire(ctx, key, defaultMessageExpiration)
+		return nil
+	})
+	if err != nil {
+		return err
 	}
-	
-	return messages, nil
+
+	if trimmed.Val() > 0 {
+		return c.client.HSet(ctx, metaKey, "complete", false).Err()
+	}
+	return nil
+}
+
+// GetRoomWindow serves a page of room messages, newest first, from the window
+func (c *RedisCache) GetRoomWindow(ctx context.Context, roomID string, limit, offset int) ([]*Message, bool, error) {
+	if limit <= 0 || offset < 0 {
+		return nil, false, nil
+	}
+
+	meta, err := c.client.HGetAll(ctx, roomWindowMetaKey(roomID)).Result()
+	if err != nil {
+		return nil, false, err
+	}
+	if loaded, _ := strconv.ParseBool(meta["loaded"]); !loaded {
+		return nil, false, ErrCacheMiss
+	}
+	complete, _ := strconv.ParseBool(meta["complete"])
+
+	key := roomWindowKey(roomID)
+	size, err := c.client.ZCard(ctx, key).Result()
+	if err != nil {
+		return nil, false, err
+	}
+	if !complete && int64(offset+limit) > size {
+		return nil, false, nil
+	}
+
+	members, err := c.client.ZRevRange(ctx, key, int64(offset), int64(offset+limit-1)).Result()
+	if err != nil {
+		return nil, false, err
+	}
+
+	messages := make([]*Message, 0, len(members))
+	for _, member := range members {
+		var message Message
+		if err := json.Unmarshal([]byte(member), &message); err != nil {
+			return nil, false, err
+		}
+		messages = append(messages, &message)
+	}
+
+	return messages, true, nil
 }
 
 // CacheRoom stores a room in Redis
@@ -0,0 +1,191 @@
+package message
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/sony/gobreaker"
+)
+
+// DefaultCacheTimeout bounds a single cache operation. Cache calls sit on the
+// request path in front of the database, so a slow cache should give up long
+// before the database would.
+const DefaultCacheTimeout = 100 * time.Millisecond
+
+// ResilientCache wraps a Cache with a circuit breaker and a deadline on every
+// operation. While the breaker is open every call fails immediately, which the
+// service treats as a cache miss and answers from the database instead.
+type ResilientCache struct {
+	cache   Cache
+	breaker *gobreaker.CircuitBreaker
+	timeout time.Duration
+}
+
+// NewResilientCache creates a cache wrapper whose operations give up after
+// timeout and whose breaker is configured by cbConfig
+func NewResilientCache(cache Cache, cbConfig CircuitBreakerConfig, timeout time.Duration) *ResilientCache {
+	if timeout <= 0 {
+		timeout = DefaultCacheTimeout
+	}
+
+	settings := gobreaker.Settings{
+		Name:          cbConfig.Name + "-cache",
+		MaxRequests:   cbConfig.MaxRequests,
+		Interval:      cbConfig.Interval,
+		Timeout:       cbConfig.Timeout,
+		ReadyToTrip:   cbConfig.ReadyToTrip,
+		OnStateChange: cbConfig.OnStateChange,
+		IsSuccessful:  isCacheSuccess,
+	}
+
+	return &ResilientCache{
+		cache:   cache,
+		breaker: gobreaker.NewCircuitBreaker(settings),
+		timeout: timeout,
+	}
+}
+
+// isCacheSuccess counts misses as successes so only a failing cache trips the breaker
+func isCacheSuccess(err error) bool {
+	return err == nil || errors.Is(err, ErrCacheMiss) || errors.Is(err, redis.Nil)
+}
+
+// BreakerState returns the current state of the cache circuit breaker
+func (c *ResilientCache) BreakerState() gobreaker.State {
+	return c.breaker.State()
+}
+
+// Degraded reports whether cache calls are currently bypassed or on probation
+func (c *ResilientCache) Degraded() bool {
+	return c.breaker.State() != gobreaker.StateClosed
+}
+
+// execute runs a cache operation through the breaker with the operation deadline
+func (c *ResilientCache) execute(ctx context.Context, operation func(ctx context.Context) (interface{}, error)) (interface{}, error) {
+	return c.breaker.Execute(func() (interface{}, error) {
+		ctx, cancel := context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+		return operation(ctx)
+	})
+}
+
+// CacheMessage implements Cache with resilience
+func (c *ResilientCache) CacheMessage(ctx context.Context, message *Message) error {
+	_, err := c.execute(ctx, func(ctx context.Context) (interface{}, error) {
+		return nil, c.cache.CacheMessage(ctx, message)
+	})
+	return err
+}
+
+// GetCachedMessage implements Cache with resilience
+func (c *ResilientCache) GetCachedMessage(ctx context.Context, id string) (*Message, error) {
+	result, err := c.execute(ctx, func(ctx context.Context) (interface{}, error) {
+		return c.cache.GetCachedMessage(ctx, id)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result.(*Message), nil
+}
+
+// CacheRoomWindow implements Cache with resilience
+func (c *ResilientCache) CacheRoomWindow(ctx context.Context, roomID string, messages []*Message, complete bool) error {
+	_, err := c.execute(ctx, func(ctx context.Context) (interface{}, error) {
+		return nil, c.cache.CacheRoomWindow(ctx, roomID, messages, complete)
+	})
+	return err
+}
+
+// AppendRoomMessage implements Cache with resilience
+func (c *ResilientCache) AppendRoomMessage(ctx context.Context, message *Message) error {
+	_, err := c.execute(ctx, func(ctx context.Context) (interface{}, error) {
+		return nil, c.cache.AppendRoomMessage(ctx, message)
+	})
+	return err
+}
+
+// roomWindowPage carries the results of GetRoomWindow through the breaker
+type roomWindowPage struct {
+	messages []*Message
+	covered  bool
+}
+
+// GetRoomWindow implements Cache with resilience
+func (c *ResilientCache) GetRoomWindow(ctx context.Context, roomID string, limit, offset int) ([]*Message, bool, error) {
+	result, err := c.execute(ctx, func(ctx context.Context) (interface{}, error) {
+		messages, covered, err := c.cache.GetRoomWindow(ctx, roomID, limit, offset)
+		return roomWindowPage{messages: messages, covered: covered}, err
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	page := result.(roomWindowPage)
+	return page.messages, page.covered, nil
+}
+
+// CacheRoom implements Cache with resilience
+func (c *ResilientCache) CacheRoom(ctx context.Context, room *Room) error {
+	_, err := c.execute(ctx, func(ctx context.Context) (interface{}, error) {
+		return nil, c.cache.CacheRoom(ctx, room)
+	})
+	return err
+}
+
+// GetCachedRoom implements Cache with resilience
+func (c *ResilientCache) GetCachedRoom(ctx context.Context, id string) (*Room, error) {
+	result, err := c.execute(ctx, func(ctx context.Context) (interface{}, error) {
+		return c.cache.GetCachedRoom(ctx, id)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result.(*Room), nil
+}
+
+// CacheRoomList implements Cache with resilience
+func (c *ResilientCache) CacheRoomList(ctx context.Context, rooms []*Room) error {
+	_, err := c.execute(ctx, func(ctx context.Context) (interface{}, error) {
+		return nil, c.cache.CacheRoomList(ctx, rooms)
+	})
+	return err
+}
+
+// GetCachedRoomList implements Cache with resilience
+func (c *ResilientCache) GetCachedRoomList(ctx context.Context) ([]*Room, error) {
+	result, err := c.execute(ctx, func(ctx context.Context) (interface{}, error) {
+		return c.cache.GetCachedRoomList(ctx)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result.([]*Room), nil
+}
+
+// SetSession implements Cache with resilience
+func (c *ResilientCache) SetSession(ctx context.Context, userID, sessionData string, expiration time.Duration) error {
+	_, err := c.execute(ctx, func(ctx context.Context) (interface{}, error) {
+		return nil, c.cache.SetSession(ctx, userID, sessionData, expiration)
+	})
+	return err
+}
+
+// GetSession implements Cache with resilience
+func (c *ResilientCache) GetSession(ctx context.Context, userID string) (string, error) {
+	result, err := c.execute(ctx, func(ctx context.Context) (interface{}, error) {
+		return c.cache.GetSession(ctx, userID)
+	})
+	if err != nil {
+		return "", err
+	}
+	return result.(string), nil
+}
+
+// DeleteSession implements Cache with resilience
+func (c *ResilientCache) DeleteSession(ctx context.Context, userID string) error {
+	_, err := c.execute(ctx, func(ctx context.Context) (interface{}, error) {
+		return nil, c.cache.DeleteSession(ctx, userID)
+	})
+	return err
+}
@@ -0,0 +1,141 @@
+package message
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"time"
+
+	"server/internal/apperr"
+)
+
+var ErrInvalidCursor = apperr.Validation("invalid_cursor", "invalid pagination cursor")
+
+// Cursor marks a position in a room's history. Messages are ordered by
+// timestamp and then by ID, so a cursor keeps its place while new messages
+// arrive.
+type Cursor struct {
+	Timestamp time.Time `json:"t"`
+	ID        string    `json:"id"`
+}
+
+// CursorOf returns the position of a message
+func CursorOf(message *Message) *Cursor {
+	return &Cursor{Timestamp: message.Timestamp.UTC(), ID: message.ID}
+}
+
+// Encode returns the opaque form of the cursor handed to clients
+func (c *Cursor) Encode() string {
+	return encodeCursor(c)
+}
+
+// DecodeCursor parses a cursor produced by Encode
+func DecodeCursor(s string) (*Cursor, error) {
+	var cursor Cursor
+	if err := decodeCursor(s, &cursor); err != nil {
+		return nil, err
+	}
+	if cursor.ID == "" || cursor.Timestamp.IsZero() {
+		return nil, ErrInvalidCursor
+	}
+	return &cursor, nil
+}
+
+// encodeCursor turns a cursor into an opaque URL safe string
+func encodeCursor(cursor interface{}) string {
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeCursor parses a string produced by encodeCursor into cursor
+func decodeCursor(s string, cursor interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return ErrInvalidCursor.Wrap(err)
+	}
+	if err := json.Unmarshal(data, cursor); err != nil {
+		return ErrInvalidCursor.Wrap(err)
+	}
+	return nil
+}
+
+// before reports whether a message sorts before (is older than) the cursor
+func (c *Cursor) before(message *Message) bool {
+	if message.Timestamp.Equal(c.Timestamp) {
+		return message.ID < c.ID
+	}
+	return message.Timestamp.Before(c.Timestamp)
+}
+
+// after reports whether a message sorts after (is newer than) the cursor
+func (c *Cursor) after(message *Message) bool {
+	if message.Timestamp.Equal(c.Timestamp) {
+		return message.ID > c.ID
+	}
+	return message.Timestamp.After(c.Timestamp)
+}
+
+// HistoryQuery selects a page of room history. Before pages towards older
+// messages and After towards newer ones; at most one of them is set.
+type HistoryQuery struct {
+	Limit  int
+	Before *Cursor
+	After  *Cursor
+}
+
+// HistoryPage is a page of room history, newest first. NextCursor is passed
+// as before= to continue with older messages and PrevCursor as after= to go
+// back to newer ones; each is empty when there is nothing more that way.
+type HistoryPage struct {
+	Messages   []*Message `json:"messages"`
+	NextCursor string     `json:"nextCursor,omitempty"`
+	PrevCursor string     `json:"prevCursor,omitempty"`
+}
+
+// newHistoryPage builds a page from up to query.Limit+1 messages, newest
+// first, using the extra message only to tell whether more remain
+func newHistoryPage(messages []*Message, query HistoryQuery) *HistoryPage {
+	more := len(messages) > query.Limit
+	if more {
+		if query.After != nil {
+			// Paging forward the extra message is the newest one
+			messages = messages[1:]
+		} else {
+			messages = messages[:query.Limit]
+		}
+	}
+	if messages == nil {
+		messages = []*Message{}
+	}
+
+	page := &HistoryPage{Messages: messages}
+	if len(messages) == 0 {
+		return page
+	}
+
+	first, last := CursorOf(messages[0]).Encode(), CursorOf(messages[len(messages)-1]).Encode()
+	switch {
+	case query.After != nil:
+		page.NextCursor = last
+		if more {
+			page.PrevCursor = first
+		}
+	case query.Before != nil:
+		page.PrevCursor = first
+		if more {
+			page.NextCursor = last
+		}
+	default:
+		if more {
+			page.NextCursor = last
+		}
+	}
+	return page
+}
+
+// reverseMessages reverses a slice in place, turning an ascending query
+// result into newest first order
+func reverseMessages(messages []*Message) {
+	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+		messages[i], messages[j] = messages[j], messages[i]
+	}
+}
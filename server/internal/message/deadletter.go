@@ -0,0 +1,323 @@
+package message
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"server/internal/apperr"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// ErrDeadLetterNotFound is returned when a dead letter does not exist
+var ErrDeadLetterNotFound = apperr.NotFound("dead_letter_not_found", "dead letter not found")
+
+// DeadLetter is a message that failed persistence permanently
+type DeadLetter struct {
+	Message       Message   `json:"message"`
+	Error         string    `json:"error"`
+	Attempts      int       `json:"attempts"`
+	FirstFailedAt time.Time `json:"firstFailedAt"`
+	LastFailedAt  time.Time `json:"lastFailedAt"`
+}
+
+// DeadLetterSink receives messages that can never be persisted as they are
+type DeadLetterSink interface {
+	Record(ctx context.Context, message *Message, cause error) error
+}
+
+// IsPermanentError reports whether a persistence error will fail again no
+// matter how often it is retried, such as a constraint violation or bad data
+func IsPermanentError(err error) bool {
+	if class, ok := sqlStateClass(err); ok {
+		switch class {
+		case "22", "23": // data exception, integrity constraint violation
+			return true
+		}
+	}
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		switch sqliteErr.Code {
+		case sqlite3.ErrConstraint, sqlite3.ErrMismatch, sqlite3.ErrTooBig:
+			return true
+		}
+	}
+	return false
+}
+
+// isolatePermanentFailures is called after a batch failed with err. When the
+// failure is permanent, the batch is retried message by message so only the
+// offending messages are dead-lettered. It returns the messages that still need
+// to be persisted together with the last error seen for them.
+func isolatePermanentFailures(ctx context.Context, service Service, sink DeadLetterSink, batch []*Message, err error) ([]*Message, error) {
+	if sink == nil || !IsPermanentError(err) {
+		return batch, err
+	}
+
+	var remaining []*Message
+	var lastErr error
+	for _, message := range batch {
+		saveErr := service.SaveMessage(ctx, message)
+		switch {
+		case saveErr == nil:
+		case IsPermanentError(saveErr):
+			if recordErr := sink.Record(ctx, message, saveErr); recordErr != nil {
+				log.Printf("Error dead-lettering message %s: %v", message.ID, recordErr)
+				remaining = append(remaining, message)
+				lastErr = saveErr
+				continue
+			}
+			log.Printf("Dead-lettered message %s: %v", message.ID, saveErr)
+		default:
+			remaining = append(remaining, message)
+			lastErr = saveErr
+		}
+	}
+
+	return remaining, lastErr
+}
+
+// DeadLetterRepository defines the interface for dead letter data access
+type DeadLetterRepository interface {
+	RecordDeadLetter(ctx context.Context, message *Message, cause string) error
+	ListDeadLetters(ctx context.Context, limit, offset int) ([]*DeadLetter, error)
+	GetDeadLetter(ctx context.Context, messageID string) (*DeadLetter, error)
+	UpdateDeadLetter(ctx context.Context, message *Message) error
+	DeleteDeadLetter(ctx context.Context, messageID string) error
+}
+
+// PostgresDeadLetterRepository implements DeadLetterRepository using PostgreSQL
+type PostgresDeadLetterRepository struct {
+	db *sql.DB
+}
+
+// NewPostgresDeadLetterRepository creates a new PostgreSQL dead letter repository
+func NewPostgresDeadLetterRepository(db *sql.DB) *PostgresDeadLetterRepository {
+	return &PostgresDeadLetterRepository{
+		db: db,
+	}
+}
+
+// RecordDeadLetter stores a failed message, bumping the attempt count if it was
+// already dead-lettered
+func (r *PostgresDeadLetterRepository) RecordDeadLetter(ctx context.Context, message *Message, cause string) error {
+	query := `
+		INSERT INTO dead_letters (message_id, room_id, user_id, username, content, type, timestamp, recipient, recipient_id, error, attempts, first_failed_at, last_failed_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, 1, $11, $11)
+		ON CONFLICT (message_id) DO UPDATE
+		SET error = EXCLUDED.error, attempts = dead_letters.attempts + 1, last_failed_at = EXCLUDED.last_failed_at
+	`
+
+	_, err := r.db.ExecContext(
+		ctx,
+		query,
+		message.ID,
+		message.RoomID,
+		message.UserID,
+		message.Username,
+		message.Content,
+		message.Type,
+		message.Timestamp,
+		message.Recipient,
+		message.RecipientID,
+		cause,
+		time.Now(),
+	)
+
+	return err
+}
+
+// ListDeadLetters retrieves dead letters, most recently failed first
+func (r *PostgresDeadLetterRepository) ListDeadLetters(ctx context.Context, limit, offset int) ([]*DeadLetter, error) {
+	query := `
+		SELECT message_id, room_id, user_id, username, content, type, timestamp, recipient, recipient_id, error, attempts, first_failed_at, last_failed_at
+		FROM dead_letters
+		ORDER BY last_failed_at DESC
+		LIMIT $1 OFFSET $2
+	`
+
+	rows, err := r.db.QueryContext(ctx, query, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deadLetters []*DeadLetter
+	for rows.Next() {
+		deadLetter, err := scanDeadLetter(rows)
+		if err != nil {
+			return nil, err
+		}
+		deadLetters = append(deadLetters, deadLetter)
+	}
+
+	return deadLetters, rows.Err()
+}
+
+// GetDeadLetter retrieves a dead letter by its message ID
+func (r *PostgresDeadLetterRepository) GetDeadLetter(ctx context.Context, messageID string) (*DeadLetter, error) {
+	query := `
+		SELECT message_id, room_id, user_id, username, content, type, timestamp, recipient, recipient_id, error, attempts, first_failed_at, last_failed_at
+		FROM dead_letters
+		WHERE message_id = $1
+	`
+
+	deadLetter, err := scanDeadLetter(r.db.QueryRowContext(ctx, query, messageID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrDeadLetterNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return deadLetter, nil
+}
+
+// UpdateDeadLetter replaces the stored message of a dead letter
+func (r *PostgresDeadLetterRepository) UpdateDeadLetter(ctx context.Context, message *Message) error {
+	query := `
+		UPDATE dead_letters
+		SET room_id = $2, user_id = $3, username = $4, content = $5, type = $6, timestamp = $7, recipient = $8, recipient_id = $9
+		WHERE message_id = $1
+	`
+
+	result, err := r.db.ExecContext(
+		ctx,
+		query,
+		message.ID,
+		message.RoomID,
+		message.UserID,
+		message.Username,
+		message.Content,
+		message.Type,
+		message.Timestamp,
+		message.Recipient,
+		message.RecipientID,
+	)
+	if err != nil {
+		return err
+	}
+
+	return requireAffected(result)
+}
+
+// DeleteDeadLetter removes a dead letter
+func (r *PostgresDeadLetterRepository) DeleteDeadLetter(ctx context.Context, messageID string) error {
+	result, err := r.db.ExecContext(ctx, `DELETE FROM dead_letters WHERE message_id = $1`, messageID)
+	if err != nil {
+		return err
+	}
+
+	return requireAffected(result)
+}
+
+// requireAffected turns an update that matched no rows into ErrDeadLetterNotFound
+func requireAffected(result sql.Result) error {
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrDeadLetterNotFound
+	}
+	return nil
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanDeadLetter(row rowScanner) (*DeadLetter, error) {
+	deadLetter := &DeadLetter{}
+	err := row.Scan(
+		&deadLetter.Message.ID,
+		&deadLetter.Message.RoomID,
+		&deadLetter.Message.UserID,
+		&deadLetter.Message.Username,
+		&deadLetter.Message.Content,
+		&deadLetter.Message.Type,
+		&deadLetter.Message.Timestamp,
+		&deadLetter.Message.Recipient,
+		&deadLetter.Message.RecipientID,
+		&deadLetter.Error,
+		&deadLetter.Attempts,
+		&deadLetter.FirstFailedAt,
+		&deadLetter.LastFailedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return deadLetter, nil
+}
+
+// DeadLetterService records, edits and replays dead letters
+type DeadLetterService struct {
+	repo    DeadLetterRepository
+	service Service
+}
+
+// NewDeadLetterService creates a dead letter service that replays through the
+// given message service
+func NewDeadLetterService(repo DeadLetterRepository, service Service) *DeadLetterService {
+	return &DeadLetterService{
+		repo:    repo,
+		service: service,
+	}
+}
+
+// Record implements DeadLetterSink
+func (s *DeadLetterService) Record(ctx context.Context, message *Message, cause error) error {
+	return s.repo.RecordDeadLetter(ctx, message, cause.Error())
+}
+
+// List returns a page of dead letters
+func (s *DeadLetterService) List(ctx context.Context, limit, offset int) ([]*DeadLetter, error) {
+	return s.repo.ListDeadLetters(ctx, limit, offset)
+}
+
+// Get returns a single dead letter
+func (s *DeadLetterService) Get(ctx context.Context, messageID string) (*DeadLetter, error) {
+	return s.repo.GetDeadLetter(ctx, messageID)
+}
+
+// Update edits the message held by a dead letter so it can be replayed. The
+// message ID is immutable.
+func (s *DeadLetterService) Update(ctx context.Context, messageID string, message *Message) (*DeadLetter, error) {
+	message.ID = messageID
+	if err := s.repo.UpdateDeadLetter(ctx, message); err != nil {
+		return nil, err
+	}
+	return s.repo.GetDeadLetter(ctx, messageID)
+}
+
+// Replay persists a dead letter again and removes it on success. On failure the
+// attempt count and error are updated and the error is returned.
+func (s *DeadLetterService) Replay(ctx context.Context, messageID string) (*Message, error) {
+	deadLetter, err := s.repo.GetDeadLetter(ctx, messageID)
+	if err != nil {
+		return nil, err
+	}
+
+	message := deadLetter.Message
+	if err := s.service.SaveMessage(ctx, &message); err != nil {
+		if recordErr := s.repo.RecordDeadLetter(ctx, &deadLetter.Message, err.Error()); recordErr != nil {
+			return nil, fmt.Errorf("replay failed: %v (recording failure: %w)", err, recordErr)
+		}
+		return nil, fmt.Errorf("replay failed: %w", err)
+	}
+
+	if err := s.repo.DeleteDeadLetter(ctx, messageID); err != nil {
+		return nil, err
+	}
+
+	return &message, nil
+}
+
+// Discard drops a dead letter without persisting it
+func (s *DeadLetterService) Discard(ctx context.Context, messageID string) error {
+	return s.repo.DeleteDeadLetter(ctx, messageID)
+}
@@ -0,0 +1,56 @@
+package message
+
+import (
+	"errors"
+
+	"server/internal/apperr"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
+)
+
+var (
+	ErrMessageNotFound = apperr.NotFound("message_not_found", "message not found")
+	ErrRoomNotFound    = apperr.NotFound("room_not_found", "room not found")
+	ErrRoomExists      = apperr.Conflict("room_exists", "room already exists")
+
+	// ErrUnavailable wraps breaker rejections while the database is failing
+	ErrUnavailable = apperr.Unavailable("unavailable", "service is temporarily unavailable")
+)
+
+// isUniqueViolation reports whether err is a Postgres or SQLite unique
+// constraint violation
+func isUniqueViolation(err error) bool {
+	if code, ok := sqlState(err); ok {
+		return code == "23505"
+	}
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique || sqliteErr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey
+	}
+	return false
+}
+
+// sqlState returns the SQLSTATE code of a Postgres error from lib/pq or pgx
+func sqlState(err error) (string, bool) {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return string(pqErr.Code), true
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgErr.Code, true
+	}
+	return "", false
+}
+
+// sqlStateClass returns the class of the SQLSTATE code of a Postgres error,
+// its first two characters. Codes too short to have one are ignored.
+func sqlStateClass(err error) (string, bool) {
+	code, ok := sqlState(err)
+	if !ok || len(code) < 2 {
+		return "", false
+	}
+	return code[:2], true
+}
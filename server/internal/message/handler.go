@@ -1,16 +1,23 @@
 package message
 
 import (
+	"context"
 	"net/http"
 	"strconv"
+	"time"
+
+	"server/internal/apperr"
+	"server/internal/auth"
 
 	"github.com/gin-gonic/gin"
 )
 
 type CreateRoomRequest struct {
-	ID      string `json:"id" binding:"required"`
-	Name    string `json:"name" binding:"required"`
-	OwnerID string `json:"ownerId" binding:"required"`
+	ID              string `json:"id" binding:"required"`
+	Name            string `json:"name" binding:"required"`
+	OwnerID         string `json:"ownerId" binding:"required"`
+	MaxMembers      int    `json:"maxMembers" binding:"min=0"`
+	AllowSpectators bool   `json:"allowSpectators"`
 }
 
 type Handler struct {
@@ -23,54 +30,158 @@ func NewHandler(service Service) *Handler {
 	}
 }
 
-// GetMessages retrieves messages for a specific room
+// Room history page sizes
+const (
+	defaultHistoryLimit = 50
+	maxHistoryLimit     = 200
+)
+
+// GetMessages retrieves a page of a room's history, newest first. Pages are
+// selected with the opaque before/after cursors returned by earlier pages;
+// a plain offset is still honoured for older clients.
 func (h *Handler) GetMessages(c *gin.Context) {
 	roomID := c.Param("roomId")
 	if roomID == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "room ID is required"})
+		c.Error(apperr.Validation("invalid_request", "room ID is required"))
+		return
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultHistoryLimit)))
+	if err != nil || limit <= 0 {
+		limit = defaultHistoryLimit
+	}
+	if limit > maxHistoryLimit {
+		limit = maxHistoryLimit
+	}
+
+	before, after := c.Query("before"), c.Query("after")
+	if offsetStr := c.Query("offset"); offsetStr != "" && before == "" && after == "" {
+		h.getMessagesByOffset(c, roomID, limit, offsetStr)
+		return
+	}
+	if before != "" && after != "" {
+		c.Error(apperr.Validation("invalid_request", "only one of before and after may be set"))
 		return
 	}
 
-	// Parse pagination parameters
-	limitStr := c.DefaultQuery("limit", "50")
-	offsetStr := c.DefaultQuery("offset", "0")
+	query := HistoryQuery{Limit: limit}
+	if before != "" {
+		if query.Before, err = DecodeCursor(before); err != nil {
+			c.Error(err)
+			return
+		}
+	}
+	if after != "" {
+		if query.After, err = DecodeCursor(after); err != nil {
+			c.Error(err)
+			return
+		}
+	}
 
-	limit, err := strconv.Atoi(limitStr)
+	ctx := WithStaleTracking(c.Request.Context())
+	page, err := h.service.GetRoomHistory(ctx, roomID, query)
 	if err != nil {
-		limit = 50
+		c.Error(apperr.Internal(err, "messages_unavailable", "failed to retrieve messages"))
+		return
+	}
+
+	body := gin.H{"messages": page.Messages}
+	if page.NextCursor != "" {
+		body["nextCursor"] = page.NextCursor
+	}
+	if page.PrevCursor != "" {
+		body["prevCursor"] = page.PrevCursor
 	}
+	respondRead(c, ctx, body)
+}
 
+// getMessagesByOffset serves the deprecated offset pagination
+func (h *Handler) getMessagesByOffset(c *gin.Context, roomID string, limit int, offsetStr string) {
 	offset, err := strconv.Atoi(offsetStr)
-	if err != nil {
+	if err != nil || offset < 0 {
 		offset = 0
 	}
 
-	// Get messages
-	messages, err := h.service.GetMessagesByRoom(c.Request.Context(), roomID, limit, offset)
+	ctx := WithStaleTracking(c.Request.Context())
+	messages, err := h.service.GetMessagesByRoom(ctx, roomID, limit, offset)
+	if err != nil {
+		c.Error(apperr.Internal(err, "messages_unavailable", "failed to retrieve messages"))
+		return
+	}
+
+	respondRead(c, ctx, gin.H{"messages": messages})
+}
+
+// SearchMessages runs a full-text search over the messages the signed-in user
+// can read, within one room when the route names it and across the rooms they
+// own or have joined otherwise. Pages are continued with the opaque cursor of the previous page.
+func (h *Handler) SearchMessages(c *gin.Context) {
+	query := SearchQuery{
+		Text:   c.Query("q"),
+		RoomID: c.Param("roomId"),
+		Sender: c.Query("sender"),
+		Type:   c.Query("type"),
+	}
+	if query.RoomID == "" {
+		query.RoomID = c.Query("roomId")
+	}
+	if user := auth.CurrentUser(c); user != nil {
+		query.ViewerID = user.ID
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultHistoryLimit)))
+	if err != nil || limit <= 0 {
+		limit = defaultHistoryLimit
+	}
+	if limit > maxHistoryLimit {
+		limit = maxHistoryLimit
+	}
+	query.Limit = limit
+
+	for param, t := range map[string]*time.Time{"since": &query.Since, "until": &query.Until} {
+		value := c.Query(param)
+		if value == "" {
+			continue
+		}
+		if *t, err = time.Parse(time.RFC3339, value); err != nil {
+			c.Error(apperr.Validation("invalid_request", param+" must be an RFC 3339 time"))
+			return
+		}
+	}
+
+	if cursor := c.Query("cursor"); cursor != "" {
+		if query.After, err = DecodeSearchCursor(cursor); err != nil {
+			c.Error(err)
+			return
+		}
+	}
+
+	page, err := h.service.SearchMessages(c.Request.Context(), query)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve messages"})
+		c.Error(apperr.Internal(err, "search_failed", "failed to search messages"))
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"messages": messages})
+	c.JSON(http.StatusOK, page)
 }
 
 // GetMessage retrieves a message by its ID
 func (h *Handler) GetMessage(c *gin.Context) {
 	messageID := c.Param("messageId")
 	if messageID == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "message ID is required"})
+		c.Error(apperr.Validation("invalid_request", "message ID is required"))
 		return
 	}
 
 	// Get message
-	message, err := h.service.GetMessageByID(c.Request.Context(), messageID)
+	ctx := WithStaleTracking(c.Request.Context())
+	message, err := h.service.GetMessageByID(ctx, messageID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve message"})
+		c.Error(apperr.Internal(err, "message_unavailable", "failed to retrieve message"))
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": message})
+	respondRead(c, ctx, gin.H{"message": message})
 }
 
 // CreateRoom creates a new chat room
@@ -78,14 +189,18 @@ func (h *Handler) CreateRoom(c *gin.Context) {
 	var request CreateRoomRequest
 
 	if err := c.ShouldBindJSON(&request); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		c.Error(apperr.Validation("invalid_request", err.Error()))
 		return
 	}
 
 	// Create room
-	room, err := h.service.CreateRoom(c.Request.Context(), request.ID, request.Name, request.OwnerID)
+	capacity := RoomCapacity{
+		MaxMembers:      request.MaxMembers,
+		AllowSpectators: request.AllowSpectators,
+	}
+	room, err := h.service.CreateRoom(c.Request.Context(), request.ID, request.Name, request.OwnerID, capacity)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create room"})
+		c.Error(apperr.Internal(err, "room_create_failed", "failed to create room"))
 		return
 	}
 
@@ -95,29 +210,42 @@ func (h *Handler) CreateRoom(c *gin.Context) {
 // GetRooms retrieves all available chat rooms
 func (h *Handler) GetRooms(c *gin.Context) {
 	// Get rooms
-	rooms, err := h.service.GetRooms(c.Request.Context())
+	ctx := WithStaleTracking(c.Request.Context())
+	rooms, err := h.service.GetRooms(ctx)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve rooms"})
+		c.Error(apperr.Internal(err, "rooms_unavailable", "failed to retrieve rooms"))
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"rooms": rooms})
+	respondRead(c, ctx, gin.H{"rooms": rooms})
 }
 
 // GetRoom retrieves a room by its ID
 func (h *Handler) GetRoom(c *gin.Context) {
 	roomID := c.Param("roomId")
 	if roomID == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "room ID is required"})
+		c.Error(apperr.Validation("invalid_request", "room ID is required"))
 		return
 	}
 
 	// Get room
-	room, err := h.service.GetRoomByID(c.Request.Context(), roomID)
+	ctx := WithStaleTracking(c.Request.Context())
+	room, err := h.service.GetRoomByID(ctx, roomID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve room"})
+		c.Error(apperr.Internal(err, "room_unavailable", "failed to retrieve room"))
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"room": room})
+	respondRead(c, ctx, gin.H{"room": room})
+}
+
+// respondRead writes a successful read, flagging responses served from stale
+// cached data with a header and a stale field
+func respondRead(c *gin.Context, ctx context.Context, body gin.H) {
+	if IsStale(ctx) {
+		c.Header(StaleHeader, "true")
+		body["stale"] = true
+	}
+
+	c.JSON(http.StatusOK, body)
 }
@@ -0,0 +1,372 @@
+package message
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultMemoryCacheSize is the default number of entries kept by MemoryCache
+const DefaultMemoryCacheSize = 10000
+
+// MemoryCache implements the Cache interface in process with LRU eviction and
+// per-entry expiry. It is meant for single-node deployments and tests.
+type MemoryCache struct {
+	mu       sync.Mutex
+	capacity int
+	maxTTL   time.Duration // caps entry lifetimes when set
+	order    *list.List    // most recently used at the front
+	entries  map[string]*list.Element
+}
+
+type memoryEntry struct {
+	key     string
+	value   interface{}
+	expires time.Time
+}
+
+// memoryWindow is the in-process counterpart of a Redis room window
+type memoryWindow struct {
+	loaded   bool
+	complete bool
+	messages []Message // newest first
+}
+
+// NewMemoryCache creates an in-process cache holding at most capacity entries
+func NewMemoryCache(capacity int) *MemoryCache {
+	if capacity <= 0 {
+		capacity = DefaultMemoryCacheSize
+	}
+
+	return &MemoryCache{
+		capacity: capacity,
+		order:    list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+}
+
+// NewMemoryCacheWithTTL creates an in-process cache whose entries live at most
+// ttl, regardless of the expiration requested for them
+func NewMemoryCacheWithTTL(capacity int, ttl time.Duration) *MemoryCache {
+	cache := NewMemoryCache(capacity)
+	cache.maxTTL = ttl
+	return cache
+}
+
+// Invalidate removes the entries stored under the given cache keys
+func (c *MemoryCache) Invalidate(keys ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, key := range keys {
+		c.delete(key)
+	}
+}
+
+// Len returns the number of live and not yet evicted entries
+func (c *MemoryCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+// get returns an entry and marks it as recently used. Callers hold c.mu.
+func (c *MemoryCache) get(key string) (interface{}, bool) {
+	element, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+
+	entry := element.Value.(*memoryEntry)
+	if time.Now().After(entry.expires) {
+		c.remove(element)
+		return nil, false
+	}
+
+	c.order.MoveToFront(element)
+	return entry.value, true
+}
+
+// set stores an entry, evicting the least recently used ones beyond capacity.
+// Callers hold c.mu.
+func (c *MemoryCache) set(key string, value interface{}, expiration time.Duration) {
+	if c.maxTTL > 0 && expiration > c.maxTTL {
+		expiration = c.maxTTL
+	}
+	expires := time.Now().Add(expiration)
+
+	if element, ok := c.entries[key]; ok {
+		entry := element.Value.(*memoryEntry)
+		entry.value = value
+		entry.expires = expires
+		c.order.MoveToFront(element)
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(&memoryEntry{key: key, value: value, expires: expires})
+	for c.order.Len() > c.capacity {
+		c.remove(c.order.Back())
+	}
+}
+
+// delete removes an entry if present. Callers hold c.mu.
+func (c *MemoryCache) delete(key string) {
+	if element, ok := c.entries[key]; ok {
+		c.remove(element)
+	}
+}
+
+func (c *MemoryCache) remove(element *list.Element) {
+	c.order.Remove(element)
+	delete(c.entries, element.Value.(*memoryEntry).key)
+}
+
+// CacheMessage stores a copy of a message
+func (c *MemoryCache) CacheMessage(ctx context.Context, message *Message) error {
+	copied := *message
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.set(fmt.Sprintf("%s%s", messageKeyPrefix, message.ID), &copied, defaultMessageExpiration)
+	return nil
+}
+
+// GetCachedMessage retrieves a copy of a cached message
+func (c *MemoryCache) GetCachedMessage(ctx context.Context, id string) (*Message, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	value, ok := c.get(fmt.Sprintf("%s%s", messageKeyPrefix, id))
+	if !ok {
+		return nil, ErrCacheMiss
+	}
+
+	copied := *value.(*Message)
+	return &copied, nil
+}
+
+// window returns the room's window, creating an unloaded one if needed.
+// Callers hold c.mu.
+func (c *MemoryCache) window(roomID string) *memoryWindow {
+	key := roomWindowKey(roomID)
+	if value, ok := c.get(key); ok {
+		return value.(*memoryWindow)
+	}
+
+	window := &memoryWindow{}
+	c.set(key, window, defaultMessageExpiration)
+	return window
+}
+
+// merge inserts messages into the window keeping it ordered newest first,
+// ignoring duplicates and trimming it to the window size
+func (w *memoryWindow) merge(messages []*Message) {
+	seen := make(map[string]struct{}, len(w.messages))
+	for _, message := range w.messages {
+		seen[message.ID] = struct{}{}
+	}
+
+	for _, message := range messages {
+		if _, ok := seen[message.ID]; ok {
+			continue
+		}
+		seen[message.ID] = struct{}{}
+		w.messages = append(w.messages, *message)
+	}
+
+	sort.SliceStable(w.messages, func(i, j int) bool {
+		return w.messages[i].Timestamp.After(w.messages[j].Timestamp)
+	})
+
+	if len(w.messages) > defaultRoomWindowSize {
+		w.messages = w.messages[:defaultRoomWindowSize]
+		w.complete = false
+	}
+}
+
+// CacheRoomWindow merges messages loaded from the database into the window
+func (c *MemoryCache) CacheRoomWindow(ctx context.Context, roomID string, messages []*Message, complete bool) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	window := c.window(roomID)
+	window.loaded = true
+	window.complete = complete
+	window.merge(messages)
+	c.set(roomWindowKey(roomID), window, defaultMessageExpiration)
+	return nil
+}
+
+// AppendRoomMessage adds a newly saved message to the room's window
+func (c *MemoryCache) AppendRoomMessage(ctx context.Context, message *Message) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.window(message.RoomID).merge([]*Message{message})
+	return nil
+}
+
+// GetRoomWindow serves a page of room messages, newest first, from the window
+func (c *MemoryCache) GetRoomWindow(ctx context.Context, roomID string, limit, offset int) ([]*Message, bool, error) {
+	if limit <= 0 || offset < 0 {
+		return nil, false, nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	value, ok := c.get(roomWindowKey(roomID))
+	if !ok || !value.(*memoryWindow).loaded {
+		return nil, false, ErrCacheMiss
+	}
+
+	window := value.(*memoryWindow)
+	if !window.complete && offset+limit > len(window.messages) {
+		return nil, false, nil
+	}
+
+	messages := make([]*Message, 0, limit)
+	for i := offset; i < offset+limit && i < len(window.messages); i++ {
+		copied := window.messages[i]
+		messages = append(messages, &copied)
+	}
+
+	return messages, true, nil
+}
+
+// CacheRoom stores a copy of a room
+func (c *MemoryCache) CacheRoom(ctx context.Context, room *Room) error {
+	copied := *room
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.set(fmt.Sprintf("%s%s", roomKeyPrefix, room.ID), &copied, defaultRoomExpiration)
+	return nil
+}
+
+// GetCachedRoom retrieves a copy of a cached room
+func (c *MemoryCache) GetCachedRoom(ctx context.Context, id string) (*Room, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	value, ok := c.get(fmt.Sprintf("%s%s", roomKeyPrefix, id))
+	if !ok {
+		return nil, ErrCacheMiss
+	}
+
+	copied := *value.(*Room)
+	return &copied, nil
+}
+
+// CacheRoomList stores a copy of the room list
+func (c *MemoryCache) CacheRoomList(ctx context.Context, rooms []*Room) error {
+	copied := make([]Room, len(rooms))
+	for i, room := range rooms {
+		copied[i] = *room
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.set(roomListKey, copied, defaultRoomExpiration)
+	return nil
+}
+
+// GetCachedRoomList retrieves a copy of the cached room list
+func (c *MemoryCache) GetCachedRoomList(ctx context.Context) ([]*Room, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	value, ok := c.get(roomListKey)
+	if !ok {
+		return nil, ErrCacheMiss
+	}
+
+	cached := value.([]Room)
+	rooms := make([]*Room, len(cached))
+	for i := range cached {
+		room := cached[i]
+		rooms[i] = &room
+	}
+	return rooms, nil
+}
+
+// SetSession stores a user session
+func (c *MemoryCache) SetSession(ctx context.Context, userID, sessionData string, expiration time.Duration) error {
+	if expiration == 0 {
+		expiration = defaultSessionExpiration
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.set(fmt.Sprintf("%s%s", sessionKeyPrefix, userID), sessionData, expiration)
+	return nil
+}
+
+// GetSession retrieves a user session
+func (c *MemoryCache) GetSession(ctx context.Context, userID string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	value, ok := c.get(fmt.Sprintf("%s%s", sessionKeyPrefix, userID))
+	if !ok {
+		return "", ErrCacheMiss
+	}
+	return value.(string), nil
+}
+
+// DeleteSession removes a user session
+func (c *MemoryCache) DeleteSession(ctx context.Context, userID string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.delete(fmt.Sprintf("%s%s", sessionKeyPrefix, userID))
+	return nil
+}
+
+// NoopCache implements the Cache interface without storing anything. Every
+// read is a miss, so the service always falls through to the repository.
+type NoopCache struct{}
+
+// NewNoopCache creates a cache that never caches
+func NewNoopCache() Cache {
+	return NoopCache{}
+}
+
+func (NoopCache) CacheMessage(ctx context.Context, message *Message) error { return nil }
+
+func (NoopCache) GetCachedMessage(ctx context.Context, id string) (*Message, error) {
+	return nil, ErrCacheMiss
+}
+
+func (NoopCache) CacheRoomWindow(ctx context.Context, roomID string, messages []*Message, complete bool) error {
+	return nil
+}
+
+func (NoopCache) AppendRoomMessage(ctx context.Context, message *Message) error { return nil }
+
+func (NoopCache) GetRoomWindow(ctx context.Context, roomID string, limit, offset int) ([]*Message, bool, error) {
+	return nil, false, ErrCacheMiss
+}
+
+func (NoopCache) CacheRoom(ctx context.Context, room *Room) error { return nil }
+
+func (NoopCache) GetCachedRoom(ctx context.Context, id string) (*Room, error) {
+	return nil, ErrCacheMiss
+}
+
+func (NoopCache) CacheRoomList(ctx context.Context, rooms []*Room) error { return nil }
+
+func (NoopCache) GetCachedRoomList(ctx context.Context) ([]*Room, error) {
+	return nil, ErrCacheMiss
+}
+
+func (NoopCache) SetSession(ctx context.Context, userID, sessionData string, expiration time.Duration) error {
+	return nil
+}
+
+func (NoopCache) GetSession(ctx context.Context, userID string) (string, error) {
+	return "", ErrCacheMiss
+}
+
+func (NoopCache) DeleteSession(ctx context.Context, userID string) error { return nil }
@@ -0,0 +1,269 @@
+package message
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// MemoryRepository implements Repository in process memory. It behaves like
+// PostgresRepository, which makes it a stand-in for tests and local runs
+// without a database. Stored values are copied so callers cannot alias them.
+type MemoryRepository struct {
+	mu       sync.RWMutex
+	messages []*Message
+	byID     map[string]*Message
+	rooms    map[string]*Room
+}
+
+// NewMemoryRepository creates an empty in-memory repository
+func NewMemoryRepository() *MemoryRepository {
+	return &MemoryRepository{
+		byID:  make(map[string]*Message),
+		rooms: make(map[string]*Room),
+	}
+}
+
+// SaveMessage stores a message, ignoring IDs that were already saved
+func (r *MemoryRepository) SaveMessage(ctx context.Context, message *Message) error {
+	return r.SaveMessages(ctx, []*Message{message})
+}
+
+// SaveMessages stores a batch of messages, ignoring IDs that were already saved
+func (r *MemoryRepository) SaveMessages(ctx context.Context, messages []*Message) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, message := range messages {
+		if _, exists := r.byID[message.ID]; exists {
+			continue
+		}
+		stored := *message
+		r.messages = append(r.messages, &stored)
+		r.byID[stored.ID] = &stored
+	}
+	return nil
+}
+
+// GetMessagesByRoom returns a page of a room's messages, newest first
+func (r *MemoryRepository) GetMessagesByRoom(ctx context.Context, roomID string, limit, offset int) ([]*Message, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	roomMessages := r.roomHistory(roomID)
+	messages := make([]*Message, 0, limit)
+	for i := offset; i < len(roomMessages) && len(messages) < limit; i++ {
+		message := *roomMessages[i]
+		messages = append(messages, &message)
+	}
+	return messages, nil
+}
+
+// GetMessagesByRoomCursor returns the messages next to a cursor, newest first
+func (r *MemoryRepository) GetMessagesByRoomCursor(ctx context.Context, roomID string, query HistoryQuery) ([]*Message, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var selected []*Message
+	for _, message := range r.roomHistory(roomID) {
+		switch {
+		case query.Before != nil && !query.Before.before(message):
+			continue
+		case query.After != nil && !query.After.after(message):
+			continue
+		}
+		copied := *message
+		selected = append(selected, &copied)
+	}
+
+	if len(selected) > query.Limit {
+		if query.After != nil {
+			// Paging forward keeps the messages closest to the cursor
+			selected = selected[len(selected)-query.Limit:]
+		} else {
+			selected = selected[:query.Limit]
+		}
+	}
+	if selected == nil {
+		selected = []*Message{}
+	}
+	return selected, nil
+}
+
+// SearchMessages matches messages containing a word starting with every
+// search term, ranked by the share of their words that match
+func (r *MemoryRepository) SearchMessages(ctx context.Context, query SearchQuery) ([]*SearchResult, error) {
+	terms := searchTerms(query.Text)
+	if len(terms) == 0 {
+		return []*SearchResult{}, nil
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	accessible := make(map[string]bool)
+	for _, room := range r.rooms {
+		if query.ViewerID != "" && room.OwnerID == query.ViewerID {
+			accessible[room.ID] = true
+		}
+	}
+	for _, message := range r.messages {
+		if query.ViewerID != "" && message.UserID == query.ViewerID {
+			accessible[message.RoomID] = true
+		}
+	}
+
+	results := []*SearchResult{}
+	for _, message := range r.messages {
+		if !query.visibleTo(message, accessible) {
+			continue
+		}
+		result, ok := matchMessage(message, terms)
+		if ok && (query.After == nil || query.After.precedes(result)) {
+			results = append(results, result)
+		}
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].cursor().precedes(results[j])
+	})
+	if len(results) > query.Limit {
+		results = results[:query.Limit]
+	}
+	return results, nil
+}
+
+// matchMessage checks every term against the words of a message and marks
+// the matching words in its snippet
+func matchMessage(message *Message, terms []string) (*SearchResult, bool) {
+	var snippet strings.Builder
+	matched := make(map[string]bool, len(terms))
+	words, hits := 0, 0
+	content := []rune(message.Content)
+	for i := 0; i < len(content); {
+		if !unicode.IsLetter(content[i]) && !unicode.IsDigit(content[i]) {
+			snippet.WriteRune(content[i])
+			i++
+			continue
+		}
+		end := i
+		for end < len(content) && (unicode.IsLetter(content[end]) || unicode.IsDigit(content[end])) {
+			end++
+		}
+		word := string(content[i:end])
+		words++
+
+		hit := false
+		for _, term := range terms {
+			if strings.HasPrefix(strings.ToLower(word), term) {
+				matched[term] = true
+				hit = true
+			}
+		}
+		if hit {
+			hits++
+			snippet.WriteString(highlightStart + word + highlightStop)
+		} else {
+			snippet.WriteString(word)
+		}
+		i = end
+	}
+
+	if len(matched) < len(terms) {
+		return nil, false
+	}
+	copied := *message
+	return &SearchResult{
+		Message: &copied,
+		Rank:    float64(hits) / float64(words),
+		Snippet: highlight(snippet.String()),
+	}, true
+}
+
+// roomHistory returns a room's stored messages ordered newest first
+func (r *MemoryRepository) roomHistory(roomID string) []*Message {
+	var roomMessages []*Message
+	for _, message := range r.messages {
+		if message.RoomID == roomID {
+			roomMessages = append(roomMessages, message)
+		}
+	}
+	sort.Slice(roomMessages, func(i, j int) bool {
+		a, b := roomMessages[i], roomMessages[j]
+		if a.Timestamp.Equal(b.Timestamp) {
+			return a.ID > b.ID
+		}
+		return a.Timestamp.After(b.Timestamp)
+	})
+	return roomMessages
+}
+
+// GetMessageByID returns a message or ErrMessageNotFound
+func (r *MemoryRepository) GetMessageByID(ctx context.Context, id string) (*Message, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	stored, ok := r.byID[id]
+	if !ok {
+		return nil, ErrMessageNotFound
+	}
+	message := *stored
+	return &message, nil
+}
+
+// CreateRoom stores a room or returns ErrRoomExists
+func (r *MemoryRepository) CreateRoom(ctx context.Context, room *Room) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.rooms[room.ID]; exists {
+		return ErrRoomExists
+	}
+	stored := *room
+	r.rooms[room.ID] = &stored
+	return nil
+}
+
+// GetRooms returns every room, most recently active first
+func (r *MemoryRepository) GetRooms(ctx context.Context) ([]*Room, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	rooms := make([]*Room, 0, len(r.rooms))
+	for _, stored := range r.rooms {
+		room := *stored
+		rooms = append(rooms, &room)
+	}
+	sort.Slice(rooms, func(i, j int) bool {
+		return rooms[i].LastActivity.After(rooms[j].LastActivity)
+	})
+	return rooms, nil
+}
+
+// GetRoomByID returns a room or ErrRoomNotFound
+func (r *MemoryRepository) GetRoomByID(ctx context.Context, id string) (*Room, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	stored, ok := r.rooms[id]
+	if !ok {
+		return nil, ErrRoomNotFound
+	}
+	room := *stored
+	return &room, nil
+}
+
+// UpdateRoomActivity sets a room's last activity to now. Unknown rooms are
+// ignored, as an UPDATE matching no rows would be.
+func (r *MemoryRepository) UpdateRoomActivity(ctx context.Context, roomID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if room, ok := r.rooms[roomID]; ok {
+		room.LastActivity = time.Now()
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package message
+
+import (
+	"context"
+	"time"
+
+	"server/internal/metrics"
+)
+
+// InstrumentedRepository wraps a Repository and records the latency of every
+// call and the number of saved messages
+type InstrumentedRepository struct {
+	repo Repository
+}
+
+// NewInstrumentedRepository creates a repository that reports to the metrics
+// registry
+func NewInstrumentedRepository(repo Repository) *InstrumentedRepository {
+	return &InstrumentedRepository{repo: repo}
+}
+
+// observeRepository records the latency of a call that started at start
+func observeRepository(op Operation, start time.Time, err error) {
+	metrics.RepositoryDuration.WithLabelValues(string(op), metrics.Result(err)).Observe(time.Since(start).Seconds())
+}
+
+func (r *InstrumentedRepository) SaveMessage(ctx context.Context, message *Message) error {
+	start := time.Now()
+	err := r.repo.SaveMessage(ctx, message)
+	observeRepository(OpSaveMessage, start, err)
+	if err == nil {
+		metrics.MessagesSaved.Inc()
+	}
+	return err
+}
+
+func (r *InstrumentedRepository) SaveMessages(ctx context.Context, messages []*Message) error {
+	start := time.Now()
+	err := r.repo.SaveMessages(ctx, messages)
+	observeRepository(OpSaveMessages, start, err)
+	if err == nil {
+		metrics.MessagesSaved.Add(float64(len(messages)))
+	}
+	return err
+}
+
+func (r *InstrumentedRepository) GetMessagesByRoom(ctx context.Context, roomID string, limit, offset int) ([]*Message, error) {
+	start := time.Now()
+	messages, err := r.repo.GetMessagesByRoom(ctx, roomID, limit, offset)
+	observeRepository(OpGetMessagesByRoom, start, err)
+	return messages, err
+}
+
+func (r *InstrumentedRepository) GetMessagesByRoomCursor(ctx context.Context, roomID string, query HistoryQuery) ([]*Message, error) {
+	start := time.Now()
+	messages, err := r.repo.GetMessagesByRoomCursor(ctx, roomID, query)
+	observeRepository(OpGetRoomHistory, start, err)
+	return messages, err
+}
+
+func (r *InstrumentedRepository) GetMessageByID(ctx context.Context, id string) (*Message, error) {
+	start := time.Now()
+	message, err := r.repo.GetMessageByID(ctx, id)
+	observeRepository(OpGetMessageByID, start, err)
+	return message, err
+}
+
+func (r *InstrumentedRepository) SearchMessages(ctx context.Context, query SearchQuery) ([]*SearchResult, error) {
+	start := time.Now()
+	results, err := r.repo.SearchMessages(ctx, query)
+	observeRepository(OpSearchMessages, start, err)
+	return results, err
+}
+
+func (r *InstrumentedRepository) CreateRoom(ctx context.Context, room *Room) error {
+	start := time.Now()
+	err := r.repo.CreateRoom(ctx, room)
+	observeRepository(OpCreateRoom, start, err)
+	return err
+}
+
+func (r *InstrumentedRepository) GetRooms(ctx context.Context) ([]*Room, error) {
+	start := time.Now()
+	rooms, err := r.repo.GetRooms(ctx)
+	observeRepository(OpGetRooms, start, err)
+	return rooms, err
+}
+
+func (r *InstrumentedRepository) GetRoomByID(ctx context.Context, id string) (*Room, error) {
+	start := time.Now()
+	room, err := r.repo.GetRoomByID(ctx, id)
+	observeRepository(OpGetRoomByID, start, err)
+	return room, err
+}
+
+func (r *InstrumentedRepository) UpdateRoomActivity(ctx context.Context, roomID string) error {
+	start := time.Now()
+	err := r.repo.UpdateRoomActivity(ctx, roomID)
+	observeRepository(OpUpdateRoomActivity, start, err)
+	return err
+}
@@ -2,6 +2,8 @@ package message
 
 import (
 	"time"
+
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Message represents a chat message stored in the database
@@ -14,13 +16,27 @@ type Message struct {
 	Type      string    `json:"type" db:"type"`
 	Timestamp time.Time `json:"timestamp" db:"timestamp"`
 	Recipient string    `json:"recipient,omitempty" db:"recipient"`
+	// RecipientID is the user ID a private message is addressed to. Only the
+	// recipient and the sender can find private messages in search.
+	RecipientID string `json:"recipientId,omitempty" db:"recipient_id"`
+	// Trace is the span the message was received in, which persistence links
+	// back to. It is not stored.
+	Trace trace.SpanContext `json:"-" db:"-"`
 }
 
 // Room represents a chat room stored in the database
 type Room struct {
-	ID           string    `json:"id" db:"id"`
-	Name         string    `json:"name" db:"name"`
-	OwnerID      string    `json:"ownerId" db:"owner_id"`
-	Created      time.Time `json:"created" db:"created"`
-	LastActivity time.Time `json:"lastActivity" db:"last_activity"`
+	ID              string    `json:"id" db:"id"`
+	Name            string    `json:"name" db:"name"`
+	OwnerID         string    `json:"ownerId" db:"owner_id"`
+	Created         time.Time `json:"created" db:"created"`
+	LastActivity    time.Time `json:"lastActivity" db:"last_activity"`
+	MaxMembers      int       `json:"maxMembers" db:"max_members"`           // 0 means unlimited
+	AllowSpectators bool      `json:"allowSpectators" db:"allow_spectators"` // Admit read-only spectators once full
+}
+
+// RoomCapacity limits how many members a room admits
+type RoomCapacity struct {
+	MaxMembers      int  `json:"maxMembers"`
+	AllowSpectators bool `json:"allowSpectators"`
 }
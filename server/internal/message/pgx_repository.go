@@ -0,0 +1,287 @@
+package message
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Names of the statements PreparePgxStatements prepares on every connection
+const (
+	stmtSaveMessage        = "save_message"
+	stmtRoomHistoryOffset  = "room_history_offset"
+	stmtRoomHistoryLatest  = "room_history_latest"
+	stmtRoomHistoryBefore  = "room_history_before"
+	stmtRoomHistoryAfter   = "room_history_after"
+	stmtMessageByID        = "message_by_id"
+	stmtCreateRoom         = "create_room"
+	stmtRooms              = "rooms"
+	stmtRoomByID           = "room_by_id"
+	stmtUpdateRoomActivity = "update_room_activity"
+)
+
+var pgxStatements = map[string]string{
+	stmtSaveMessage: `
+		INSERT INTO messages (id, room_id, user_id, username, content, type, timestamp, recipient, recipient_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		ON CONFLICT (id) DO NOTHING
+	`,
+	stmtRoomHistoryOffset: `
+		SELECT id, room_id, user_id, username, content, type, timestamp, recipient, recipient_id
+		FROM messages
+		WHERE room_id = $1
+		ORDER BY timestamp DESC, id DESC
+		LIMIT $2 OFFSET $3
+	`,
+	stmtRoomHistoryLatest: `
+		SELECT id, room_id, user_id, username, content, type, timestamp, recipient, recipient_id
+		FROM messages
+		WHERE room_id = $1
+		ORDER BY timestamp DESC, id DESC
+		LIMIT $2
+	`,
+	stmtRoomHistoryBefore: `
+		SELECT id, room_id, user_id, username, content, type, timestamp, recipient, recipient_id
+		FROM messages
+		WHERE room_id = $1 AND (timestamp, id) < ($3, $4)
+		ORDER BY timestamp DESC, id DESC
+		LIMIT $2
+	`,
+	stmtRoomHistoryAfter: `
+		SELECT id, room_id, user_id, username, content, type, timestamp, recipient, recipient_id
+		FROM messages
+		WHERE room_id = $1 AND (timestamp, id) > ($3, $4)
+		ORDER BY timestamp ASC, id ASC
+		LIMIT $2
+	`,
+	stmtMessageByID: `
+		SELECT id, room_id, user_id, username, content, type, timestamp, recipient, recipient_id
+		FROM messages
+		WHERE id = $1
+	`,
+	stmtCreateRoom: `
+		INSERT INTO rooms (id, name, owner_id, created, last_activity, max_members, allow_spectators)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`,
+	stmtRooms: `
+		SELECT id, name, owner_id, created, last_activity, max_members, allow_spectators
+		FROM rooms
+		ORDER BY last_activity DESC
+	`,
+	stmtRoomByID: `
+		SELECT id, name, owner_id, created, last_activity, max_members, allow_spectators
+		FROM rooms
+		WHERE id = $1
+	`,
+	stmtUpdateRoomActivity: `
+		UPDATE rooms
+		SET last_activity = $1
+		WHERE id = $2
+	`,
+}
+
+// PreparePgxStatements prepares the statements of PgxRepository on a new
+// connection. Pass it as the afterConnect hook of the pool.
+func PreparePgxStatements(ctx context.Context, conn *pgx.Conn) error {
+	for name, sql := range pgxStatements {
+		if _, err := conn.Prepare(ctx, name, sql); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PgxRepository implements the Repository interface on a pgx connection pool
+// whose connections were set up with PreparePgxStatements
+type PgxRepository struct {
+	pool *pgxpool.Pool
+}
+
+// NewPgxRepository creates a new repository on a pgx pool
+func NewPgxRepository(pool *pgxpool.Pool) *PgxRepository {
+	return &PgxRepository{
+		pool: pool,
+	}
+}
+
+// SaveMessage stores a message in the database
+func (r *PgxRepository) SaveMessage(ctx context.Context, message *Message) error {
+	_, err := r.pool.Exec(ctx, stmtSaveMessage, messageArgs(message)...)
+	return err
+}
+
+// SaveMessages sends the inserts of a batch in a single round trip inside a
+// transaction, preserving the order of the slice
+func (r *PgxRepository) SaveMessages(ctx context.Context, messages []*Message) error {
+	if len(messages) == 0 {
+		return nil
+	}
+
+	batch := &pgx.Batch{}
+	for _, message := range messages {
+		batch.Queue(stmtSaveMessage, messageArgs(message)...)
+	}
+
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if err := tx.SendBatch(ctx, batch).Close(); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// GetMessagesByRoom retrieves messages for a specific room with pagination
+func (r *PgxRepository) GetMessagesByRoom(ctx context.Context, roomID string, limit, offset int) ([]*Message, error) {
+	rows, err := r.pool.Query(ctx, stmtRoomHistoryOffset, roomID, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+
+	return collectMessages(rows)
+}
+
+// GetMessagesByRoomCursor retrieves the messages next to a cursor, newest
+// first
+func (r *PgxRepository) GetMessagesByRoomCursor(ctx context.Context, roomID string, query HistoryQuery) ([]*Message, error) {
+	var (
+		rows pgx.Rows
+		err  error
+	)
+	switch {
+	case query.Before != nil:
+		rows, err = r.pool.Query(ctx, stmtRoomHistoryBefore, roomID, query.Limit, query.Before.Timestamp, query.Before.ID)
+	case query.After != nil:
+		rows, err = r.pool.Query(ctx, stmtRoomHistoryAfter, roomID, query.Limit, query.After.Timestamp, query.After.ID)
+	default:
+		rows, err = r.pool.Query(ctx, stmtRoomHistoryLatest, roomID, query.Limit)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	messages, err := collectMessages(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	if query.After != nil {
+		reverseMessages(messages)
+	}
+	return messages, nil
+}
+
+// collectMessages scans and closes rows of messages
+func collectMessages(rows pgx.Rows) ([]*Message, error) {
+	defer rows.Close()
+
+	messages := []*Message{}
+	for rows.Next() {
+		msg, err := scanMessage(rows)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, msg)
+	}
+	return messages, rows.Err()
+}
+
+// SearchMessages runs a full-text search over message content, using the
+// same query as PostgresRepository
+func (r *PgxRepository) SearchMessages(ctx context.Context, query SearchQuery) ([]*SearchResult, error) {
+	sql, args := postgresSearchQuery(query)
+	rows, err := r.pool.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []*SearchResult{}
+	for rows.Next() {
+		result, err := scanSearchResult(rows)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, result)
+	}
+
+	return results, rows.Err()
+}
+
+// GetMessageByID retrieves a message by its ID
+func (r *PgxRepository) GetMessageByID(ctx context.Context, id string) (*Message, error) {
+	msg, err := scanMessage(r.pool.QueryRow(ctx, stmtMessageByID, id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrMessageNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return msg, nil
+}
+
+// CreateRoom creates a new chat room
+func (r *PgxRepository) CreateRoom(ctx context.Context, room *Room) error {
+	_, err := r.pool.Exec(
+		ctx,
+		stmtCreateRoom,
+		room.ID,
+		room.Name,
+		room.OwnerID,
+		room.Created,
+		room.LastActivity,
+		room.MaxMembers,
+		room.AllowSpectators,
+	)
+
+	if isUniqueViolation(err) {
+		return ErrRoomExists.Wrap(err)
+	}
+	return err
+}
+
+// GetRooms retrieves all available chat rooms
+func (r *PgxRepository) GetRooms(ctx context.Context) ([]*Room, error) {
+	rows, err := r.pool.Query(ctx, stmtRooms)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rooms []*Room
+	for rows.Next() {
+		room, err := scanRoom(rows)
+		if err != nil {
+			return nil, err
+		}
+		rooms = append(rooms, room)
+	}
+
+	return rooms, rows.Err()
+}
+
+// GetRoomByID retrieves a room by its ID
+func (r *PgxRepository) GetRoomByID(ctx context.Context, id string) (*Room, error) {
+	room, err := scanRoom(r.pool.QueryRow(ctx, stmtRoomByID, id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrRoomNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return room, nil
+}
+
+// UpdateRoomActivity updates the last_activity timestamp for a room
+func (r *PgxRepository) UpdateRoomActivity(ctx context.Context, roomID string) error {
+	_, err := r.pool.Exec(ctx, stmtUpdateRoomActivity, time.Now(), roomID)
+	return err
+}
@@ -3,15 +3,27 @@ package message
 import (
 	"context"
 	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
 	"time"
+
+	"server/db"
 )
 
+// maxMessagesPerInsert keeps multi-row inserts below the Postgres limit of
+// 65535 bind parameters (8 columns per message)
+const maxMessagesPerInsert = 1000
+
 // Repository defines the interface for message data access
 type Repository interface {
 	// Message operations
 	SaveMessage(ctx context.Context, message *Message) error
+	SaveMessages(ctx context.Context, messages []*Message) error
 	GetMessagesByRoom(ctx context.Context, roomID string, limit, offset int) ([]*Message, error)
+	GetMessagesByRoomCursor(ctx context.Context, roomID string, query HistoryQuery) ([]*Message, error)
 	GetMessageByID(ctx context.Context, id string) (*Message, error)
+	SearchMessages(ctx context.Context, query SearchQuery) ([]*SearchResult, error)
 	
 	// Room operations
 	CreateRoom(ctx context.Context, room *Room) error
@@ -22,21 +34,37 @@ type Repository interface {
 
 // PostgresRepository implements the Repository interface using PostgreSQL
 type PostgresRepository struct {
-	db *sql.DB
+	db       *sql.DB
+	replicas *db.ReplicaSet
 }
 
 // NewPostgresRepository creates a new PostgreSQL repository
-func NewPostgresRepository(db *sql.DB) Repository {
+func NewPostgresRepository(db *sql.DB) *PostgresRepository {
 	return &PostgresRepository{
 		db: db,
 	}
 }
 
+// UseReplicas sends the read-only queries to read replicas. Writes keep
+// going to the primary.
+func (r *PostgresRepository) UseReplicas(replicas *db.ReplicaSet) {
+	r.replicas = replicas
+}
+
+// reader returns the pool a read-only query runs on
+func (r *PostgresRepository) reader(ctx context.Context) *sql.DB {
+	if r.replicas == nil {
+		return r.db
+	}
+	return r.replicas.Reader(ctx)
+}
+
 // SaveMessage stores a message in the database
 func (r *PostgresRepository) SaveMessage(ctx context.Context, message *Message) error {
 	query := `
-		INSERT INTO messages (id, room_id, user_id, username, content, type, timestamp, recipient)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		INSERT INTO messages (id, room_id, user_id, username, content, type, timestamp, recipient, recipient_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		ON CONFLICT (id) DO NOTHING
 	`
 	
 	_, err := r.db.ExecContext(
@@ -50,22 +78,82 @@ func (r *PostgresRepository) SaveMessage(ctx context.Context, message *Message)
 		message.Type,
 		message.Timestamp,
 		message.Recipient,
+		message.RecipientID,
 	)
 	
 	return err
 }
 
+// SaveMessages stores a batch of messages using multi-row inserts inside a
+// single transaction, preserving the order of the slice
+func (r *PostgresRepository) SaveMessages(ctx context.Context, messages []*Message) error {
+	if len(messages) == 0 {
+		return nil
+	}
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for start := 0; start < len(messages); start += maxMessagesPerInsert {
+		end := start + maxMessagesPerInsert
+		if end > len(messages) {
+			end = len(messages)
+		}
+
+		query, args := buildMessageInsert(messages[start:end])
+		if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// buildMessageInsert builds a multi-row INSERT statement for the given messages.
+// Rows whose ID already exists are skipped so replayed batches are idempotent.
+func buildMessageInsert(messages []*Message) (string, []interface{}) {
+	var sb strings.Builder
+	sb.WriteString("INSERT INTO messages (id, room_id, user_id, username, content, type, timestamp, recipient, recipient_id) VALUES ")
+
+	args := make([]interface{}, 0, len(messages)*9)
+	for i, message := range messages {
+		if i > 0 {
+			sb.WriteString(", ")
+		}
+		n := i * 9
+		fmt.Fprintf(&sb, "($%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d)", n+1, n+2, n+3, n+4, n+5, n+6, n+7, n+8, n+9)
+		args = append(args,
+			message.ID,
+			message.RoomID,
+			message.UserID,
+			message.Username,
+			message.Content,
+			message.Type,
+			message.Timestamp,
+			message.Recipient,
+			message.RecipientID,
+		)
+	}
+
+	sb.WriteString(" ON CONFLICT (id) DO NOTHING")
+
+	return sb.String(), args
+}
+
 // GetMessagesByRoom retrieves messages for a specific room with pagination
 func (r *PostgresRepository) GetMessagesByRoom(ctx context.Context, roomID string, limit, offset int) ([]*Message, error) {
 	query := `
-		SELECT id, room_id, user_id, username, content, type, timestamp, recipient
+		SELECT id, room_id, user_id, username, content, type, timestamp, recipient, recipient_id
 		FROM messages
 		WHERE room_id = $1
-		ORDER BY timestamp DESC
+		ORDER BY timestamp DESC, id DESC
 		LIMIT $2 OFFSET $3
 	`
 	
-	rows, err := r.db.QueryContext(ctx, query, roomID, limit, offset)
+	rows, err := r.reader(ctx).QueryContext(ctx, query, roomID, limit, offset)
 	if err != nil {
 		return nil, err
 	}
@@ -83,6 +171,7 @@ func (r *PostgresRepository) GetMessagesByRoom(ctx context.Context, roomID strin
 			&msg.Type,
 			&msg.Timestamp,
 			&msg.Recipient,
+			&msg.RecipientID,
 		)
 		if err != nil {
 			return nil, err
@@ -93,16 +182,116 @@ func (r *PostgresRepository) GetMessagesByRoom(ctx context.Context, roomID strin
 	return messages, nil
 }
 
+// GetMessagesByRoomCursor retrieves the messages next to a cursor, newest
+// first, using the (room_id, timestamp, id) index instead of an offset
+func (r *PostgresRepository) GetMessagesByRoomCursor(ctx context.Context, roomID string, query HistoryQuery) ([]*Message, error) {
+	condition, order := "", "DESC"
+	args := []interface{}{roomID, query.Limit}
+	switch {
+	case query.Before != nil:
+		condition = "AND (timestamp, id) < ($3, $4)"
+		args = append(args, query.Before.Timestamp, query.Before.ID)
+	case query.After != nil:
+		condition, order = "AND (timestamp, id) > ($3, $4)", "ASC"
+		args = append(args, query.After.Timestamp, query.After.ID)
+	}
+
+	rows, err := r.reader(ctx).QueryContext(ctx, `
+		SELECT id, room_id, user_id, username, content, type, timestamp, recipient, recipient_id
+		FROM messages
+		WHERE room_id = $1 `+condition+`
+		ORDER BY timestamp `+order+`, id `+order+`
+		LIMIT $2
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	messages := []*Message{}
+	for rows.Next() {
+		msg, err := scanMessage(rows)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, msg)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if query.After != nil {
+		reverseMessages(messages)
+	}
+	return messages, nil
+}
+
+// searchHeadlineOptions makes ts_headline mark matches with the highlight
+// delimiters and keep snippets short
+const searchHeadlineOptions = `StartSel="` + highlightStart + `", StopSel="` + highlightStop + `", MaxFragments=2, MaxWords=20, MinWords=5`
+
+// postgresSearchQuery builds the full-text search over the search_vector
+// column, ranked with ts_rank_cd. Snippets are only built for the rows of the
+// page.
+func postgresSearchQuery(query SearchQuery) (string, []interface{}) {
+	args := []interface{}{query.Text, query.Limit, searchHeadlineOptions}
+	bind := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	conditions := searchConditions(query, bind)
+	cursor := ""
+	if query.After != nil {
+		cursor = fmt.Sprintf("WHERE (rank, timestamp, id) < (%s, %s, %s)",
+			bind(query.After.Rank), bind(query.After.Timestamp), bind(query.After.ID))
+	}
+
+	return `
+		SELECT id, room_id, user_id, username, content, type, timestamp, recipient, recipient_id, rank,
+			ts_headline('english', content, websearch_to_tsquery('english', $1), $3)
+		FROM (
+			SELECT m.id, m.room_id, m.user_id, m.username, m.content, m.type, m.timestamp, m.recipient, m.recipient_id,
+				ts_rank_cd(m.search_vector, q)::float8 AS rank
+			FROM messages m, websearch_to_tsquery('english', $1) q
+			WHERE m.search_vector @@ q` + conditions + `
+		) matches ` + cursor + `
+		ORDER BY rank DESC, timestamp DESC, id DESC
+		LIMIT $2
+	`, args
+}
+
+// SearchMessages runs a full-text search over message content
+func (r *PostgresRepository) SearchMessages(ctx context.Context, query SearchQuery) ([]*SearchResult, error) {
+	sql, args := postgresSearchQuery(query)
+	rows, err := r.reader(ctx).QueryContext(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []*SearchResult{}
+	for rows.Next() {
+		result, err := scanSearchResult(rows)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, result)
+	}
+
+	return results, rows.Err()
+}
+
 // GetMessageByID retrieves a message by its ID
 func (r *PostgresRepository) GetMessageByID(ctx context.Context, id string) (*Message, error) {
 	query := `
-		SELECT id, room_id, user_id, username, content, type, timestamp, recipient
+		SELECT id, room_id, user_id, username, content, type, timestamp, recipient, recipient_id
 		FROM messages
 		WHERE id = $1
 	`
 	
 	msg := &Message{}
-	err := r.db.QueryRowContext(ctx, query, id).Scan(
+	err := r.reader(ctx).QueryRowContext(ctx, query, id).Scan(
 		&msg.ID,
 		&msg.RoomID,
 		&msg.UserID,
@@ -111,8 +300,12 @@ func (r *PostgresRepository) GetMessageByID(ctx context.Context, id string) (*Me
 		&msg.Type,
 		&msg.Timestamp,
 		&msg.Recipient,
+		&msg.RecipientID,
 	)
 	
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrMessageNotFound
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -123,8 +316,8 @@ func (r *PostgresRepository) GetMessageByID(ctx context.Context, id string) (*Me
 // CreateRoom creates a new chat room
 func (r *PostgresRepository) CreateRoom(ctx context.Context, room *Room) error {
 	query := `
-		INSERT INTO rooms (id, name, owner_id, created, last_activity)
-		VALUES ($1, $2, $3, $4, $5)
+		INSERT INTO rooms (id, name, owner_id, created, last_activity, max_members, allow_spectators)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
 	`
 	
 	_, err := r.db.ExecContext(
@@ -135,20 +328,25 @@ func (r *PostgresRepository) CreateRoom(ctx context.Context, room *Room) error {
 		room.OwnerID,
 		room.Created,
 		room.LastActivity,
+		room.MaxMembers,
+		room.AllowSpectators,
 	)
 	
+	if isUniqueViolation(err) {
+		return ErrRoomExists.Wrap(err)
+	}
 	return err
 }
 
 // GetRooms retrieves all available chat rooms
 func (r *PostgresRepository) GetRooms(ctx context.Context) ([]*Room, error) {
 	query := `
-		SELECT id, name, owner_id, created, last_activity
+		SELECT id, name, owner_id, created, last_activity, max_members, allow_spectators
 		FROM rooms
 		ORDER BY last_activity DESC
 	`
 	
-	rows, err := r.db.QueryContext(ctx, query)
+	rows, err := r.reader(ctx).QueryContext(ctx, query)
 	if err != nil {
 		return nil, err
 	}
@@ -163,6 +361,8 @@ func (r *PostgresRepository) GetRooms(ctx context.Context) ([]*Room, error) {
 			&room.OwnerID,
 			&room.Created,
 			&room.LastActivity,
+			&room.MaxMembers,
+			&room.AllowSpectators,
 		)
 		if err != nil {
 			return nil, err
@@ -176,20 +376,25 @@ func (r *PostgresRepository) GetRooms(ctx context.Context) ([]*Room, error) {
 // GetRoomByID retrieves a room by its ID
 func (r *PostgresRepository) GetRoomByID(ctx context.Context, id string) (*Room, error) {
 	query := `
-		SELECT id, name, owner_id, created, last_activity
+		SELECT id, name, owner_id, created, last_activity, max_members, allow_spectators
 		FROM rooms
 		WHERE id = $1
 	`
 	
 	room := &Room{}
-	err := r.db.QueryRowContext(ctx, query, id).Scan(
+	err := r.reader(ctx).QueryRowContext(ctx, query, id).Scan(
 		&room.ID,
 		&room.Name,
 		&room.OwnerID,
 		&room.Created,
 		&room.LastActivity,
+		&room.MaxMembers,
+		&room.AllowSpectators,
 	)
 	
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrRoomNotFound
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -2,11 +2,20 @@ package message
 
 import (
 	"context"
+	"database/sql"
+	"errors"
 	"fmt"
 	"time"
 
+	"server/internal/apperr"
+	"server/internal/metrics"
+	"server/internal/tracing"
+
 	"github.com/cenkalti/backoff/v4"
+	"github.com/jackc/pgx/v5"
 	"github.com/sony/gobreaker"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // CircuitBreakerConfig holds configuration for circuit breaker
@@ -24,14 +33,34 @@ type RetryConfig struct {
 	MaxElapsedTime time.Duration
 	MaxInterval    time.Duration
 	InitialInterval time.Duration
+	MaxRetries     uint64 // zero leaves retries bounded by MaxElapsedTime only
 }
 
+// Operation names a ResilientService call for per-operation configuration
+type Operation string
+
+const (
+	OpSaveMessage        Operation = "SaveMessage"
+	OpSaveMessages       Operation = "SaveMessages"
+	OpGetMessagesByRoom  Operation = "GetMessagesByRoom"
+	OpGetRoomHistory     Operation = "GetRoomHistory"
+	OpGetMessageByID     Operation = "GetMessageByID"
+	OpSearchMessages     Operation = "SearchMessages"
+	OpCreateRoom         Operation = "CreateRoom"
+	OpGetRooms           Operation = "GetRooms"
+	OpGetRoomByID        Operation = "GetRoomByID"
+	OpUpdateRoomActivity Operation = "UpdateRoomActivity"
+)
+
 // ResilientService wraps a Service with circuit breaker and retry mechanisms
 type ResilientService struct {
 	service Service
 	messageBreaker *gobreaker.CircuitBreaker
 	roomBreaker    *gobreaker.CircuitBreaker
 	retryConfig    RetryConfig
+	retryBudgets   map[Operation]RetryConfig
+	bulkheads      map[OperationGroup]*Bulkhead
+	staleCache     Cache
 }
 
 // NewResilientService creates a new resilient service wrapper
@@ -43,6 +72,7 @@ func NewResilientService(service Service, cbConfig CircuitBreakerConfig, retryCo
 		Timeout:       cbConfig.Timeout,
 		ReadyToTrip:   cbConfig.ReadyToTrip,
 		OnStateChange: cbConfig.OnStateChange,
+		IsSuccessful:  isBreakerSuccess,
 	}
 
 	roomCBSettings := gobreaker.Settings{
@@ -52,6 +82,7 @@ func NewResilientService(service Service, cbConfig CircuitBreakerConfig, retryCo
 		Timeout:       cbConfig.Timeout,
 		ReadyToTrip:   cbConfig.ReadyToTrip,
 		OnStateChange: cbConfig.OnStateChange,
+		IsSuccessful:  isBreakerSuccess,
 	}
 
 	return &ResilientService{
@@ -59,28 +90,159 @@ func NewResilientService(service Service, cbConfig CircuitBreakerConfig, retryCo
 		messageBreaker: gobreaker.NewCircuitBreaker(messageCBSettings),
 		roomBreaker:    gobreaker.NewCircuitBreaker(roomCBSettings),
 		retryConfig:    retryConfig,
+		retryBudgets:   make(map[Operation]RetryConfig),
+		bulkheads:      make(map[OperationGroup]*Bulkhead),
+	}
+}
+
+// MessageBreakerState returns the current state of the message circuit breaker
+func (rs *ResilientService) MessageBreakerState() gobreaker.State {
+	return rs.messageBreaker.State()
+}
+
+// RoomBreakerState returns the current state of the room circuit breaker
+func (rs *ResilientService) RoomBreakerState() gobreaker.State {
+	return rs.roomBreaker.State()
+}
+
+// UseRetryConfig overrides the retry budget of a single operation. It must be
+// called before the service is used.
+func (rs *ResilientService) UseRetryConfig(operation Operation, config RetryConfig) {
+	rs.retryBudgets[operation] = config
+}
+
+// UseBulkhead caps concurrency and call duration for a group of operations.
+// It must be called before the service is used.
+func (rs *ResilientService) UseBulkhead(group OperationGroup, config BulkheadConfig) {
+	rs.bulkheads[group] = NewBulkhead(config)
+}
+
+// Bulkhead returns the bulkhead of a group, or nil if the group is unlimited
+func (rs *ResilientService) Bulkhead(group OperationGroup) *Bulkhead {
+	return rs.bulkheads[group]
+}
+
+// UseStaleCache lets reads fall back to possibly outdated cached data while a
+// breaker is open. Such reads are flagged on contexts from WithStaleTracking.
+func (rs *ResilientService) UseStaleCache(cache Cache) {
+	rs.staleCache = cache
+}
+
+// canServeStale reports whether err means a breaker refused the call and
+// a cached copy may be served instead
+func (rs *ResilientService) canServeStale(err error) bool {
+	return rs.staleCache != nil && isBreakerRejection(err)
+}
+
+// isBreakerRejection reports whether err comes from a breaker refusing a call
+func isBreakerRejection(err error) bool {
+	return errors.Is(err, gobreaker.ErrOpenState) || errors.Is(err, gobreaker.ErrTooManyRequests)
+}
+
+// IsRetryable reports whether a failed operation may succeed if tried again.
+// Missing rows, bad data, constraint violations, invalid statements, cancelled
+// requests and breaker rejections all fail the same way on every attempt.
+func IsRetryable(err error) bool {
+	switch {
+	case err == nil:
+		return false
+	case errors.Is(err, sql.ErrNoRows), errors.Is(err, pgx.ErrNoRows), errors.Is(err, context.Canceled):
+		return false
+	case isBreakerRejection(err), errors.Is(err, ErrOverloaded), IsPermanentError(err):
+		return false
+	}
+
+	switch apperr.KindOf(err) {
+	case apperr.KindNotFound, apperr.KindConflict, apperr.KindValidation, apperr.KindUnauthorized, apperr.KindForbidden:
+		return false
+	}
+
+	if class, ok := sqlStateClass(err); ok {
+		switch class {
+		case "28", "42": // invalid authorization, syntax error or access rule violation
+			return false
+		}
 	}
+	return true
 }
 
-// executeWithResilience executes a function with circuit breaker and retry mechanisms
-func (rs *ResilientService) executeWithResilience(ctx context.Context, breaker *gobreaker.CircuitBreaker, operation func() (interface{}, error)) (interface{}, error) {
+// isBreakerSuccess keeps errors that say nothing about the database's health
+// from counting as breaker failures
+func isBreakerSuccess(err error) bool {
+	return err == nil || !IsRetryable(err)
+}
+
+// retryConfigFor returns the retry budget of an operation
+func (rs *ResilientService) retryConfigFor(op Operation) RetryConfig {
+	if config, ok := rs.retryBudgets[op]; ok {
+		return config
+	}
+	return rs.retryConfig
+}
+
+// executeWithResilience executes a function with bulkhead, circuit breaker and
+// retry mechanisms. Errors that are not retryable are returned after the first
+// attempt, and calls rejected by a full bulkhead never reach the breaker.
+func (rs *ResilientService) executeWithResilience(ctx context.Context, op Operation, breaker *gobreaker.CircuitBreaker, operation func(ctx context.Context) (interface{}, error)) (result interface{}, err error) {
+	ctx, span := tracer.Start(ctx, "message.Resilient/"+string(op), trace.WithAttributes(attribute.String("chat.breaker", breaker.Name())))
+	defer func() { tracing.End(span, err) }()
+
+	if bulkhead, ok := rs.bulkheads[operationGroups[op]]; ok {
+		release, err := bulkhead.acquire(ctx)
+		if err != nil {
+			return nil, err
+		}
+		defer release()
+
+		var cancel context.CancelFunc
+		ctx, cancel = bulkhead.withDeadline(ctx)
+		defer cancel()
+	}
+
+	retryConfig := rs.retryConfigFor(op)
 	exponentialBackoff := backoff.NewExponentialBackOff()
-	exponentialBackoff.MaxElapsedTime = rs.retryConfig.MaxElapsedTime
-	exponentialBackoff.MaxInterval = rs.retryConfig.MaxInterval
-	exponentialBackoff.InitialInterval = rs.retryConfig.InitialInterval
+	exponentialBackoff.MaxElapsedTime = retryConfig.MaxElapsedTime
+	exponentialBackoff.MaxInterval = retryConfig.MaxInterval
+	exponentialBackoff.InitialInterval = retryConfig.InitialInterval
 
-	var result interface{}
-	var err error
+	var policy backoff.BackOff = exponentialBackoff
+	if retryConfig.MaxRetries > 0 {
+		policy = backoff.WithMaxRetries(policy, retryConfig.MaxRetries)
+	}
 
+	attempt := 0
 	retryOperation := func() error {
+		attempt++
 		result, err = breaker.Execute(func() (interface{}, error) {
-			return operation()
+			// Every attempt gets its own span so the queries of failed
+			// attempts are not mixed with those of the one that succeeded
+			attemptCtx, attemptSpan := tracer.Start(ctx, "message.Resilient/attempt", trace.WithAttributes(attribute.Int("chat.attempt", attempt)))
+			value, opErr := operation(attemptCtx)
+			tracing.End(attemptSpan, opErr)
+			return value, opErr
 		})
+		if err != nil && !IsRetryable(err) {
+			return backoff.Permanent(err)
+		}
 		return err
 	}
 
-	err = backoff.Retry(retryOperation, backoff.WithContext(exponentialBackoff, ctx))
+	err = backoff.RetryNotify(retryOperation, backoff.WithContext(policy, ctx), func(err error, delay time.Duration) {
+		metrics.Retries.WithLabelValues(string(op)).Inc()
+		span.AddEvent("retry", trace.WithAttributes(
+			attribute.Int("chat.attempt", attempt),
+			attribute.String("error", err.Error()),
+			attribute.String("chat.retry_delay", delay.String()),
+		))
+	})
+	span.SetAttributes(attribute.Int("chat.attempts", attempt))
 	if err != nil {
+		if isBreakerRejection(err) {
+			return nil, ErrUnavailable.Wrap(err)
+		}
+		if !IsRetryable(err) {
+			return nil, err
+		}
 		return nil, fmt.Errorf("operation failed after retries: %w", err)
 	}
 
@@ -89,38 +251,89 @@ func (rs *ResilientService) executeWithResilience(ctx context.Context, breaker *
 
 // SaveMessage implements Service with resilience
 func (rs *ResilientService) SaveMessage(ctx context.Context, message *Message) error {
-	_, err := rs.executeWithResilience(ctx, rs.messageBreaker, func() (interface{}, error) {
+	_, err := rs.executeWithResilience(ctx, OpSaveMessage, rs.messageBreaker, func(ctx context.Context) (interface{}, error) {
 		return nil, rs.service.SaveMessage(ctx, message)
 	})
 	return err
 }
 
+// SaveMessages implements Service with resilience
+func (rs *ResilientService) SaveMessages(ctx context.Context, messages []*Message) error {
+	_, err := rs.executeWithResilience(ctx, OpSaveMessages, rs.messageBreaker, func(ctx context.Context) (interface{}, error) {
+		return nil, rs.service.SaveMessages(ctx, messages)
+	})
+	return err
+}
+
 // GetMessagesByRoom implements Service with resilience
 func (rs *ResilientService) GetMessagesByRoom(ctx context.Context, roomID string, limit, offset int) ([]*Message, error) {
-	result, err := rs.executeWithResilience(ctx, rs.messageBreaker, func() (interface{}, error) {
+	result, err := rs.executeWithResilience(ctx, OpGetMessagesByRoom, rs.messageBreaker, func(ctx context.Context) (interface{}, error) {
 		return rs.service.GetMessagesByRoom(ctx, roomID, limit, offset)
 	})
 	if err != nil {
+		if rs.canServeStale(err) {
+			messages, covered, cacheErr := rs.staleCache.GetRoomWindow(ctx, roomID, limit, offset)
+			if cacheErr == nil && covered {
+				markStale(ctx)
+				return messages, nil
+			}
+		}
 		return nil, err
 	}
 	return result.([]*Message), nil
 }
 
+// GetRoomHistory implements Service with resilience. Only the latest page can
+// fall back to the stale cache, which holds no older history.
+func (rs *ResilientService) GetRoomHistory(ctx context.Context, roomID string, query HistoryQuery) (*HistoryPage, error) {
+	result, err := rs.executeWithResilience(ctx, OpGetRoomHistory, rs.messageBreaker, func(ctx context.Context) (interface{}, error) {
+		return rs.service.GetRoomHistory(ctx, roomID, query)
+	})
+	if err != nil {
+		if rs.canServeStale(err) && query.Before == nil && query.After == nil {
+			messages, covered, cacheErr := rs.staleCache.GetRoomWindow(ctx, roomID, query.Limit+1, 0)
+			if cacheErr == nil && covered {
+				markStale(ctx)
+				return newHistoryPage(messages, query), nil
+			}
+		}
+		return nil, err
+	}
+	return result.(*HistoryPage), nil
+}
+
 // GetMessageByID implements Service with resilience
 func (rs *ResilientService) GetMessageByID(ctx context.Context, id string) (*Message, error) {
-	result, err := rs.executeWithResilience(ctx, rs.messageBreaker, func() (interface{}, error) {
+	result, err := rs.executeWithResilience(ctx, OpGetMessageByID, rs.messageBreaker, func(ctx context.Context) (interface{}, error) {
 		return rs.service.GetMessageByID(ctx, id)
 	})
 	if err != nil {
+		if rs.canServeStale(err) {
+			if message, cacheErr := rs.staleCache.GetCachedMessage(ctx, id); cacheErr == nil {
+				markStale(ctx)
+				return message, nil
+			}
+		}
 		return nil, err
 	}
 	return result.(*Message), nil
 }
 
+// SearchMessages implements Service with resilience
+func (rs *ResilientService) SearchMessages(ctx context.Context, query SearchQuery) (*SearchPage, error) {
+	result, err := rs.executeWithResilience(ctx, OpSearchMessages, rs.messageBreaker, func(ctx context.Context) (interface{}, error) {
+		return rs.service.SearchMessages(ctx, query)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result.(*SearchPage), nil
+}
+
 // CreateRoom implements Service with resilience
-func (rs *ResilientService) CreateRoom(ctx context.Context, id, name, ownerID string) (*Room, error) {
-	result, err := rs.executeWithResilience(ctx, rs.roomBreaker, func() (interface{}, error) {
-		return rs.service.CreateRoom(ctx, id, name, ownerID)
+func (rs *ResilientService) CreateRoom(ctx context.Context, id, name, ownerID string, capacity RoomCapacity) (*Room, error) {
+	result, err := rs.executeWithResilience(ctx, OpCreateRoom, rs.roomBreaker, func(ctx context.Context) (interface{}, error) {
+		return rs.service.CreateRoom(ctx, id, name, ownerID, capacity)
 	})
 	if err != nil {
 		return nil, err
@@ -130,10 +343,16 @@ func (rs *ResilientService) CreateRoom(ctx context.Context, id, name, ownerID st
 
 // GetRooms implements Service with resilience
 func (rs *ResilientService) GetRooms(ctx context.Context) ([]*Room, error) {
-	result, err := rs.executeWithResilience(ctx, rs.roomBreaker, func() (interface{}, error) {
+	result, err := rs.executeWithResilience(ctx, OpGetRooms, rs.roomBreaker, func(ctx context.Context) (interface{}, error) {
 		return rs.service.GetRooms(ctx)
 	})
 	if err != nil {
+		if rs.canServeStale(err) {
+			if rooms, cacheErr := rs.staleCache.GetCachedRoomList(ctx); cacheErr == nil && len(rooms) > 0 {
+				markStale(ctx)
+				return rooms, nil
+			}
+		}
 		return nil, err
 	}
 	return result.([]*Room), nil
@@ -141,10 +360,16 @@ func (rs *ResilientService) GetRooms(ctx context.Context) ([]*Room, error) {
 
 // GetRoomByID implements Service with resilience
 func (rs *ResilientService) GetRoomByID(ctx context.Context, id string) (*Room, error) {
-	result, err := rs.executeWithResilience(ctx, rs.roomBreaker, func() (interface{}, error) {
+	result, err := rs.executeWithResilience(ctx, OpGetRoomByID, rs.roomBreaker, func(ctx context.Context) (interface{}, error) {
 		return rs.service.GetRoomByID(ctx, id)
 	})
 	if err != nil {
+		if rs.canServeStale(err) {
+			if room, cacheErr := rs.staleCache.GetCachedRoom(ctx, id); cacheErr == nil {
+				markStale(ctx)
+				return room, nil
+			}
+		}
 		return nil, err
 	}
 	return result.(*Room), nil
@@ -152,7 +377,7 @@ func (rs *ResilientService) GetRoomByID(ctx context.Context, id string) (*Room,
 
 // UpdateRoomActivity implements Service with resilience
 func (rs *ResilientService) UpdateRoomActivity(ctx context.Context, roomID string) error {
-	_, err := rs.executeWithResilience(ctx, rs.roomBreaker, func() (interface{}, error) {
+	_, err := rs.executeWithResilience(ctx, OpUpdateRoomActivity, rs.roomBreaker, func(ctx context.Context) (interface{}, error) {
 		return nil, rs.service.UpdateRoomActivity(ctx, roomID)
 	})
 	return err
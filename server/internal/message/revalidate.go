@@ -0,0 +1,185 @@
+package message
+
+import (
+	"context"
+	"log"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+const (
+	// How long cached entries are served without revalidation. Past these, the
+	// cached copy is still served while a background refresh replaces it.
+	roomListFreshFor = 30 * time.Second
+	roomFreshFor     = 1 * time.Minute
+	messageFreshFor  = 10 * time.Minute
+
+	// earlyExpiryBeta scales probabilistic early expiry; above 1 favours
+	// refreshing earlier
+	earlyExpiryBeta = 1.0
+
+	// refreshTimeout bounds a shared load or background refresh
+	refreshTimeout = 5 * time.Second
+
+	// refreshRetryInterval delays the next refresh of a key whose last one failed
+	refreshRetryInterval = 5 * time.Second
+
+	// maxTrackedKeys bounds the freshness metadata kept in process
+	maxTrackedKeys = DefaultMemoryCacheSize
+)
+
+type loadFunc func(ctx context.Context) (interface{}, error)
+
+// freshness records when a cached key goes stale and how long it took to load
+type freshness struct {
+	staleAt time.Time
+	delta   time.Duration
+}
+
+// flight is a load of a key shared by every caller that asked for it
+type flight struct {
+	done  chan struct{}
+	value interface{}
+	err   error
+}
+
+// revalidator coalesces concurrent loads of the same cache key into a single
+// database query and decides when a cached entry should be refreshed in the
+// background. Freshness is tracked in process; keys it has not seen, such as
+// those written by another replica, count as stale so they are refreshed once.
+type revalidator struct {
+	mu      sync.Mutex
+	entries map[string]freshness
+	flights map[string]*flight
+	beta    float64
+}
+
+func newRevalidator() *revalidator {
+	return &revalidator{
+		entries: make(map[string]freshness),
+		flights: make(map[string]*flight),
+		beta:    earlyExpiryBeta,
+	}
+}
+
+// load runs fn for key unless a load of the same key is already in flight, in
+// which case it waits for and returns that load's result. The load is shared,
+// so it runs detached from the cancellation of the caller that started it and
+// a caller giving up does not fail the others.
+func (r *revalidator) load(ctx context.Context, key string, ttl time.Duration, fn loadFunc) (interface{}, error) {
+	r.mu.Lock()
+	f, ok := r.flights[key]
+	if !ok {
+		f = r.begin(key)
+		go func() {
+			loadCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), refreshTimeout)
+			defer cancel()
+			r.run(loadCtx, key, ttl, f, fn)
+		}()
+	}
+	r.mu.Unlock()
+
+	select {
+	case <-f.done:
+		return f.value, f.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// revalidate refreshes key in the background when its cached copy is stale or,
+// with a probability that grows as it nears staleness, about to become stale
+func (r *revalidator) revalidate(key string, ttl time.Duration, fn loadFunc) {
+	r.mu.Lock()
+	if _, ok := r.flights[key]; ok || !r.due(key) {
+		r.mu.Unlock()
+		return
+	}
+
+	f := r.begin(key)
+	r.mu.Unlock()
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), refreshTimeout)
+		defer cancel()
+
+		r.run(ctx, key, ttl, f, fn)
+		if f.err != nil {
+			log.Printf("Background refresh of %s failed: %v", key, f.err)
+		}
+	}()
+}
+
+// refreshed marks key as fresh after the service wrote it to the cache
+func (r *revalidator) refreshed(key string, ttl time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.track(key, ttl, r.entries[key].delta)
+}
+
+// begin registers a flight for key. Callers hold r.mu.
+func (r *revalidator) begin(key string) *flight {
+	f := &flight{done: make(chan struct{})}
+	r.flights[key] = f
+	return f
+}
+
+// run executes a registered flight and records the freshness of its result
+func (r *revalidator) run(ctx context.Context, key string, ttl time.Duration, f *flight, fn loadFunc) {
+	start := time.Now()
+	f.value, f.err = fn(ctx)
+	delta := time.Since(start)
+
+	r.mu.Lock()
+	delete(r.flights, key)
+	if f.err == nil {
+		r.track(key, ttl, delta)
+	} else {
+		r.track(key, refreshRetryInterval, r.entries[key].delta)
+	}
+	r.mu.Unlock()
+
+	close(f.done)
+}
+
+// due reports whether key should be refreshed now. Ahead of staleness it uses
+// probabilistic early expiry: the longer a key takes to load, the earlier it
+// is likely to be refreshed. Callers hold r.mu.
+func (r *revalidator) due(key string) bool {
+	entry, ok := r.entries[key]
+	if !ok {
+		return true
+	}
+
+	now := time.Now()
+	if !now.Before(entry.staleAt) {
+		return true
+	}
+
+	early := time.Duration(-float64(entry.delta) * r.beta * math.Log(1-rand.Float64()))
+	return !now.Add(early).Before(entry.staleAt)
+}
+
+// track records when key goes stale, making room if too many keys are tracked.
+// Callers hold r.mu.
+func (r *revalidator) track(key string, ttl time.Duration, delta time.Duration) {
+	if _, ok := r.entries[key]; !ok && len(r.entries) >= maxTrackedKeys {
+		now := time.Now()
+		for tracked, entry := range r.entries {
+			if now.After(entry.staleAt) {
+				delete(r.entries, tracked)
+			}
+		}
+
+		for tracked := range r.entries {
+			if len(r.entries) < maxTrackedKeys {
+				break
+			}
+			delete(r.entries, tracked)
+		}
+	}
+
+	r.entries[key] = freshness{staleAt: time.Now().Add(ttl), delta: delta}
+}
@@ -0,0 +1,197 @@
+package message
+
+import (
+	"html"
+	"strings"
+	"time"
+	"unicode"
+
+	"server/internal/apperr"
+)
+
+var ErrEmptySearch = apperr.Validation("invalid_search", "search text is required")
+
+// Matches in snippets are delimited with private use characters, which are
+// swapped for <mark> tags once the rest of the snippet has been escaped
+const (
+	highlightStart = "\uE000"
+	highlightStop  = "\uE001"
+)
+
+// SearchQuery describes a full-text search over message content. Empty
+// filters match everything, but results are always limited to what the user
+// ViewerID names can read: the rooms they own or have posted in, which
+// includes the join message saved when they connect signed in, and of the
+// private messages only those they sent or received. A search without a
+// viewer matches nothing.
+type SearchQuery struct {
+	Text     string
+	ViewerID string
+	RoomID   string
+	Sender   string
+	Type     string
+	Since    time.Time // Inclusive
+	Until    time.Time // Exclusive
+	Limit    int
+	After    *SearchCursor
+}
+
+// SearchCursor marks a position in search results, which are ordered by rank
+// and then newest first
+type SearchCursor struct {
+	Rank      float64   `json:"r"`
+	Timestamp time.Time `json:"t"`
+	ID        string    `json:"id"`
+}
+
+// Encode returns the opaque form of the cursor handed to clients
+func (c *SearchCursor) Encode() string {
+	return encodeCursor(c)
+}
+
+// DecodeSearchCursor parses a cursor produced by SearchCursor.Encode
+func DecodeSearchCursor(s string) (*SearchCursor, error) {
+	var cursor SearchCursor
+	if err := decodeCursor(s, &cursor); err != nil {
+		return nil, err
+	}
+	if cursor.ID == "" || cursor.Timestamp.IsZero() {
+		return nil, ErrInvalidCursor
+	}
+	return &cursor, nil
+}
+
+// SearchResult is a matching message with its relevance and a snippet of
+// HTML escaped content in which matches are wrapped in <mark> tags
+type SearchResult struct {
+	Message *Message `json:"message"`
+	Rank    float64  `json:"rank"`
+	Snippet string   `json:"snippet"`
+}
+
+// cursor returns the position of a result
+func (r *SearchResult) cursor() *SearchCursor {
+	return &SearchCursor{Rank: r.Rank, Timestamp: r.Message.Timestamp.UTC(), ID: r.Message.ID}
+}
+
+// precedes reports whether the cursor sorts before a result, which then
+// belongs on a later page
+func (c *SearchCursor) precedes(r *SearchResult) bool {
+	switch {
+	case r.Rank != c.Rank:
+		return r.Rank < c.Rank
+	case !r.Message.Timestamp.Equal(c.Timestamp):
+		return r.Message.Timestamp.Before(c.Timestamp)
+	default:
+		return r.Message.ID < c.ID
+	}
+}
+
+// SearchPage is a page of search results. NextCursor continues with less
+// relevant results and is empty on the last page.
+type SearchPage struct {
+	Results    []*SearchResult `json:"results"`
+	NextCursor string          `json:"nextCursor,omitempty"`
+}
+
+// newSearchPage builds a page from up to limit+1 results, using the extra
+// result only to tell whether more remain
+func newSearchPage(results []*SearchResult, limit int) *SearchPage {
+	page := &SearchPage{Results: results}
+	if len(results) > limit {
+		page.Results = results[:limit]
+		page.NextCursor = page.Results[limit-1].cursor().Encode()
+	}
+	if page.Results == nil {
+		page.Results = []*SearchResult{}
+	}
+	return page
+}
+
+// scanSearchResult reads a message followed by its rank and raw snippet
+func scanSearchResult(row rowScanner) (*SearchResult, error) {
+	msg := &Message{}
+	result := &SearchResult{Message: msg}
+	var snippet string
+	err := row.Scan(
+		&msg.ID,
+		&msg.RoomID,
+		&msg.UserID,
+		&msg.Username,
+		&msg.Content,
+		&msg.Type,
+		&msg.Timestamp,
+		&msg.Recipient,
+		&msg.RecipientID,
+		&result.Rank,
+		&snippet,
+	)
+	if err != nil {
+		return nil, err
+	}
+	result.Snippet = highlight(snippet)
+	return result, nil
+}
+
+// searchConditions renders the filters and access rules of a search as SQL
+// conditions on the messages table aliased m. bind adds an argument and
+// returns its placeholder.
+func searchConditions(query SearchQuery, bind func(interface{}) string) string {
+	var sb strings.Builder
+	if query.RoomID != "" {
+		sb.WriteString(" AND m.room_id = " + bind(query.RoomID))
+	}
+	if query.Sender != "" {
+		sb.WriteString(" AND m.username = " + bind(query.Sender))
+	}
+	if query.Type != "" {
+		sb.WriteString(" AND m.type = " + bind(query.Type))
+	}
+	if !query.Since.IsZero() {
+		sb.WriteString(" AND m.timestamp >= " + bind(query.Since))
+	}
+	if !query.Until.IsZero() {
+		sb.WriteString(" AND m.timestamp < " + bind(query.Until))
+	}
+
+	if query.ViewerID == "" {
+		return sb.String() + " AND 1 = 0"
+	}
+	viewer := query.ViewerID
+	sb.WriteString(" AND (EXISTS (SELECT 1 FROM rooms r WHERE r.id = m.room_id AND r.owner_id = " + bind(viewer) + ")" +
+		" OR EXISTS (SELECT 1 FROM messages j WHERE j.room_id = m.room_id AND j.user_id = " + bind(viewer) + "))")
+	sb.WriteString(" AND (m.recipient = '' OR m.user_id = " + bind(viewer) + " OR m.recipient_id = " + bind(viewer) + ")")
+	return sb.String()
+}
+
+// visibleTo reports whether a message passes the filters and access rules of
+// a search, mirroring searchConditions. accessible holds the rooms the
+// viewer can read.
+func (q SearchQuery) visibleTo(message *Message, accessible map[string]bool) bool {
+	switch {
+	case q.ViewerID == "",
+		!accessible[message.RoomID],
+		q.RoomID != "" && message.RoomID != q.RoomID,
+		q.Sender != "" && message.Username != q.Sender,
+		q.Type != "" && message.Type != q.Type,
+		!q.Since.IsZero() && message.Timestamp.Before(q.Since),
+		!q.Until.IsZero() && !message.Timestamp.Before(q.Until):
+		return false
+	}
+	return message.Recipient == "" || message.UserID == q.ViewerID || message.RecipientID == q.ViewerID
+}
+
+// searchTerms splits search text into lower case words
+func searchTerms(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// highlight escapes a snippet produced with the highlight delimiters and
+// turns the delimiters into <mark> tags
+func highlight(snippet string) string {
+	escaped := html.EscapeString(snippet)
+	escaped = strings.ReplaceAll(escaped, highlightStart, "<mark>")
+	return strings.ReplaceAll(escaped, highlightStop, "</mark>")
+}
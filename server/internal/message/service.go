@@ -2,6 +2,9 @@ package message
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -10,10 +13,13 @@ import (
 type Service interface {
 	// Message operations
 	SaveMessage(ctx context.Context, message *Message) error
+	SaveMessages(ctx context.Context, messages []*Message) error
 	GetMessagesByRoom(ctx context.Context, roomID string, limit, offset int) ([]*Message, error)
+	GetRoomHistory(ctx context.Context, roomID string, query HistoryQuery) (*HistoryPage, error)
 	GetMessageByID(ctx context.Context, id string) (*Message, error)
+	SearchMessages(ctx context.Context, query SearchQuery) (*SearchPage, error)
 
-	CreateRoom(ctx context.Context, id, name, ownerID string) (*Room, error)
+	CreateRoom(ctx context.Context, id, name, ownerID string, capacity RoomCapacity) (*Room, error)
 	GetRooms(ctx context.Context) ([]*Room, error)
 	GetRoomByID(ctx context.Context, id string) (*Room, error)
 	UpdateRoomActivity(ctx context.Context, roomID string) error
@@ -26,12 +32,19 @@ type Service interface {
 type DefaultService struct {
 	repo  Repository
 	cache Cache
+	fresh *revalidator
 }
 
+// NewService creates the message service. A nil cache disables caching.
 func NewService(repo Repository, cache Cache) Service {
+	if cache == nil {
+		cache = NewNoopCache()
+	}
+
 	return &DefaultService{
 		repo:  repo,
 		cache: cache,
+		fresh: newRevalidator(),
 	}
 }
 
@@ -48,7 +61,11 @@ func (s *DefaultService) SaveMessage(ctx context.Context, message *Message) erro
 		return err
 	}
 
-	if err := s.cache.CacheMessage(ctx, message); err != nil {
+	if err := s.cache.CacheMessage(ctx, message); err == nil {
+		s.fresh.refreshed(fmt.Sprintf("%s%s", messageKeyPrefix, message.ID), messageFreshFor)
+	}
+
+	if err := s.cache.AppendRoomMessage(ctx, message); err != nil {
 	}
 
 	if err := s.UpdateRoomActivity(ctx, message.RoomID); err != nil {
@@ -56,31 +73,133 @@ func (s *DefaultService) SaveMessage(ctx context.Context, message *Message) erro
 
 	return nil
 }
+
+// SaveMessages stores a batch of messages in one round trip and refreshes the
+// cache and activity of every room touched by the batch
+func (s *DefaultService) SaveMessages(ctx context.Context, messages []*Message) error {
+	now := time.Now()
+	for _, message := range messages {
+		if message.ID == "" {
+			message.ID = uuid.New().String()
+		}
+		if message.Timestamp.IsZero() {
+			message.Timestamp = now
+		}
+	}
+
+	if err := s.repo.SaveMessages(ctx, messages); err != nil {
+		return err
+	}
+
+	rooms := make(map[string]struct{})
+	for _, message := range messages {
+		if err := s.cache.CacheMessage(ctx, message); err == nil {
+			s.fresh.refreshed(fmt.Sprintf("%s%s", messageKeyPrefix, message.ID), messageFreshFor)
+		}
+		if err := s.cache.AppendRoomMessage(ctx, message); err != nil {
+		}
+		rooms[message.RoomID] = struct{}{}
+	}
+
+	for roomID := range rooms {
+		if err := s.UpdateRoomActivity(ctx, roomID); err != nil {
+		}
+	}
+
+	return nil
+}
+
+// GetMessagesByRoom serves a page of room history, newest first, from the
+// cached window when it covers the page and from the database otherwise
 func (s *DefaultService) GetMessagesByRoom(ctx context.Context, roomID string, limit, offset int) ([]*Message, error) {
-	cachedMessages, err := s.cache.GetCachedRoomMessages(ctx, roomID)
-	if err == nil && len(cachedMessages) > 0 {
-		return cachedMessages, nil
+	messages, covered, err := s.cache.GetRoomWindow(ctx, roomID, limit, offset)
+	if err == nil && covered {
+		return messages, nil
 	}
 
-	messages, err := s.repo.GetMessagesByRoom(ctx, roomID, limit, offset)
+	if errors.Is(err, ErrCacheMiss) {
+		window, err := s.repo.GetMessagesByRoom(ctx, roomID, defaultRoomWindowSize, 0)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := s.cache.CacheRoomWindow(ctx, roomID, window, len(window) < defaultRoomWindowSize); err != nil {
+		}
+
+		if page, ok := windowPage(window, limit, offset); ok {
+			return page, nil
+		}
+	}
+
+	return s.repo.GetMessagesByRoom(ctx, roomID, limit, offset)
+}
+
+// GetRoomHistory serves a page of room history around a cursor. The latest
+// page goes through the cached window; older pages are read with a keyset
+// query, so they stay stable while new messages arrive.
+func (s *DefaultService) GetRoomHistory(ctx context.Context, roomID string, query HistoryQuery) (*HistoryPage, error) {
+	fetch := query
+	fetch.Limit = query.Limit + 1
+
+	var messages []*Message
+	var err error
+	if query.Before == nil && query.After == nil {
+		messages, err = s.GetMessagesByRoom(ctx, roomID, fetch.Limit, 0)
+	} else {
+		messages, err = s.repo.GetMessagesByRoomCursor(ctx, roomID, fetch)
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	if len(messages) > 0 {
-		if err := s.cache.CacheRoomMessages(ctx, roomID, messages); err != nil {
-		}
+	return newHistoryPage(messages, query), nil
+}
+
+// windowPage slices a page out of a freshly loaded window, reporting false if
+// the window does not cover it
+func windowPage(window []*Message, limit, offset int) ([]*Message, bool) {
+	if limit <= 0 || offset < 0 {
+		return nil, false
 	}
 
-	return messages, nil
+	complete := len(window) < defaultRoomWindowSize
+	if offset+limit > len(window) && !complete {
+		return nil, false
+	}
+	if offset >= len(window) {
+		return []*Message{}, true
+	}
+
+	end := offset + limit
+	if end > len(window) {
+		end = len(window)
+	}
+	return window[offset:end], true
 }
 
+// GetMessageByID retrieves a message, serving a stale cached copy while it is
+// refreshed in the background and coalescing concurrent cache misses
 func (s *DefaultService) GetMessageByID(ctx context.Context, id string) (*Message, error) {
+	key := fmt.Sprintf("%s%s", messageKeyPrefix, id)
+	load := func(ctx context.Context) (interface{}, error) {
+		return s.loadMessage(ctx, id)
+	}
+
 	cachedMessage, err := s.cache.GetCachedMessage(ctx, id)
 	if err == nil {
+		s.fresh.revalidate(key, messageFreshFor, load)
 		return cachedMessage, nil
 	}
 
+	message, err := s.fresh.load(ctx, key, messageFreshFor, load)
+	if err != nil {
+		return nil, err
+	}
+	return message.(*Message), nil
+}
+
+// loadMessage reads a message from the database and caches it
+func (s *DefaultService) loadMessage(ctx context.Context, id string) (*Message, error) {
 	message, err := s.repo.GetMessageByID(ctx, id)
 	if err != nil {
 		return nil, err
@@ -92,13 +211,32 @@ func (s *DefaultService) GetMessageByID(ctx context.Context, id string) (*Messag
 	return message, nil
 }
 
-func (s *DefaultService) CreateRoom(ctx context.Context, id, name, ownerID string) (*Room, error) {
+// SearchMessages runs a full-text search. Results always come from the
+// database, since the cache only holds recent history.
+func (s *DefaultService) SearchMessages(ctx context.Context, query SearchQuery) (*SearchPage, error) {
+	if strings.TrimSpace(query.Text) == "" {
+		return nil, ErrEmptySearch
+	}
+
+	fetch := query
+	fetch.Limit = query.Limit + 1
+	results, err := s.repo.SearchMessages(ctx, fetch)
+	if err != nil {
+		return nil, err
+	}
+
+	return newSearchPage(results, query.Limit), nil
+}
+
+func (s *DefaultService) CreateRoom(ctx context.Context, id, name, ownerID string, capacity RoomCapacity) (*Room, error) {
 	room := &Room{
-		ID:           id,
-		Name:         name,
-		OwnerID:      ownerID,
-		Created:      time.Now(),
-		LastActivity: time.Now(),
+		ID:              id,
+		Name:            name,
+		OwnerID:         ownerID,
+		Created:         time.Now(),
+		LastActivity:    time.Now(),
+		MaxMembers:      capacity.MaxMembers,
+		AllowSpectators: capacity.AllowSpectators,
 	}
 
 	// Save to database
@@ -107,29 +245,42 @@ func (s *DefaultService) CreateRoom(ctx context.Context, id, name, ownerID strin
 	}
 
 	// Update cache
-	if err := s.cache.CacheRoom(ctx, room); err != nil {
-		// Log error but don't fail the operation
-		// log.Printf("Error caching room: %v", err)
+	if err := s.cache.CacheRoom(ctx, room); err == nil {
+		s.fresh.refreshed(fmt.Sprintf("%s%s", roomKeyPrefix, room.ID), roomFreshFor)
 	}
 
 	// Invalidate room list cache by getting fresh data and updating
-	rooms, err := s.repo.GetRooms(ctx)
-	if err == nil {
-		s.cache.CacheRoomList(ctx, rooms)
+	if _, err := s.loadRooms(ctx); err != nil {
+		// log.Printf("Error refreshing room list: %v", err)
 	}
 
 	return room, nil
 }
 
-// GetRooms retrieves all available chat rooms with caching
+// GetRooms retrieves all available chat rooms with caching. A stale room list
+// is served while it is refreshed in the background, and concurrent cache
+// misses share a single database query.
 func (s *DefaultService) GetRooms(ctx context.Context) ([]*Room, error) {
+	load := func(ctx context.Context) (interface{}, error) {
+		return s.loadRooms(ctx)
+	}
+
 	// Try to get from cache first
 	cachedRooms, err := s.cache.GetCachedRoomList(ctx)
 	if err == nil && len(cachedRooms) > 0 {
+		s.fresh.revalidate(roomListKey, roomListFreshFor, load)
 		return cachedRooms, nil
 	}
 
-	// Get from database
+	rooms, err := s.fresh.load(ctx, roomListKey, roomListFreshFor, load)
+	if err != nil {
+		return nil, err
+	}
+	return rooms.([]*Room), nil
+}
+
+// loadRooms reads all rooms from the database and caches the list
+func (s *DefaultService) loadRooms(ctx context.Context) ([]*Room, error) {
 	rooms, err := s.repo.GetRooms(ctx)
 	if err != nil {
 		return nil, err
@@ -137,24 +288,38 @@ func (s *DefaultService) GetRooms(ctx context.Context) ([]*Room, error) {
 
 	// Update cache
 	if len(rooms) > 0 {
-		if err := s.cache.CacheRoomList(ctx, rooms); err != nil {
-			// Log error but don't fail the operation
-			// log.Printf("Error caching room list: %v", err)
+		if err := s.cache.CacheRoomList(ctx, rooms); err == nil {
+			s.fresh.refreshed(roomListKey, roomListFreshFor)
 		}
 	}
 
 	return rooms, nil
 }
 
-// GetRoomByID retrieves a room by its ID with caching
+// GetRoomByID retrieves a room by its ID with caching, serving a stale copy
+// while it is refreshed in the background
 func (s *DefaultService) GetRoomByID(ctx context.Context, id string) (*Room, error) {
+	key := fmt.Sprintf("%s%s", roomKeyPrefix, id)
+	load := func(ctx context.Context) (interface{}, error) {
+		return s.loadRoom(ctx, id)
+	}
+
 	// Try to get from cache first
 	cachedRoom, err := s.cache.GetCachedRoom(ctx, id)
 	if err == nil {
+		s.fresh.revalidate(key, roomFreshFor, load)
 		return cachedRoom, nil
 	}
 
-	// Get from database
+	room, err := s.fresh.load(ctx, key, roomFreshFor, load)
+	if err != nil {
+		return nil, err
+	}
+	return room.(*Room), nil
+}
+
+// loadRoom reads a room from the database and caches it
+func (s *DefaultService) loadRoom(ctx context.Context, id string) (*Room, error) {
 	room, err := s.repo.GetRoomByID(ctx, id)
 	if err != nil {
 		return nil, err
@@ -177,9 +342,8 @@ func (s *DefaultService) UpdateRoomActivity(ctx context.Context, roomID string)
 	}
 
 	// Get updated room to refresh cache
-	room, err := s.repo.GetRoomByID(ctx, roomID)
-	if err == nil {
-		s.cache.CacheRoom(ctx, room)
+	if _, err := s.loadRoom(ctx, roomID); err == nil {
+		s.fresh.refreshed(fmt.Sprintf("%s%s", roomKeyPrefix, roomID), roomFreshFor)
 	}
 
 	return nil
@@ -0,0 +1,322 @@
+package message
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	activeSegmentName = "messages.spool"
+	replaySegmentName = "messages.replay"
+)
+
+// Spool is an append-only, on-disk write-ahead log for messages that could not
+// be persisted. Each line of a segment holds one JSON encoded message.
+type Spool struct {
+	dir string
+
+	mu          sync.Mutex
+	active      *os.File
+	activeCount int
+	replayCount int
+
+	replayMu sync.Mutex // serialises Replay calls
+}
+
+// SpoolStats describes the current state of the spool
+type SpoolStats struct {
+	Dir       string `json:"dir"`
+	Depth     int    `json:"depth"`
+	Active    int    `json:"active"`
+	Replaying int    `json:"replaying"`
+}
+
+// NewSpool opens (or creates) a spool in the given directory and counts the
+// messages left over from a previous run
+func NewSpool(dir string) (*Spool, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create spool directory: %w", err)
+	}
+
+	s := &Spool{dir: dir}
+
+	var err error
+	if s.activeCount, err = countSegment(s.segmentPath(activeSegmentName)); err != nil {
+		return nil, err
+	}
+	if s.replayCount, err = countSegment(s.segmentPath(replaySegmentName)); err != nil {
+		return nil, err
+	}
+
+	if s.active, err = openSegment(s.segmentPath(activeSegmentName)); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Append durably writes messages to the active segment
+func (s *Spool) Append(messages ...*Message) error {
+	if len(messages) == 0 {
+		return nil
+	}
+
+	var buf []byte
+	for _, message := range messages {
+		line, err := json.Marshal(message)
+		if err != nil {
+			return err
+		}
+		buf = append(buf, line...)
+		buf = append(buf, '\n')
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.active.Write(buf); err != nil {
+		return err
+	}
+	if err := s.active.Sync(); err != nil {
+		return err
+	}
+
+	s.activeCount += len(messages)
+	return nil
+}
+
+// Depth returns the number of messages waiting to be replayed
+func (s *Spool) Depth() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.activeCount + s.replayCount
+}
+
+// Stats returns a snapshot of the spool state
+func (s *Spool) Stats() SpoolStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return SpoolStats{
+		Dir:       s.dir,
+		Depth:     s.activeCount + s.replayCount,
+		Active:    s.activeCount,
+		Replaying: s.replayCount,
+	}
+}
+
+// Replay moves the active segment aside and hands its messages to fn in
+// batches, skipping duplicate IDs. The segment is removed only after every
+// batch succeeded; a failed replay is retried from the start on the next call,
+// relying on the repository to ignore messages that were already stored.
+func (s *Spool) Replay(batchSize int, fn func([]*Message) error) (int, error) {
+	s.replayMu.Lock()
+	defer s.replayMu.Unlock()
+
+	if err := s.rotate(); err != nil {
+		return 0, err
+	}
+
+	replayPath := s.segmentPath(replaySegmentName)
+	file, err := os.Open(replayPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	seen := make(map[string]struct{})
+	batch := make([]*Message, 0, batchSize)
+	replayed := 0
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var message Message
+		if err := json.Unmarshal(scanner.Bytes(), &message); err != nil {
+			// A torn write from a crash can only affect the last line
+			log.Printf("Skipping unreadable spool entry: %v", err)
+			continue
+		}
+		if _, ok := seen[message.ID]; ok {
+			continue
+		}
+		seen[message.ID] = struct{}{}
+
+		batch = append(batch, &message)
+		if len(batch) >= batchSize {
+			if err := fn(batch); err != nil {
+				return replayed, err
+			}
+			replayed += len(batch)
+			batch = make([]*Message, 0, batchSize)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return replayed, err
+	}
+
+	if len(batch) > 0 {
+		if err := fn(batch); err != nil {
+			return replayed, err
+		}
+		replayed += len(batch)
+	}
+
+	file.Close()
+	if err := os.Remove(replayPath); err != nil {
+		return replayed, err
+	}
+
+	s.mu.Lock()
+	s.replayCount = 0
+	s.mu.Unlock()
+
+	return replayed, nil
+}
+
+// Close closes the active segment
+func (s *Spool) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.active.Close()
+}
+
+// rotate turns the active segment into the replay segment unless a previous
+// replay is still pending
+func (s *Spool) rotate() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.replayCount > 0 || s.activeCount == 0 {
+		return nil
+	}
+
+	if err := s.active.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(s.segmentPath(activeSegmentName), s.segmentPath(replaySegmentName)); err != nil {
+		return err
+	}
+
+	active, err := openSegment(s.segmentPath(activeSegmentName))
+	if err != nil {
+		return err
+	}
+
+	s.active = active
+	s.replayCount = s.activeCount
+	s.activeCount = 0
+	return nil
+}
+
+func (s *Spool) segmentPath(name string) string {
+	return filepath.Join(s.dir, name)
+}
+
+// openSegment opens a segment for appending
+func openSegment(path string) (*os.File, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open spool segment: %w", err)
+	}
+	return file, nil
+}
+
+// countSegment counts the entries of a segment, returning zero if it is missing
+func countSegment(path string) (int, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	count := 0
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) > 0 {
+			count++
+		}
+	}
+	return count, scanner.Err()
+}
+
+// SpoolReplayer drains the spool into the message service whenever the
+// database is reachable again
+type SpoolReplayer struct {
+	spool     *Spool
+	service   Service
+	ready     func() bool
+	interval  time.Duration
+	batchSize int
+
+	deadLetters DeadLetterSink
+}
+
+// NewSpoolReplayer creates a replayer. ready reports whether the database is
+// expected to accept writes, typically whether the message breaker is not open.
+func NewSpoolReplayer(spool *Spool, service Service, ready func() bool, interval time.Duration, batchSize int) *SpoolReplayer {
+	if batchSize <= 0 {
+		batchSize = DefaultWriteBehindConfig().BatchSize
+	}
+	return &SpoolReplayer{
+		spool:     spool,
+		service:   service,
+		ready:     ready,
+		interval:  interval,
+		batchSize: batchSize,
+	}
+}
+
+// UseDeadLetters routes spooled messages that fail permanently to the given
+// sink so they cannot block the spool. It must be called before Run.
+func (r *SpoolReplayer) UseDeadLetters(sink DeadLetterSink) {
+	r.deadLetters = sink
+}
+
+// Run replays the spool periodically until the context is cancelled
+func (r *SpoolReplayer) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if r.spool.Depth() == 0 || (r.ready != nil && !r.ready()) {
+				continue
+			}
+
+			replayed, err := r.spool.Replay(r.batchSize, func(batch []*Message) error {
+				err := r.service.SaveMessages(ctx, batch)
+				if err == nil {
+					return nil
+				}
+				remaining, err := isolatePermanentFailures(ctx, r.service, r.deadLetters, batch, err)
+				if len(remaining) > 0 {
+					return err
+				}
+				return nil
+			})
+			if err != nil {
+				log.Printf("Spool replay stopped after %d messages: %v", replayed, err)
+			} else if replayed > 0 {
+				log.Printf("Replayed %d spooled messages", replayed)
+			}
+		}
+	}
+}
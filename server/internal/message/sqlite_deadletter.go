@@ -0,0 +1,133 @@
+package message
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// SQLiteDeadLetterRepository implements DeadLetterRepository using SQLite
+type SQLiteDeadLetterRepository struct {
+	db *sql.DB
+}
+
+// NewSQLiteDeadLetterRepository creates a new SQLite dead letter repository
+func NewSQLiteDeadLetterRepository(db *sql.DB) *SQLiteDeadLetterRepository {
+	return &SQLiteDeadLetterRepository{
+		db: db,
+	}
+}
+
+// RecordDeadLetter stores a failed message, bumping the attempt count if it was
+// already dead-lettered
+func (r *SQLiteDeadLetterRepository) RecordDeadLetter(ctx context.Context, message *Message, cause string) error {
+	query := `
+		INSERT INTO dead_letters (message_id, room_id, user_id, username, content, type, timestamp, recipient, recipient_id, error, attempts, first_failed_at, last_failed_at)
+		VALUES (?1, ?2, ?3, ?4, ?5, ?6, ?7, ?8, ?9, ?10, 1, ?11, ?11)
+		ON CONFLICT (message_id) DO UPDATE
+		SET error = excluded.error, attempts = dead_letters.attempts + 1, last_failed_at = excluded.last_failed_at
+	`
+
+	_, err := r.db.ExecContext(
+		ctx,
+		query,
+		message.ID,
+		message.RoomID,
+		message.UserID,
+		message.Username,
+		message.Content,
+		message.Type,
+		message.Timestamp.UTC(),
+		message.Recipient,
+		message.RecipientID,
+		cause,
+		time.Now().UTC(),
+	)
+
+	return err
+}
+
+// ListDeadLetters retrieves dead letters, most recently failed first
+func (r *SQLiteDeadLetterRepository) ListDeadLetters(ctx context.Context, limit, offset int) ([]*DeadLetter, error) {
+	query := `
+		SELECT message_id, room_id, user_id, username, content, type, timestamp, recipient, recipient_id, error, attempts, first_failed_at, last_failed_at
+		FROM dead_letters
+		ORDER BY last_failed_at DESC
+		LIMIT ? OFFSET ?
+	`
+
+	rows, err := r.db.QueryContext(ctx, query, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deadLetters []*DeadLetter
+	for rows.Next() {
+		deadLetter, err := scanDeadLetter(rows)
+		if err != nil {
+			return nil, err
+		}
+		deadLetters = append(deadLetters, deadLetter)
+	}
+
+	return deadLetters, rows.Err()
+}
+
+// GetDeadLetter retrieves a dead letter by its message ID
+func (r *SQLiteDeadLetterRepository) GetDeadLetter(ctx context.Context, messageID string) (*DeadLetter, error) {
+	query := `
+		SELECT message_id, room_id, user_id, username, content, type, timestamp, recipient, recipient_id, error, attempts, first_failed_at, last_failed_at
+		FROM dead_letters
+		WHERE message_id = ?
+	`
+
+	deadLetter, err := scanDeadLetter(r.db.QueryRowContext(ctx, query, messageID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrDeadLetterNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return deadLetter, nil
+}
+
+// UpdateDeadLetter replaces the stored message of a dead letter
+func (r *SQLiteDeadLetterRepository) UpdateDeadLetter(ctx context.Context, message *Message) error {
+	query := `
+		UPDATE dead_letters
+		SET room_id = ?2, user_id = ?3, username = ?4, content = ?5, type = ?6, timestamp = ?7, recipient = ?8, recipient_id = ?9
+		WHERE message_id = ?1
+	`
+
+	result, err := r.db.ExecContext(
+		ctx,
+		query,
+		message.ID,
+		message.RoomID,
+		message.UserID,
+		message.Username,
+		message.Content,
+		message.Type,
+		message.Timestamp.UTC(),
+		message.Recipient,
+		message.RecipientID,
+	)
+	if err != nil {
+		return err
+	}
+
+	return requireAffected(result)
+}
+
+// DeleteDeadLetter removes a dead letter
+func (r *SQLiteDeadLetterRepository) DeleteDeadLetter(ctx context.Context, messageID string) error {
+	result, err := r.db.ExecContext(ctx, `DELETE FROM dead_letters WHERE message_id = ?`, messageID)
+	if err != nil {
+		return err
+	}
+
+	return requireAffected(result)
+}
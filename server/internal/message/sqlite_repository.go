@@ -0,0 +1,334 @@
+package message
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+// SQLiteRepository implements the Repository interface using SQLite. Times are
+// stored in UTC so that ordering by their text form matches ordering by time.
+type SQLiteRepository struct {
+	db *sql.DB
+}
+
+// NewSQLiteRepository creates a new SQLite repository
+func NewSQLiteRepository(db *sql.DB) *SQLiteRepository {
+	return &SQLiteRepository{
+		db: db,
+	}
+}
+
+const sqliteInsertMessage = `
+	INSERT INTO messages (id, room_id, user_id, username, content, type, timestamp, recipient, recipient_id)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	ON CONFLICT (id) DO NOTHING
+`
+
+// SaveMessage stores a message in the database
+func (r *SQLiteRepository) SaveMessage(ctx context.Context, message *Message) error {
+	_, err := r.db.ExecContext(ctx, sqliteInsertMessage, messageArgs(message)...)
+	return err
+}
+
+// SaveMessages stores a batch of messages inside a single transaction,
+// preserving the order of the slice
+func (r *SQLiteRepository) SaveMessages(ctx context.Context, messages []*Message) error {
+	if len(messages) == 0 {
+		return nil
+	}
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, sqliteInsertMessage)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, message := range messages {
+		if _, err := stmt.ExecContext(ctx, messageArgs(message)...); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// messageArgs returns the columns of a message in insert order
+func messageArgs(message *Message) []interface{} {
+	return []interface{}{
+		message.ID,
+		message.RoomID,
+		message.UserID,
+		message.Username,
+		message.Content,
+		message.Type,
+		message.Timestamp.UTC(),
+		message.Recipient,
+		message.RecipientID,
+	}
+}
+
+// GetMessagesByRoom retrieves messages for a specific room with pagination
+func (r *SQLiteRepository) GetMessagesByRoom(ctx context.Context, roomID string, limit, offset int) ([]*Message, error) {
+	query := `
+		SELECT id, room_id, user_id, username, content, type, timestamp, recipient, recipient_id
+		FROM messages
+		WHERE room_id = ?
+		ORDER BY timestamp DESC, id DESC
+		LIMIT ? OFFSET ?
+	`
+
+	rows, err := r.db.QueryContext(ctx, query, roomID, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []*Message
+	for rows.Next() {
+		msg, err := scanMessage(rows)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, msg)
+	}
+
+	return messages, rows.Err()
+}
+
+// GetMessagesByRoomCursor retrieves the messages next to a cursor, newest
+// first
+func (r *SQLiteRepository) GetMessagesByRoomCursor(ctx context.Context, roomID string, query HistoryQuery) ([]*Message, error) {
+	condition, order := "", "DESC"
+	args := []interface{}{roomID, query.Limit}
+	switch {
+	case query.Before != nil:
+		condition = "AND (timestamp, id) < (?3, ?4)"
+		args = append(args, query.Before.Timestamp.UTC(), query.Before.ID)
+	case query.After != nil:
+		condition, order = "AND (timestamp, id) > (?3, ?4)", "ASC"
+		args = append(args, query.After.Timestamp.UTC(), query.After.ID)
+	}
+
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT id, room_id, user_id, username, content, type, timestamp, recipient, recipient_id
+		FROM messages
+		WHERE room_id = ?1 `+condition+`
+		ORDER BY timestamp `+order+`, id `+order+`
+		LIMIT ?2
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	messages := []*Message{}
+	for rows.Next() {
+		msg, err := scanMessage(rows)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, msg)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if query.After != nil {
+		reverseMessages(messages)
+	}
+	return messages, nil
+}
+
+// GetMessageByID retrieves a specific message by its ID
+func (r *SQLiteRepository) GetMessageByID(ctx context.Context, id string) (*Message, error) {
+	query := `
+		SELECT id, room_id, user_id, username, content, type, timestamp, recipient, recipient_id
+		FROM messages
+		WHERE id = ?
+	`
+
+	msg, err := scanMessage(r.db.QueryRowContext(ctx, query, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrMessageNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return msg, nil
+}
+
+// SearchMessages runs a full-text search over the messages_fts index. Every
+// search term has to match; results are ranked with the search_rank function
+// the SQLite driver registers.
+func (r *SQLiteRepository) SearchMessages(ctx context.Context, query SearchQuery) ([]*SearchResult, error) {
+	terms := searchTerms(query.Text)
+	if len(terms) == 0 {
+		return []*SearchResult{}, nil
+	}
+	for i, term := range terms {
+		terms[i] = `"` + term + `"`
+	}
+
+	var args []interface{}
+	bind := func(v interface{}) string {
+		if t, ok := v.(time.Time); ok {
+			v = t.UTC()
+		}
+		args = append(args, v)
+		return "?"
+	}
+
+	sql := `
+		SELECT id, room_id, user_id, username, content, type, timestamp, recipient, recipient_id, rank, snippet
+		FROM (
+			SELECT m.id, m.room_id, m.user_id, m.username, m.content, m.type, m.timestamp, m.recipient, m.recipient_id,
+				search_rank(matchinfo(messages_fts, 'pcx')) AS rank,
+				snippet(messages_fts, ` + bind(highlightStart) + `, ` + bind(highlightStop) + `, '…', -1, 24) AS snippet
+			FROM messages_fts
+			JOIN messages m ON m.rowid = messages_fts.docid
+			WHERE messages_fts MATCH ` + bind(strings.Join(terms, " ")) + searchConditions(query, bind) + `
+		)`
+	if query.After != nil {
+		sql += ` WHERE (rank, timestamp, id) < (` + bind(query.After.Rank) + `, ` + bind(query.After.Timestamp) + `, ` + bind(query.After.ID) + `)`
+	}
+	sql += ` ORDER BY rank DESC, timestamp DESC, id DESC LIMIT ` + bind(query.Limit)
+
+	rows, err := r.db.QueryContext(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []*SearchResult{}
+	for rows.Next() {
+		result, err := scanSearchResult(rows)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, result)
+	}
+
+	return results, rows.Err()
+}
+
+// CreateRoom creates a new chat room
+func (r *SQLiteRepository) CreateRoom(ctx context.Context, room *Room) error {
+	query := `
+		INSERT INTO rooms (id, name, owner_id, created, last_activity, max_members, allow_spectators)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`
+
+	_, err := r.db.ExecContext(
+		ctx,
+		query,
+		room.ID,
+		room.Name,
+		room.OwnerID,
+		room.Created.UTC(),
+		room.LastActivity.UTC(),
+		room.MaxMembers,
+		room.AllowSpectators,
+	)
+
+	if isUniqueViolation(err) {
+		return ErrRoomExists.Wrap(err)
+	}
+	return err
+}
+
+// GetRooms retrieves all available chat rooms
+func (r *SQLiteRepository) GetRooms(ctx context.Context) ([]*Room, error) {
+	query := `
+		SELECT id, name, owner_id, created, last_activity, max_members, allow_spectators
+		FROM rooms
+		ORDER BY last_activity DESC
+	`
+
+	rows, err := r.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rooms []*Room
+	for rows.Next() {
+		room, err := scanRoom(rows)
+		if err != nil {
+			return nil, err
+		}
+		rooms = append(rooms, room)
+	}
+
+	return rooms, rows.Err()
+}
+
+// GetRoomByID retrieves a room by its ID
+func (r *SQLiteRepository) GetRoomByID(ctx context.Context, id string) (*Room, error) {
+	query := `
+		SELECT id, name, owner_id, created, last_activity, max_members, allow_spectators
+		FROM rooms
+		WHERE id = ?
+	`
+
+	room, err := scanRoom(r.db.QueryRowContext(ctx, query, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrRoomNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return room, nil
+}
+
+// UpdateRoomActivity updates the last_activity timestamp for a room
+func (r *SQLiteRepository) UpdateRoomActivity(ctx context.Context, roomID string) error {
+	_, err := r.db.ExecContext(ctx, `UPDATE rooms SET last_activity = ? WHERE id = ?`, time.Now().UTC(), roomID)
+	return err
+}
+
+func scanMessage(row rowScanner) (*Message, error) {
+	msg := &Message{}
+	err := row.Scan(
+		&msg.ID,
+		&msg.RoomID,
+		&msg.UserID,
+		&msg.Username,
+		&msg.Content,
+		&msg.Type,
+		&msg.Timestamp,
+		&msg.Recipient,
+		&msg.RecipientID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+func scanRoom(row rowScanner) (*Room, error) {
+	room := &Room{}
+	err := row.Scan(
+		&room.ID,
+		&room.Name,
+		&room.OwnerID,
+		&room.Created,
+		&room.LastActivity,
+		&room.MaxMembers,
+		&room.AllowSpectators,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return room, nil
+}
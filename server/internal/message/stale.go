@@ -0,0 +1,31 @@
+package message
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// StaleHeader is set on responses served from the cache while the database
+// circuit breaker is open
+const StaleHeader = "X-Stale-Data"
+
+type staleKey struct{}
+
+// WithStaleTracking returns a context that records whether a read made with it
+// was answered from possibly outdated cached data
+func WithStaleTracking(ctx context.Context) context.Context {
+	return context.WithValue(ctx, staleKey{}, new(atomic.Bool))
+}
+
+// IsStale reports whether a read made with ctx was served stale
+func IsStale(ctx context.Context) bool {
+	flag, ok := ctx.Value(staleKey{}).(*atomic.Bool)
+	return ok && flag.Load()
+}
+
+// markStale flags a read made with ctx as served stale
+func markStale(ctx context.Context) {
+	if flag, ok := ctx.Value(staleKey{}).(*atomic.Bool); ok {
+		flag.Store(true)
+	}
+}
@@ -0,0 +1,239 @@
+package message
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// cacheInvalidationChannel is the Redis pub/sub channel carrying L1 evictions
+	cacheInvalidationChannel = "cache:invalidations"
+
+	// DefaultL1TTL bounds how long an L1 entry can stay stale if an
+	// invalidation is missed, for example during a Redis reconnect
+	DefaultL1TTL = 30 * time.Second
+)
+
+// cacheInvalidation is published whenever a replica writes an L1-tier key
+type cacheInvalidation struct {
+	Origin string   `json:"origin"`
+	Keys   []string `json:"keys"`
+}
+
+// TierStats holds hit and miss counters for one cache tier
+type TierStats struct {
+	Hits   uint64 `json:"hits"`
+	Misses uint64 `json:"misses"`
+}
+
+// TieredCacheStats holds hit and miss counters for both cache tiers
+type TieredCacheStats struct {
+	L1 TierStats `json:"l1"`
+	L2 TierStats `json:"l2"`
+}
+
+// TieredCache implements the Cache interface with an in-process L1 in front of
+// Redis. Rooms, the room list and single messages are served from L1; room
+// windows and sessions go straight to Redis. Every write to an L1-tier key is
+// announced over Redis pub/sub so other replicas evict their stale copies.
+type TieredCache struct {
+	l1       *MemoryCache
+	l2       *RedisCache
+	instance string
+
+	l1Hits, l1Misses uint64
+	l2Hits, l2Misses uint64
+}
+
+// NewTieredCache creates a two-tier cache. Call Start to receive invalidations
+// from other replicas.
+func NewTieredCache(l1 *MemoryCache, l2 *RedisCache) *TieredCache {
+	return &TieredCache{
+		l1:       l1,
+		l2:       l2,
+		instance: uuid.New().String(),
+	}
+}
+
+// Start subscribes to invalidations from other replicas and evicts their keys
+// from L1 until the context is cancelled. It returns once the subscription is
+// confirmed.
+func (c *TieredCache) Start(ctx context.Context) error {
+	pubsub := c.l2.client.Subscribe(ctx, cacheInvalidationChannel)
+	if _, err := pubsub.Receive(ctx); err != nil {
+		pubsub.Close()
+		return fmt.Errorf("failed to subscribe to cache invalidations: %w", err)
+	}
+
+	go func() {
+		defer pubsub.Close()
+
+		messages := pubsub.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case msg, ok := <-messages:
+				if !ok {
+					return
+				}
+
+				var invalidation cacheInvalidation
+				if err := json.Unmarshal([]byte(msg.Payload), &invalidation); err != nil {
+					log.Printf("Ignoring malformed cache invalidation: %v", err)
+					continue
+				}
+				if invalidation.Origin != c.instance {
+					c.l1.Invalidate(invalidation.Keys...)
+				}
+			}
+		}
+	}()
+
+	return nil
+}
+
+// Stats returns the hit and miss counters of both tiers
+func (c *TieredCache) Stats() TieredCacheStats {
+	return TieredCacheStats{
+		L1: TierStats{Hits: atomic.LoadUint64(&c.l1Hits), Misses: atomic.LoadUint64(&c.l1Misses)},
+		L2: TierStats{Hits: atomic.LoadUint64(&c.l2Hits), Misses: atomic.LoadUint64(&c.l2Misses)},
+	}
+}
+
+// publishInvalidation tells other replicas to evict the given keys from L1
+func (c *TieredCache) publishInvalidation(ctx context.Context, keys ...string) error {
+	payload, err := json.Marshal(cacheInvalidation{Origin: c.instance, Keys: keys})
+	if err != nil {
+		return err
+	}
+	return c.l2.client.Publish(ctx, cacheInvalidationChannel, payload).Err()
+}
+
+// record counts a lookup result for a tier
+func record(err error, hits, misses *uint64) {
+	if err == nil {
+		atomic.AddUint64(hits, 1)
+	} else {
+		atomic.AddUint64(misses, 1)
+	}
+}
+
+// CacheMessage writes a message to both tiers and invalidates other replicas
+func (c *TieredCache) CacheMessage(ctx context.Context, message *Message) error {
+	if err := c.l2.CacheMessage(ctx, message); err != nil {
+		return err
+	}
+	c.l1.CacheMessage(ctx, message)
+	return c.publishInvalidation(ctx, fmt.Sprintf("%s%s", messageKeyPrefix, message.ID))
+}
+
+// GetCachedMessage reads a message from L1, falling back to Redis
+func (c *TieredCache) GetCachedMessage(ctx context.Context, id string) (*Message, error) {
+	message, err := c.l1.GetCachedMessage(ctx, id)
+	record(err, &c.l1Hits, &c.l1Misses)
+	if err == nil {
+		return message, nil
+	}
+
+	message, err = c.l2.GetCachedMessage(ctx, id)
+	record(err, &c.l2Hits, &c.l2Misses)
+	if err != nil {
+		return nil, err
+	}
+
+	c.l1.CacheMessage(ctx, message)
+	return message, nil
+}
+
+// CacheRoomWindow is served by Redis only
+func (c *TieredCache) CacheRoomWindow(ctx context.Context, roomID string, messages []*Message, complete bool) error {
+	return c.l2.CacheRoomWindow(ctx, roomID, messages, complete)
+}
+
+// AppendRoomMessage is served by Redis only
+func (c *TieredCache) AppendRoomMessage(ctx context.Context, message *Message) error {
+	return c.l2.AppendRoomMessage(ctx, message)
+}
+
+// GetRoomWindow is served by Redis only
+func (c *TieredCache) GetRoomWindow(ctx context.Context, roomID string, limit, offset int) ([]*Message, bool, error) {
+	messages, covered, err := c.l2.GetRoomWindow(ctx, roomID, limit, offset)
+	record(err, &c.l2Hits, &c.l2Misses)
+	return messages, covered, err
+}
+
+// CacheRoom writes a room to both tiers and invalidates other replicas
+func (c *TieredCache) CacheRoom(ctx context.Context, room *Room) error {
+	if err := c.l2.CacheRoom(ctx, room); err != nil {
+		return err
+	}
+	c.l1.CacheRoom(ctx, room)
+	return c.publishInvalidation(ctx, fmt.Sprintf("%s%s", roomKeyPrefix, room.ID))
+}
+
+// GetCachedRoom reads a room from L1, falling back to Redis
+func (c *TieredCache) GetCachedRoom(ctx context.Context, id string) (*Room, error) {
+	room, err := c.l1.GetCachedRoom(ctx, id)
+	record(err, &c.l1Hits, &c.l1Misses)
+	if err == nil {
+		return room, nil
+	}
+
+	room, err = c.l2.GetCachedRoom(ctx, id)
+	record(err, &c.l2Hits, &c.l2Misses)
+	if err != nil {
+		return nil, err
+	}
+
+	c.l1.CacheRoom(ctx, room)
+	return room, nil
+}
+
+// CacheRoomList writes the room list to both tiers and invalidates other replicas
+func (c *TieredCache) CacheRoomList(ctx context.Context, rooms []*Room) error {
+	if err := c.l2.CacheRoomList(ctx, rooms); err != nil {
+		return err
+	}
+	c.l1.CacheRoomList(ctx, rooms)
+	return c.publishInvalidation(ctx, roomListKey)
+}
+
+// GetCachedRoomList reads the room list from L1, falling back to Redis
+func (c *TieredCache) GetCachedRoomList(ctx context.Context) ([]*Room, error) {
+	rooms, err := c.l1.GetCachedRoomList(ctx)
+	record(err, &c.l1Hits, &c.l1Misses)
+	if err == nil {
+		return rooms, nil
+	}
+
+	rooms, err = c.l2.GetCachedRoomList(ctx)
+	record(err, &c.l2Hits, &c.l2Misses)
+	if err != nil {
+		return nil, err
+	}
+
+	c.l1.CacheRoomList(ctx, rooms)
+	return rooms, nil
+}
+
+// SetSession is served by Redis only
+func (c *TieredCache) SetSession(ctx context.Context, userID, sessionData string, expiration time.Duration) error {
+	return c.l2.SetSession(ctx, userID, sessionData, expiration)
+}
+
+// GetSession is served by Redis only
+func (c *TieredCache) GetSession(ctx context.Context, userID string) (string, error) {
+	return c.l2.GetSession(ctx, userID)
+}
+
+// DeleteSession is served by Redis only
+func (c *TieredCache) DeleteSession(ctx context.Context, userID string) error {
+	return c.l2.DeleteSession(ctx, userID)
+}
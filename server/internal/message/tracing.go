@@ -0,0 +1,118 @@
+package message
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"server/internal/tracing"
+)
+
+var tracer = otel.Tracer("server/internal/message")
+
+// TracedService wraps a Service and records a span for every call, which the
+// repository's SQL and Redis spans nest under
+type TracedService struct {
+	service Service
+}
+
+// NewTracedService creates a service that traces the calls to service
+func NewTracedService(service Service) *TracedService {
+	return &TracedService{service: service}
+}
+
+func startServiceSpan(ctx context.Context, method string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return tracer.Start(ctx, "message.Service/"+method, trace.WithAttributes(attrs...))
+}
+
+func (s *TracedService) SaveMessage(ctx context.Context, message *Message) (err error) {
+	ctx, span := startServiceSpan(ctx, "SaveMessage", attribute.String("chat.room_id", message.RoomID))
+	defer func() { tracing.End(span, err) }()
+	return s.service.SaveMessage(ctx, message)
+}
+
+func (s *TracedService) SaveMessages(ctx context.Context, messages []*Message) (err error) {
+	ctx, span := startServiceSpan(ctx, "SaveMessages", attribute.Int("chat.batch_size", len(messages)))
+	defer func() { tracing.End(span, err) }()
+	return s.service.SaveMessages(ctx, messages)
+}
+
+func (s *TracedService) GetMessagesByRoom(ctx context.Context, roomID string, limit, offset int) (messages []*Message, err error) {
+	ctx, span := startServiceSpan(ctx, "GetMessagesByRoom", attribute.String("chat.room_id", roomID))
+	defer func() { tracing.End(span, err) }()
+	return s.service.GetMessagesByRoom(ctx, roomID, limit, offset)
+}
+
+func (s *TracedService) GetRoomHistory(ctx context.Context, roomID string, query HistoryQuery) (page *HistoryPage, err error) {
+	ctx, span := startServiceSpan(ctx, "GetRoomHistory", attribute.String("chat.room_id", roomID))
+	defer func() { tracing.End(span, err) }()
+	return s.service.GetRoomHistory(ctx, roomID, query)
+}
+
+func (s *TracedService) GetMessageByID(ctx context.Context, id string) (message *Message, err error) {
+	ctx, span := startServiceSpan(ctx, "GetMessageByID", attribute.String("chat.message_id", id))
+	defer func() { tracing.End(span, err) }()
+	return s.service.GetMessageByID(ctx, id)
+}
+
+func (s *TracedService) SearchMessages(ctx context.Context, query SearchQuery) (page *SearchPage, err error) {
+	ctx, span := startServiceSpan(ctx, "SearchMessages")
+	defer func() { tracing.End(span, err) }()
+	return s.service.SearchMessages(ctx, query)
+}
+
+func (s *TracedService) CreateRoom(ctx context.Context, id, name, ownerID string, capacity RoomCapacity) (room *Room, err error) {
+	ctx, span := startServiceSpan(ctx, "CreateRoom", attribute.String("chat.room_id", id))
+	defer func() { tracing.End(span, err) }()
+	return s.service.CreateRoom(ctx, id, name, ownerID, capacity)
+}
+
+func (s *TracedService) GetRooms(ctx context.Context) (rooms []*Room, err error) {
+	ctx, span := startServiceSpan(ctx, "GetRooms")
+	defer func() { tracing.End(span, err) }()
+	return s.service.GetRooms(ctx)
+}
+
+func (s *TracedService) GetRoomByID(ctx context.Context, id string) (room *Room, err error) {
+	ctx, span := startServiceSpan(ctx, "GetRoomByID", attribute.String("chat.room_id", id))
+	defer func() { tracing.End(span, err) }()
+	return s.service.GetRoomByID(ctx, id)
+}
+
+func (s *TracedService) UpdateRoomActivity(ctx context.Context, roomID string) (err error) {
+	ctx, span := startServiceSpan(ctx, "UpdateRoomActivity", attribute.String("chat.room_id", roomID))
+	defer func() { tracing.End(span, err) }()
+	return s.service.UpdateRoomActivity(ctx, roomID)
+}
+
+func (s *TracedService) SetUserSession(ctx context.Context, userID, sessionData string, expiration time.Duration) (err error) {
+	ctx, span := startServiceSpan(ctx, "SetUserSession")
+	defer func() { tracing.End(span, err) }()
+	return s.service.SetUserSession(ctx, userID, sessionData, expiration)
+}
+
+func (s *TracedService) GetUserSession(ctx context.Context, userID string) (session string, err error) {
+	ctx, span := startServiceSpan(ctx, "GetUserSession")
+	defer func() { tracing.End(span, err) }()
+	return s.service.GetUserSession(ctx, userID)
+}
+
+func (s *TracedService) DeleteUserSession(ctx context.Context, userID string) (err error) {
+	ctx, span := startServiceSpan(ctx, "DeleteUserSession")
+	defer func() { tracing.End(span, err) }()
+	return s.service.DeleteUserSession(ctx, userID)
+}
+
+// messageLinks links a span to the spans the messages were received in
+func messageLinks(messages []*Message) []trace.Link {
+	var links []trace.Link
+	for _, message := range messages {
+		if message.Trace.IsValid() {
+			links = append(links, trace.Link{SpanContext: message.Trace})
+		}
+	}
+	return links
+}
@@ -0,0 +1,283 @@
+package message
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ErrWriteQueueFull is returned by WriteBehind.Enqueue when the persistence
+// queue cannot accept more messages
+var ErrWriteQueueFull = errors.New("message write queue is full")
+
+// ErrWriteBehindClosed is returned by WriteBehind.Enqueue after Close
+var ErrWriteBehindClosed = errors.New("message write-behind is closed")
+
+// WriteBehindConfig holds configuration for asynchronous message persistence
+type WriteBehindConfig struct {
+	QueueSize     int           // Maximum number of messages waiting to be persisted
+	BatchSize     int           // Flush once this many messages are pending
+	FlushInterval time.Duration // Flush pending messages at least this often
+	FlushTimeout  time.Duration // Upper bound for a single flush, including retries
+	HighWatermark float64       // Queue fill ratio at which backpressure is signalled
+	LowWatermark  float64       // Queue fill ratio at which backpressure is released
+}
+
+// DefaultWriteBehindConfig returns sensible defaults for a single server
+func DefaultWriteBehindConfig() WriteBehindConfig {
+	return WriteBehindConfig{
+		QueueSize:     4096,
+		BatchSize:     200,
+		FlushInterval: 250 * time.Millisecond,
+		FlushTimeout:  2 * time.Minute,
+		HighWatermark: 0.8,
+		LowWatermark:  0.5,
+	}
+}
+
+// BackpressureFunc is called whenever the write-behind queue crosses a watermark.
+// saturated is true when the queue reached the high watermark and false once it
+// drained below the low watermark again.
+type BackpressureFunc func(saturated bool)
+
+// WriteBehind persists messages asynchronously through a bounded queue. Messages
+// are flushed in batches by a single worker in the order they were enqueued, so
+// messages within a room are never reordered.
+type WriteBehind struct {
+	service        Service
+	config         WriteBehindConfig
+	queue          chan *Message
+	onBackpressure BackpressureFunc
+	spool          *Spool
+	deadLetters    DeadLetterSink
+
+	mu     sync.RWMutex // guards closed against concurrent Enqueue and Close
+	closed bool
+
+	stateMu   sync.Mutex
+	saturated bool
+
+	// overloadedUntil is the unix time in nanoseconds until which Enqueue
+	// rejects messages because the last flush was turned away by a full
+	// bulkhead
+	overloadedUntil atomic.Int64
+
+	done chan struct{}
+}
+
+// NewWriteBehind creates a write-behind stage in front of the given service
+func NewWriteBehind(service Service, config WriteBehindConfig) *WriteBehind {
+	defaults := DefaultWriteBehindConfig()
+	if config.QueueSize <= 0 {
+		config.QueueSize = defaults.QueueSize
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = defaults.BatchSize
+	}
+	if config.FlushInterval <= 0 {
+		config.FlushInterval = defaults.FlushInterval
+	}
+	if config.FlushTimeout <= 0 {
+		config.FlushTimeout = defaults.FlushTimeout
+	}
+	if config.HighWatermark <= 0 || config.HighWatermark > 1 {
+		config.HighWatermark = defaults.HighWatermark
+	}
+	if config.LowWatermark <= 0 || config.LowWatermark >= config.HighWatermark {
+		config.LowWatermark = config.HighWatermark / 2
+	}
+
+	return &WriteBehind{
+		service: service,
+		config:  config,
+		queue:   make(chan *Message, config.QueueSize),
+		done:    make(chan struct{}),
+	}
+}
+
+// OnBackpressure registers a callback for queue saturation changes. It must be
+// called before Run.
+func (w *WriteBehind) OnBackpressure(fn BackpressureFunc) {
+	w.onBackpressure = fn
+}
+
+// UseSpool makes failed flushes fall back to the given on-disk spool instead of
+// dropping the batch. It must be called before Run.
+func (w *WriteBehind) UseSpool(spool *Spool) {
+	w.spool = spool
+}
+
+// UseDeadLetters routes messages that fail permanently, such as constraint
+// violations, to the given sink instead of retrying them. It must be called
+// before Run.
+func (w *WriteBehind) UseDeadLetters(sink DeadLetterSink) {
+	w.deadLetters = sink
+}
+
+// Enqueue schedules a message for persistence without blocking. The message ID
+// and timestamp are assigned here so callers can use them before the flush.
+// It returns ErrOverloaded for a flush interval after a flush was rejected by
+// a full bulkhead, so senders learn that persistence is overloaded instead of
+// their messages piling up in the spool.
+func (w *WriteBehind) Enqueue(message *Message) error {
+	if time.Now().UnixNano() < w.overloadedUntil.Load() {
+		return ErrOverloaded
+	}
+
+	if message.ID == "" {
+		message.ID = uuid.New().String()
+	}
+	if message.Timestamp.IsZero() {
+		message.Timestamp = time.Now()
+	}
+
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	if w.closed {
+		return ErrWriteBehindClosed
+	}
+
+	select {
+	case w.queue <- message:
+		w.checkWatermarks()
+		return nil
+	default:
+		w.checkWatermarks()
+		return ErrWriteQueueFull
+	}
+}
+
+// Depth returns the number of messages waiting to be persisted
+func (w *WriteBehind) Depth() int {
+	return len(w.queue)
+}
+
+// Capacity returns the maximum number of messages the queue can hold
+func (w *WriteBehind) Capacity() int {
+	return cap(w.queue)
+}
+
+// Saturated reports whether the queue is currently above its high watermark
+func (w *WriteBehind) Saturated() bool {
+	w.stateMu.Lock()
+	defer w.stateMu.Unlock()
+	return w.saturated
+}
+
+// Run consumes the queue until Close is called, flushing whenever a batch is
+// full or the flush interval elapses
+func (w *WriteBehind) Run() {
+	defer close(w.done)
+
+	ticker := time.NewTicker(w.config.FlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]*Message, 0, w.config.BatchSize)
+	for {
+		select {
+		case message, ok := <-w.queue:
+			if !ok {
+				w.flush(batch)
+				return
+			}
+			batch = append(batch, message)
+			if len(batch) >= w.config.BatchSize {
+				w.flush(batch)
+				batch = make([]*Message, 0, w.config.BatchSize)
+			}
+
+		case <-ticker.C:
+			if len(batch) > 0 {
+				w.flush(batch)
+				batch = make([]*Message, 0, w.config.BatchSize)
+			}
+		}
+	}
+}
+
+// Close stops accepting messages and waits for the queue to be flushed
+func (w *WriteBehind) Close() {
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return
+	}
+	w.closed = true
+	close(w.queue)
+	w.mu.Unlock()
+
+	<-w.done
+}
+
+// flush persists a batch and re-evaluates backpressure afterwards
+func (w *WriteBehind) flush(batch []*Message) {
+	if len(batch) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), w.config.FlushTimeout)
+	defer cancel()
+
+	// The batch mixes messages of many traces, so the flush starts a trace of
+	// its own and links to the span each message was received in
+	ctx, span := tracer.Start(ctx, "message.WriteBehind/flush",
+		trace.WithNewRoot(),
+		trace.WithLinks(messageLinks(batch)...),
+		trace.WithAttributes(attribute.Int("chat.batch_size", len(batch))),
+	)
+	defer span.End()
+
+	err := w.service.SaveMessages(ctx, batch)
+	if err != nil {
+		batch, err = isolatePermanentFailures(ctx, w.service, w.deadLetters, batch, err)
+	}
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	if errors.Is(err, ErrOverloaded) {
+		w.overloadedUntil.Store(time.Now().Add(w.config.FlushInterval).UnixNano())
+	}
+
+	if err != nil && len(batch) > 0 {
+		if w.spool == nil {
+			log.Printf("Error persisting batch of %d messages: %v", len(batch), err)
+		} else if spoolErr := w.spool.Append(batch...); spoolErr != nil {
+			log.Printf("Error persisting batch of %d messages: %v (spool failed: %v)", len(batch), err, spoolErr)
+		} else {
+			log.Printf("Spooled batch of %d messages after persistence failure: %v", len(batch), err)
+		}
+	}
+
+	w.checkWatermarks()
+}
+
+// checkWatermarks signals saturation changes based on the current queue depth.
+// The callback runs under stateMu so transitions are delivered in order; it
+// must not block.
+func (w *WriteBehind) checkWatermarks() {
+	fill := float64(len(w.queue)) / float64(cap(w.queue))
+
+	w.stateMu.Lock()
+	defer w.stateMu.Unlock()
+
+	changed := false
+	switch {
+	case !w.saturated && fill >= w.config.HighWatermark:
+		w.saturated, changed = true, true
+	case w.saturated && fill <= w.config.LowWatermark:
+		w.saturated, changed = false, true
+	}
+
+	if changed && w.onBackpressure != nil {
+		w.onBackpressure(w.saturated)
+	}
+}
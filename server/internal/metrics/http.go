@@ -0,0 +1,31 @@
+package metrics
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Middleware counts HTTP requests by method, route and status and observes
+// their latency. Routes are labelled by their pattern, such as
+// /api/messages/room/:roomId, so IDs do not inflate the label set.
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		websocket := c.IsWebsocket()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		method := c.Request.Method
+
+		HTTPRequests.WithLabelValues(method, route, strconv.Itoa(c.Writer.Status())).Inc()
+		// A WebSocket request lasts as long as its connection
+		if !websocket {
+			HTTPDuration.WithLabelValues(method, route).Observe(time.Since(start).Seconds())
+		}
+	}
+}
@@ -0,0 +1,160 @@
+// Package metrics defines the Prometheus metrics of the server and the
+// registry the /metrics endpoint serves.
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/sony/gobreaker"
+)
+
+const namespace = "chat"
+
+// Registry holds every metric of the server along with the Go runtime and
+// process collectors
+var Registry = prometheus.NewRegistry()
+
+var factory = promauto.With(Registry)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
+
+// Hub metrics
+var (
+	HubClients = factory.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "hub",
+		Name:      "clients",
+		Help:      "WebSocket clients connected to a room.",
+	})
+	HubRooms = factory.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "hub",
+		Name:      "rooms",
+		Help:      "Rooms held by the hub.",
+	})
+	BroadcastFanout = factory.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "hub",
+		Name:      "broadcast_fanout_seconds",
+		Help:      "Time to hand a broadcast to every client of its room.",
+		Buckets:   []float64{.00001, .00005, .0001, .0005, .001, .005, .01, .05, .1, .5, 1},
+	})
+)
+
+// Persistence metrics
+var (
+	MessagesSaved = factory.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "messages_saved_total",
+		Help:      "Messages written to the repository.",
+	})
+	RepositoryDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "repository",
+		Name:      "duration_seconds",
+		Help:      "Latency of message repository calls.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"operation", "result"})
+	RedisDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "redis",
+		Name:      "duration_seconds",
+		Help:      "Latency of Redis commands and pipelines.",
+		Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+	}, []string{"command", "result"})
+	Retries = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "retries_total",
+		Help:      "Retried attempts of resilient service operations.",
+	}, []string{"operation"})
+	BreakerState = factory.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "circuit_breaker",
+		Name:      "state",
+		Help:      "Circuit breaker state: 0 closed, 1 half-open, 2 open.",
+	}, []string{"breaker"})
+)
+
+// HTTP metrics
+var (
+	HTTPRequests = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "requests_total",
+		Help:      "HTTP requests by route and status code.",
+	}, []string{"method", "route", "status"})
+	HTTPDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "request_duration_seconds",
+		Help:      "Latency of HTTP requests by route, excluding WebSocket connections.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route"})
+)
+
+// Result labels an outcome as ok or error
+func Result(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "ok"
+}
+
+// SetBreakerState records the state of a circuit breaker. It has the shape of
+// the second half of gobreaker's OnStateChange callback.
+func SetBreakerState(name string, state gobreaker.State) {
+	BreakerState.WithLabelValues(name).Set(float64(state))
+}
+
+// ObserveCacheTier exports the hit and miss counters of a cache tier along
+// with its hit ratio
+func ObserveCacheTier(tier string, stats func() (hits, misses uint64)) {
+	labels := prometheus.Labels{"tier": tier}
+	factory.NewCounterFunc(prometheus.CounterOpts{
+		Namespace:   namespace,
+		Subsystem:   "cache",
+		Name:        "hits_total",
+		Help:        "Cache hits by tier.",
+		ConstLabels: labels,
+	}, func() float64 {
+		hits, _ := stats()
+		return float64(hits)
+	})
+	factory.NewCounterFunc(prometheus.CounterOpts{
+		Namespace:   namespace,
+		Subsystem:   "cache",
+		Name:        "misses_total",
+		Help:        "Cache misses by tier.",
+		ConstLabels: labels,
+	}, func() float64 {
+		_, misses := stats()
+		return float64(misses)
+	})
+	factory.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace:   namespace,
+		Subsystem:   "cache",
+		Name:        "hit_ratio",
+		Help:        "Share of cache lookups answered by the tier since startup.",
+		ConstLabels: labels,
+	}, func() float64 {
+		hits, misses := stats()
+		if hits+misses == 0 {
+			return 0
+		}
+		return float64(hits) / float64(hits+misses)
+	})
+}
+
+// Handler serves the registry in the Prometheus exposition format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry})
+}
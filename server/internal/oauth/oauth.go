@@ -5,20 +5,18 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
-	"os"
+
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
 )
 
 var googleOauthConfig *oauth2.Config
 
-func InitGoogleOAuth() {
-	redirectURL := os.Getenv("GOOGLE_OAUTH_REDIRECT_URL")
-	fmt.Println("DEBUG: GOOGLE_OAUTH_REDIRECT_URL =", redirectURL) // Debug print made using ai to test the google outh.
+func InitGoogleOAuth(clientID, clientSecret, redirectURL string) {
 	googleOauthConfig = &oauth2.Config{
 		RedirectURL:  redirectURL,
-		ClientID:     os.Getenv("GOOGLE_OAUTH_CLIENT_ID"),
-		ClientSecret: os.Getenv("GOOGLE_OAUTH_CLIENT_SECRET"),
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
 		Scopes:       []string{"https://www.googleapis.com/auth/userinfo.email", "https://www.googleapis.com/auth/userinfo.profile"},
 		Endpoint:     google.Endpoint,
 	}
@@ -0,0 +1,48 @@
+package user
+
+import (
+	"context"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// MemoryRepository implements Repository in process memory with the same
+// semantics as the Postgres repository, for tests and runs without a database
+type MemoryRepository struct {
+	mu    sync.RWMutex
+	users map[string]*User // keyed by email
+}
+
+// NewMemoryRepository creates an empty in-memory repository
+func NewMemoryRepository() *MemoryRepository {
+	return &MemoryRepository{users: make(map[string]*User)}
+}
+
+// CreateUser stores a user under a new ID or returns ErrEmailTaken
+func (r *MemoryRepository) CreateUser(ctx context.Context, user *User) (*User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.users[user.Email]; exists {
+		return &User{}, ErrEmailTaken
+	}
+
+	user.ID = uuid.New().String()
+	stored := *user
+	r.users[user.Email] = &stored
+	return user, nil
+}
+
+// GetUserByEmail returns a user or ErrUserNotFound
+func (r *MemoryRepository) GetUserByEmail(ctx context.Context, email string) (*User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	stored, ok := r.users[email]
+	if !ok {
+		return &User{}, ErrUserNotFound
+	}
+	user := *stored
+	return &user, nil
+}
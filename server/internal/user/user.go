@@ -2,8 +2,10 @@ package user
 
 import "context"
 
+// User is an account in the users table shared with the auth package, where
+// Username is stored as name and Password as password_hash
 type User struct {
-	ID       int64  `json:"id"`
+	ID       string `json:"id"`
 	Username string `json:"username"`
 	Email    string `json:"email"`
 	Password string `json:"password"`
@@ -2,6 +2,7 @@ package user
 
 import (
 	"net/http"
+	"server/internal/apperr"
 
 	"github.com/gin-gonic/gin"
 )
@@ -19,13 +20,13 @@ func NewHandler(s Service) *Handler {
 func (h *Handler) CreateUser(c *gin.Context) {
 	var u CreateUserReq
 	if err := c.ShouldBindJSON(&u); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		c.Error(apperr.Validation("invalid_request", err.Error()))
 		return
 	}
 
 	res, err := h.Service.CreateUser(c.Request.Context(), &u)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.Error(apperr.Internal(err, "signup_failed", "failed to create user"))
 		return
 	}
 
@@ -35,13 +36,13 @@ func (h *Handler) CreateUser(c *gin.Context) {
 func (h *Handler) Login(c *gin.Context) {
 	var user LoginUserReq
 	if err := c.ShouldBindJSON(&user); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		c.Error(apperr.Validation("invalid_request", err.Error()))
 		return
 	}
 
 	u, err := h.Service.Login(c.Request.Context(), &user)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.Error(apperr.Internal(err, "login_failed", "failed to log in"))
 		return
 	}
 
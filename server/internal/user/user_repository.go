@@ -3,6 +3,17 @@ package user
 import (
 	"context"
 	"database/sql"
+	"errors"
+
+	"server/internal/apperr"
+
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
+)
+
+var (
+	ErrUserNotFound = apperr.NotFound("user_not_found", "user not found")
+	ErrEmailTaken   = apperr.Conflict("email_taken", "a user with this email already exists")
 )
 
 type DBTX interface {
@@ -16,29 +27,48 @@ type repository struct {
 	db DBTX
 }
 
+// NewRepository creates a repository for Postgres or SQLite. Both databases
+// generate user IDs and bind the positional $n parameters used here.
 func NewRepository(db DBTX) Repository {
 	return &repository{db: db}
 }
 
 func (r *repository) CreateUser(ctx context.Context, user *User) (*User, error) {
-	var lastInsertId int
-	query := "INSERT INTO users(username, password, email) VALUES ($1, $2, $3) returning id"
+	var lastInsertId string
+	query := "INSERT INTO users(name, password_hash, email) VALUES ($1, $2, $3) returning id"
 	err := r.db.QueryRowContext(ctx, query, user.Username, user.Password, user.Email).Scan(&lastInsertId)
+	if isUniqueViolation(err) {
+		return &User{}, ErrEmailTaken.Wrap(err)
+	}
 	if err != nil {
 		return &User{}, err
 	}
 
-	user.ID = int64(lastInsertId)
+	user.ID = lastInsertId
 	return user, nil
 }
 
 func (r *repository) GetUserByEmail(ctx context.Context, email string) (*User, error) {
 	u := User{}
-	query := "SELECT id, email, username, password FROM users WHERE email = $1"
+	query := "SELECT id, email, name, password_hash FROM users WHERE email = $1"
 	err := r.db.QueryRowContext(ctx, query, email).Scan(&u.ID, &u.Email, &u.Username, &u.Password)
+	if errors.Is(err, sql.ErrNoRows) {
+		return &User{}, ErrUserNotFound
+	}
 	if err != nil {
-		return &User{}, nil
+		return &User{}, err
 	}
 
 	return &u, nil
 }
+
+// isUniqueViolation reports whether err is a Postgres or SQLite unique
+// constraint violation
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code == "23505"
+	}
+	var sqliteErr sqlite3.Error
+	return errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique
+}
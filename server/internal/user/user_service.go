@@ -2,26 +2,28 @@ package user
 
 import (
 	"context"
+	"errors"
+	"server/internal/apperr"
 	"server/util"
-	"strconv"
 	"time"
 
 	"github.com/golang-jwt/jwt/v4"
 )
 
-const (
-	secretKey = "secret"
-)
+var ErrInvalidCredentials = apperr.Unauthorized("invalid_credentials", "invalid email or password")
 
 type service struct {
 	Repository
-	timeout time.Duration
+	timeout   time.Duration
+	secretKey []byte
 }
 
-func NewService(repository Repository) Service {
+// NewService creates a user service that signs access tokens with secretKey
+func NewService(repository Repository, secretKey string) Service {
 	return &service{
 		repository,
 		time.Duration(2) * time.Second,
+		[]byte(secretKey),
 	}
 }
 
@@ -46,7 +48,7 @@ func (s *service) CreateUser(c context.Context, req *CreateUserReq) (*CreateUser
 	}
 
 	res := &CreateUserRes{
-		ID:       strconv.Itoa(int(r.ID)),
+		ID:       r.ID,
 		Username: r.Username,
 		Email:    r.Email,
 	}
@@ -65,28 +67,31 @@ func (s *service) Login(c context.Context, req *LoginUserReq) (*LoginUserRes, er
 	defer cancel()
 
 	u, err := s.Repository.GetUserByEmail(ctx, req.Email)
+	if errors.Is(err, ErrUserNotFound) {
+		return &LoginUserRes{}, ErrInvalidCredentials
+	}
 	if err != nil {
 		return &LoginUserRes{}, err
 	}
 
 	err = util.CheckPassword(req.Password, u.Password)
 	if err != nil {
-		return &LoginUserRes{}, err
+		return &LoginUserRes{}, ErrInvalidCredentials
 	}
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, MyJWTClaims{
-		ID:       strconv.Itoa(int(u.ID)),
+		ID:       u.ID,
 		Username: u.Username,
 		RegisteredClaims: jwt.RegisteredClaims{
-			Issuer:    strconv.Itoa(int(u.ID)),
+			Issuer:    u.ID,
 			ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)),
 		},
 	})
 
-	ss, err := token.SignedString([]byte(secretKey))
+	ss, err := token.SignedString(s.secretKey)
 	if err != nil {
 		return &LoginUserRes{}, err
 	}
 
-	return &LoginUserRes{accessToken: ss, Username: u.Username, ID: strconv.Itoa(int(u.ID))}, nil
+	return &LoginUserRes{accessToken: ss, Username: u.Username, ID: u.ID}, nil
 }
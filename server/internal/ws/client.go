@@ -3,12 +3,21 @@ package ws
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"log"
+	"sync/atomic"
 	"time"
 
+	"server/db"
+
 	"github.com/gorilla/websocket"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
+var tracer = otel.Tracer("server/internal/ws")
+
 type MessageType string
 
 const (
@@ -21,28 +30,52 @@ const (
 	MessageTypeTyping  MessageType = "typing"  // Client represents a connected websocket client
 )
 
+// ErrorCodeOverloaded marks the error message sent when a message was
+// rejected because persistence is overloaded
+const ErrorCodeOverloaded = "overloaded"
+
 type Client struct {
 	Conn          *websocket.Conn
 	Message       chan *Message
 	ID            string    `json:"id"`
 	RoomID        string    `json:"roomId"`
 	Username      string    `json:"username"`
+	UserID        string    `json:"userId,omitempty"` // Signed-in user, empty for anonymous clients
 	IsActive      bool      `json:"isActive"`   // Whether client is currently active
 	IsTyping      bool      `json:"isTyping"`   // Whether client is currently typing
-	LastActive    time.Time `json:"lastActive"` // Last activity timestamp
 	JoinedAt      time.Time `json:"joinedAt"`   // When client joined
+	IsSpectator   bool      `json:"isSpectator"` // Read-only client admitted to a full room
 	messageService MessageService // Service for persisting messages
+	admitted       chan Admission // Receives the hub's admission decision
+	// lastActive is the unix time in nanoseconds of the last activity. The
+	// reader, the writer and the hub all record activity, so it is atomic.
+	lastActive atomic.Int64
+}
+
+// LastActive returns when the client was last active
+func (c *Client) LastActive() time.Time {
+	return time.Unix(0, c.lastActive.Load())
+}
+
+// touch records activity of the client
+func (c *Client) touch() {
+	c.lastActive.Store(time.Now().UnixNano())
 }
 
 // Message represents a message sent between clients
 type Message struct {
-	ID        string      `json:"id,omitempty"`        // Unique message ID
-	Type      MessageType `json:"type"`                // Message type
-	Content   string      `json:"content"`             // Message content
-	RoomID    string      `json:"roomId"`              // Room ID
-	Username  string      `json:"username"`            // Sender username
-	Timestamp time.Time   `json:"timestamp"`           // Message timestamp
-	Recipient string      `json:"recipient,omitempty"` // For private messages
+	ID          string      `json:"id,omitempty"`          // Unique message ID
+	Type        MessageType `json:"type"`                  // Message type
+	Content     string      `json:"content"`               // Message content
+	RoomID      string      `json:"roomId"`                // Room ID
+	Username    string      `json:"username"`              // Sender username
+	UserID      string      `json:"userId,omitempty"`      // Signed-in sender, set by the server
+	Timestamp   time.Time   `json:"timestamp"`             // Message timestamp
+	Recipient   string      `json:"recipient,omitempty"`   // For private messages
+	RecipientID string      `json:"recipientId,omitempty"` // User ID a private message is addressed to
+	Code        string      `json:"code,omitempty"`        // Machine-readable reason of error messages
+
+	spanContext trace.SpanContext // Span the message was received in
 }
 
 // writeMessage handles sending messages to the client
@@ -55,7 +88,7 @@ func (c *Client) writeMessage() {
 	// Set ping handler
 	c.Conn.SetPingHandler(func(string) error {
 
-		c.LastActive = time.Now()
+		c.touch()
 		return c.Conn.WriteControl(websocket.PongMessage, []byte{}, time.Now().Add(10*time.Second))
 	})
 
@@ -70,7 +103,7 @@ func (c *Client) writeMessage() {
 				return
 			}
 
-			c.LastActive = time.Now()
+			c.touch()
 
 			err := c.Conn.WriteJSON(message)
 			if err != nil {
@@ -100,7 +133,7 @@ func (c *Client) readMessage(hub *Hub) {
 	})
 
 	for {
-		c.LastActive = time.Now()
+		c.touch()
 
 		_, rawMessage, err := c.Conn.ReadMessage()
 		if err != nil {
@@ -110,65 +143,141 @@ func (c *Client) readMessage(hub *Hub) {
 			break
 		}
 
-		var parsedMsg Message
-		if err := json.Unmarshal(rawMessage, &parsedMsg); err == nil {
-			if parsedMsg.Type == "" {
-				parsedMsg.Type = MessageTypeChat
-			}
-			if parsedMsg.RoomID == "" {
-				parsedMsg.RoomID = c.RoomID
-			}
-			if parsedMsg.Username == "" {
-				parsedMsg.Username = c.Username
-			}
-			if parsedMsg.Timestamp.IsZero() {
-				parsedMsg.Timestamp = time.Now()
+		// Every message starts a trace that persistence and fan-out continue.
+		// It is a write, so anything it reads must come from the primary.
+		ctx, span := tracer.Start(db.WithPrimary(context.Background()), "ws.receive",
+			trace.WithSpanKind(trace.SpanKindConsumer),
+			trace.WithAttributes(
+				attribute.String("chat.room_id", c.RoomID),
+				attribute.String("chat.client_id", c.ID),
+			),
+		)
+		c.handleMessage(ctx, hub, rawMessage)
+		span.End()
+	}
+}
+
+// handleMessage persists a message read from the connection and hands it to
+// the hub
+func (c *Client) handleMessage(ctx context.Context, hub *Hub, rawMessage []byte) {
+	var parsedMsg Message
+	if err := json.Unmarshal(rawMessage, &parsedMsg); err == nil {
+		if parsedMsg.Type == "" {
+			parsedMsg.Type = MessageTypeChat
+		}
+		// Clients post into the room they joined, which is also the only one
+		// their shard of the hub can deliver to
+		parsedMsg.RoomID = c.RoomID
+		if parsedMsg.Username == "" {
+			parsedMsg.Username = c.Username
+		}
+		if parsedMsg.Timestamp.IsZero() {
+			parsedMsg.Timestamp = time.Now()
+		}
+		// Only the session the client joined with says who sent a message
+		parsedMsg.UserID = c.UserID
+		parsedMsg.spanContext = trace.SpanContextFromContext(ctx)
+		trace.SpanFromContext(ctx).SetAttributes(attribute.String("chat.message_type", string(parsedMsg.Type)))
+
+		if c.IsSpectator {
+			if parsedMsg.Type != MessageTypeTyping {
+				c.rejectSpectatorMessage()
 			}
+			return
+		}
 
-			if parsedMsg.Type == MessageTypeTyping {
-				c.IsTyping = parsedMsg.Content == "true"
-				hub.UpdateClientStatus <- c
-				continue
+		if parsedMsg.Type == MessageTypeTyping {
+			// The hub owns the room's clients, so it gets a snapshot of the
+			// status instead of the client the reader keeps using
+			hub.UpdateClientStatus <- &Client{
+				ID:       c.ID,
+				RoomID:   c.RoomID,
+				Username: c.Username,
+				IsTyping: parsedMsg.Content == "true",
 			}
+			return
+		}
 
-			if parsedMsg.Type == MessageTypePrivate && parsedMsg.Recipient != "" {
+		if parsedMsg.Type == MessageTypePrivate && parsedMsg.Recipient != "" {
+			if c.persist(ctx, &parsedMsg) {
 				hub.PrivateMessage <- &parsedMsg
-				
-				// Save private message to database if message service is available
-				if c.messageService != nil {
-					if err := c.messageService.SaveMessage(context.Background(), &parsedMsg); err != nil {
-						log.Printf("Error saving private message to database: %v", err)
-					}
-				}
-				continue
 			}
+			return
+		}
 
-			// Broadcast regular message
+		// Broadcast regular message once it has been handed to persistence
+		if c.persist(ctx, &parsedMsg) {
 			hub.Broadcast <- &parsedMsg
-			
-			// Save message to database if message service is available
-			if c.messageService != nil {
-				if err := c.messageService.SaveMessage(context.Background(), &parsedMsg); err != nil {
-					log.Printf("Error saving message to database: %v", err)
-				}
-			}
-		} else {
-			msg := &Message{
-				Type:      MessageTypeChat,
-				Content:   string(rawMessage),
-				RoomID:    c.RoomID,
-				Username:  c.Username,
-				Timestamp: time.Now(),
-			}
+		}
+	} else {
+		if c.IsSpectator {
+			c.rejectSpectatorMessage()
+			return
+		}
 
+		msg := &Message{
+			Type:        MessageTypeChat,
+			Content:     string(rawMessage),
+			RoomID:      c.RoomID,
+			Username:    c.Username,
+			UserID:      c.UserID,
+			Timestamp:   time.Now(),
+			spanContext: trace.SpanContextFromContext(ctx),
+		}
+
+		if c.persist(ctx, msg) {
 			hub.Broadcast <- msg
-			
-			// Save message to database if message service is available
-			if c.messageService != nil {
-				if err := c.messageService.SaveMessage(context.Background(), msg); err != nil {
-					log.Printf("Error saving message to database: %v", err)
-				}
-			}
 		}
 	}
 }
+
+// rejectSpectatorMessage tells a spectator that it cannot send messages
+func (c *Client) rejectSpectatorMessage() {
+	c.Message <- &Message{
+		Type:      MessageTypeError,
+		Content:   "room is full, spectators cannot send messages",
+		RoomID:    c.RoomID,
+		Username:  c.Username,
+		Timestamp: time.Now(),
+	}
+}
+
+// persist hands a message to the message service before it is fanned out.
+// It returns false when persistence pushed back, in which case the sender is
+// told the message was not delivered.
+func (c *Client) persist(ctx context.Context, msg *Message) bool {
+	if c.messageService == nil {
+		return true
+	}
+
+	err := c.messageService.SaveMessage(ctx, msg)
+	if err == nil {
+		return true
+	}
+
+	if errors.Is(err, ErrBackpressure) {
+		c.Message <- &Message{
+			Type:      MessageTypeError,
+			Content:   "server is busy, message was not sent",
+			RoomID:    msg.RoomID,
+			Username:  msg.Username,
+			Timestamp: time.Now(),
+		}
+		return false
+	}
+
+	if errors.Is(err, ErrOverloaded) {
+		c.Message <- &Message{
+			Type:      MessageTypeError,
+			Code:      ErrorCodeOverloaded,
+			Content:   "server is overloaded, message was not sent",
+			RoomID:    msg.RoomID,
+			Username:  msg.Username,
+			Timestamp: time.Now(),
+		}
+		return false
+	}
+
+	log.Printf("Error saving message to database: %v", err)
+	return true
+}
@@ -1,14 +1,60 @@
 package ws
 
-import "time"
+import (
+	"context"
+	"log"
+	"time"
+
+	"server/internal/metrics"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
 
 type Room struct {
-	ID           string             `json:"id"`
-	Name         string             `json:"name"`
-	Clients      map[string]*Client `json:"clients"`
-	OwnerID      string             `json:"owner_id,omitempty"`
-	Created      time.Time          `json:"created,omitempty"`
-	LastActivity time.Time          `json:"last_activity,omitempty"`
+	ID              string             `json:"id"`
+	Name            string             `json:"name"`
+	Clients         map[string]*Client `json:"clients"`
+	OwnerID         string             `json:"owner_id,omitempty"`
+	Created         time.Time          `json:"created,omitempty"`
+	LastActivity    time.Time          `json:"last_activity,omitempty"`
+	MaxMembers      int                `json:"max_members"`      // 0 means unlimited
+	AllowSpectators bool               `json:"allow_spectators"` // Admit read-only spectators once full
+}
+
+// Admission is the hub's decision about a client that asked to join a room
+type Admission int
+
+const (
+	AdmitMember    Admission = iota // Client joined as a regular member
+	AdmitSpectator                  // Room is full, client joined read-only
+	RejectFull                      // Room is full and does not allow spectators
+)
+
+// Counts returns the number of members and spectators in the room
+func (r *Room) Counts() (members, spectators int) {
+	for _, cl := range r.Clients {
+		if cl.IsSpectator {
+			spectators++
+		} else {
+			members++
+		}
+	}
+	return members, spectators
+}
+
+// admit decides how a new client may join the room
+func (r *Room) admit() Admission {
+	if r.MaxMembers <= 0 {
+		return AdmitMember
+	}
+	if members, _ := r.Counts(); members < r.MaxMembers {
+		return AdmitMember
+	}
+	if r.AllowSpectators {
+		return AdmitSpectator
+	}
+	return RejectFull
 }
 
 type Hub struct {
@@ -18,6 +64,10 @@ type Hub struct {
 	Broadcast         chan *Message
 	UpdateClientStatus chan *Client       // Channel for client status updates (typing, etc.)
 	PrivateMessage     chan *Message      // Channel for private messages between users
+	Backpressure       chan bool          // Channel for persistence saturation changes
+
+	ping                 chan chan struct{}
+	persistenceSaturated bool
 }
 
 func NewHub() *Hub {
@@ -28,24 +78,91 @@ func NewHub() *Hub {
 		Broadcast:          make(chan *Message, 5),
 		UpdateClientStatus: make(chan *Client, 5),
 		PrivateMessage:     make(chan *Message, 5),
+		Backpressure:       make(chan bool, 16),
+		ping:               make(chan chan struct{}),
+	}
+}
+
+// SignalBackpressure reports a persistence saturation change to the hub. It
+// never blocks, so it can be registered as a message.BackpressureFunc.
+func (h *Hub) SignalBackpressure(saturated bool) {
+	select {
+	case h.Backpressure <- saturated:
+	default:
+		log.Printf("Hub backpressure channel full, dropping signal (saturated=%v)", saturated)
+	}
+}
+
+// Ping waits for the hub loop to pick up an event, so a loop that is stuck
+// delivering to a blocked client fails to answer before ctx is done
+func (h *Hub) Ping(ctx context.Context) error {
+	done := make(chan struct{})
+	select {
+	case h.ping <- done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// startFanout starts the span of handing m to the clients of its room, as a
+// child of the span the message was received in. Notices the hub generates
+// itself are not traced.
+func (m *Message) startFanout(name string) trace.Span {
+	if !m.spanContext.IsValid() {
+		return trace.SpanFromContext(context.Background())
 	}
+	ctx := trace.ContextWithSpanContext(context.Background(), m.spanContext)
+	_, span := tracer.Start(ctx, name, trace.WithAttributes(attribute.String("chat.room_id", m.RoomID)))
+	return span
+}
+
+// addressedTo reports whether cl is the recipient of private message m.
+// Messages addressed to a user ID only reach that signed-in user; the
+// username is only used for messages without one.
+func (m *Message) addressedTo(cl *Client) bool {
+	if m.RecipientID != "" {
+		return cl.UserID == m.RecipientID
+	}
+	return cl.Username == m.Recipient
+}
+
+// endFanout ends a fanout span with the number of clients m was handed to
+func endFanout(span trace.Span, recipients int) {
+	span.SetAttributes(attribute.Int("chat.recipients", recipients))
+	span.End()
 }
 
 func (h *Hub) Run() {
 	for {
 		select {
 		case cl := <-h.Register: //join
+			admission := AdmitMember
 			if _, ok := h.Rooms[cl.RoomID]; ok {
 				r := h.Rooms[cl.RoomID]
 
 				if _, ok := r.Clients[cl.ID]; !ok {
-					r.Clients[cl.ID] = cl
+					admission = r.admit()
+					if admission != RejectFull {
+						cl.IsSpectator = admission == AdmitSpectator
+						r.Clients[cl.ID] = cl
+						metrics.HubClients.Inc()
+					}
 				}
 			}
+			if cl.admitted != nil {
+				cl.admitted <- admission
+			}
 		case cl := <-h.Unregister:
 			if _, ok := h.Rooms[cl.RoomID]; ok {
 				if _, ok := h.Rooms[cl.RoomID].Clients[cl.ID]; ok {
-					if len(h.Rooms[cl.RoomID].Clients) != 0 {
+					if len(h.Rooms[cl.RoomID].Clients) != 0 && !cl.IsSpectator {
 						h.Broadcast <- &Message{
 							Content:  "user left the chat",
 							RoomID:   cl.RoomID,
@@ -55,6 +172,7 @@ func (h *Hub) Run() {
 
 					delete(h.Rooms[cl.RoomID].Clients, cl.ID)
 					close(cl.Message)
+					metrics.HubClients.Dec()
 				}
 			}
 
@@ -63,9 +181,13 @@ func (h *Hub) Run() {
 				// Update room's last activity timestamp
 				h.Rooms[m.RoomID].LastActivity = time.Now()
 
+				span := m.startFanout("hub.broadcast")
+				start := time.Now()
 				for _, cl := range h.Rooms[m.RoomID].Clients {
 					cl.Message <- m
 				}
+				metrics.BroadcastFanout.Observe(time.Since(start).Seconds())
+				endFanout(span, len(h.Rooms[m.RoomID].Clients))
 			}
 			
 		case cl := <-h.UpdateClientStatus:
@@ -73,7 +195,7 @@ func (h *Hub) Run() {
 				// Update the client in the room
 				if existingClient, ok := h.Rooms[cl.RoomID].Clients[cl.ID]; ok {
 					existingClient.IsTyping = cl.IsTyping
-					existingClient.LastActive = time.Now()
+					existingClient.touch()
 					
 					statusMsg := &Message{
 						Type:      MessageTypeTyping,
@@ -93,20 +215,59 @@ func (h *Hub) Run() {
 			
 		case m := <-h.PrivateMessage:
 			if _, ok := h.Rooms[m.RoomID]; ok {
+				span := m.startFanout("hub.private")
+				delivered := 0
 				// Find the recipient client
 				for _, cl := range h.Rooms[m.RoomID].Clients {
-					if cl.Username == m.Recipient {
+					if m.addressedTo(cl) {
 						cl.Message <- m
+						delivered++
 						
 						for _, sender := range h.Rooms[m.RoomID].Clients {
 							if sender.Username == m.Username {
 								sender.Message <- m
+								delivered++
 								break
 							}
 						}
 						break
 					}
 				}
+				endFanout(span, delivered)
+			}
+
+		case done := <-h.ping:
+			close(done)
+
+		case saturated := <-h.Backpressure:
+			if saturated == h.persistenceSaturated {
+				continue
+			}
+			h.persistenceSaturated = saturated
+
+			content := "Message history has caught up"
+			if saturated {
+				log.Println("Message persistence is saturated, new messages may be rejected")
+				content = "The server is busy saving messages; some messages may be rejected"
+			} else {
+				log.Println("Message persistence recovered from saturation")
+			}
+
+			for _, r := range h.Rooms {
+				notice := &Message{
+					Type:      MessageTypeSystem,
+					Content:   content,
+					RoomID:    r.ID,
+					Timestamp: time.Now(),
+				}
+				// Notices are advisory, so a client whose buffer is full
+				// misses this one rather than stalling the hub loop
+				for _, cl := range r.Clients {
+					select {
+					case cl.Message <- notice:
+					default:
+					}
+				}
 			}
 		}
 	}
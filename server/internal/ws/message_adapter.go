@@ -2,11 +2,23 @@ package ws
 
 import (
 	"context"
+	"errors"
 	"server/internal/message"
+
+	"go.opentelemetry.io/otel/trace"
 )
 
+// ErrBackpressure is returned by SaveMessage when persistence cannot keep up
+// and the message was rejected instead of queued or saved
+var ErrBackpressure = errors.New("message persistence is saturated")
+
+// ErrOverloaded is returned by SaveMessage when a bulkhead of the message
+// service turned the write away
+var ErrOverloaded = errors.New("message persistence is overloaded")
+
 type MessageServiceAdapter struct {
 	messageService message.Service
+	writer         *message.WriteBehind
 }
 
 func NewMessageServiceAdapter(messageService message.Service) MessageService {
@@ -15,6 +27,15 @@ func NewMessageServiceAdapter(messageService message.Service) MessageService {
 	}
 }
 
+// NewWriteBehindAdapter creates an adapter that persists chat messages through
+// the asynchronous write-behind queue instead of calling the service inline
+func NewWriteBehindAdapter(messageService message.Service, writer *message.WriteBehind) MessageService {
+	return &MessageServiceAdapter{
+		messageService: messageService,
+		writer:         writer,
+	}
+}
+
 func (a *MessageServiceAdapter) SaveMessage(ctx context.Context, msg interface{}) error {
 	wsMsg, ok := msg.(*Message)
 	if !ok {
@@ -22,21 +43,44 @@ func (a *MessageServiceAdapter) SaveMessage(ctx context.Context, msg interface{}
 	}
 
 	dbMsg := &message.Message{
-		ID:        wsMsg.ID,
-		RoomID:    wsMsg.RoomID,
-		UserID:    "", 
-		Username:  wsMsg.Username,
-		Content:   wsMsg.Content,
-		Type:      string(wsMsg.Type),
-		Timestamp: wsMsg.Timestamp,
-		Recipient: wsMsg.Recipient,
+		ID:          wsMsg.ID,
+		RoomID:      wsMsg.RoomID,
+		UserID:      wsMsg.UserID,
+		Username:    wsMsg.Username,
+		Content:     wsMsg.Content,
+		Type:        string(wsMsg.Type),
+		Timestamp:   wsMsg.Timestamp,
+		Recipient:   wsMsg.Recipient,
+		RecipientID: wsMsg.RecipientID,
+		Trace:       trace.SpanContextFromContext(ctx),
+	}
+
+	if a.writer == nil {
+		err := a.messageService.SaveMessage(ctx, dbMsg)
+		if errors.Is(err, message.ErrOverloaded) {
+			return ErrOverloaded
+		}
+		return err
 	}
-	
-	return a.messageService.SaveMessage(ctx, dbMsg)
+
+	if err := a.writer.Enqueue(dbMsg); err != nil {
+		switch {
+		case errors.Is(err, message.ErrWriteQueueFull):
+			return ErrBackpressure
+		case errors.Is(err, message.ErrOverloaded):
+			return ErrOverloaded
+		}
+		return err
+	}
+
+	// Expose the identifiers assigned by the queue to the fan-out
+	wsMsg.ID = dbMsg.ID
+	wsMsg.Timestamp = dbMsg.Timestamp
+	return nil
 }
 
-func (a *MessageServiceAdapter) CreateRoom(ctx context.Context, id, name, ownerID string) (interface{}, error) {
-	return a.messageService.CreateRoom(ctx, id, name, ownerID)
+func (a *MessageServiceAdapter) CreateRoom(ctx context.Context, id, name, ownerID string, capacity message.RoomCapacity) (interface{}, error) {
+	return a.messageService.CreateRoom(ctx, id, name, ownerID, capacity)
 }
 
 func (a *MessageServiceAdapter) UpdateRoomActivity(ctx context.Context, roomID string) error {
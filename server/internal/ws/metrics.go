@@ -0,0 +1,42 @@
+package ws
+
+import (
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var queueDepthDesc = prometheus.NewDesc(
+	"chat_hub_queue_depth",
+	"Events waiting in a hub shard channel.",
+	[]string{"shard", "queue"}, nil,
+)
+
+// queueCollector reports the depth of the event channels of every shard.
+// Channel lengths are safe to read outside the hub loop.
+type queueCollector struct {
+	router Router
+}
+
+// NewQueueCollector exports the channel depths of a hub's shards
+func NewQueueCollector(router Router) prometheus.Collector {
+	return &queueCollector{router: router}
+}
+
+func (c *queueCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- queueDepthDesc
+}
+
+func (c *queueCollector) Collect(ch chan<- prometheus.Metric) {
+	for i, shard := range c.router.Shards() {
+		id := strconv.Itoa(i)
+		for queue, depth := range map[string]int{
+			"broadcast":     len(shard.Broadcast),
+			"private":       len(shard.PrivateMessage),
+			"client_status": len(shard.UpdateClientStatus),
+			"backpressure":  len(shard.Backpressure),
+		} {
+			ch <- prometheus.MustNewConstMetric(queueDepthDesc, prometheus.GaugeValue, float64(depth), id, queue)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package ws
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+)
+
+// Router routes hub events to the event loop that owns a room. A plain Hub is
+// a router with a single shard.
+type Router interface {
+	ShardFor(roomID string) *Hub
+	Shards() []*Hub
+	SignalBackpressure(saturated bool)
+	Ping(ctx context.Context) error
+}
+
+// ShardFor returns the hub itself; a single Hub owns every room
+func (h *Hub) ShardFor(roomID string) *Hub {
+	return h
+}
+
+// Shards returns the hub as its only shard
+func (h *Hub) Shards() []*Hub {
+	return []*Hub{h}
+}
+
+// ShardedHub partitions rooms across several independent Hub event loops by a
+// hash of the room ID. Every event concerns a single room, so each shard keeps
+// the same ordering guarantees as a single Hub for the rooms it owns.
+type ShardedHub struct {
+	shards []*Hub
+}
+
+// NewShardedHub creates a hub with n shard loops, each with its own channels
+func NewShardedHub(n int) *ShardedHub {
+	if n < 1 {
+		n = 1
+	}
+
+	shards := make([]*Hub, n)
+	for i := range shards {
+		shards[i] = NewHub()
+	}
+
+	return &ShardedHub{shards: shards}
+}
+
+// ShardFor returns the shard that owns the given room
+func (s *ShardedHub) ShardFor(roomID string) *Hub {
+	if len(s.shards) == 1 {
+		return s.shards[0]
+	}
+
+	hash := fnv.New32a()
+	hash.Write([]byte(roomID))
+	return s.shards[hash.Sum32()%uint32(len(s.shards))]
+}
+
+// Shards returns every shard loop
+func (s *ShardedHub) Shards() []*Hub {
+	return s.shards
+}
+
+// SignalBackpressure forwards a persistence saturation change to every shard
+func (s *ShardedHub) SignalBackpressure(saturated bool) {
+	for _, shard := range s.shards {
+		shard.SignalBackpressure(saturated)
+	}
+}
+
+// Ping checks that every shard loop is responsive
+func (s *ShardedHub) Ping(ctx context.Context) error {
+	for i, shard := range s.shards {
+		if err := shard.Ping(ctx); err != nil {
+			return fmt.Errorf("hub shard %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// Run starts every shard loop and blocks for as long as they run
+func (s *ShardedHub) Run() {
+	for _, shard := range s.shards[1:] {
+		go shard.Run()
+	}
+	s.shards[0].Run()
+}
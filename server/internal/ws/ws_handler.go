@@ -4,6 +4,11 @@ import (
 	"context"
 	"log"
 	"net/http"
+	"server/db"
+	"server/internal/apperr"
+	"server/internal/auth"
+	"server/internal/message"
+	"server/internal/metrics"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -12,45 +17,63 @@ import (
 
 type MessageService interface {
 	SaveMessage(ctx context.Context, message interface{}) error
-	CreateRoom(ctx context.Context, id, name, ownerID string) (interface{}, error)
+	CreateRoom(ctx context.Context, id, name, ownerID string, capacity message.RoomCapacity) (interface{}, error)
 	UpdateRoomActivity(ctx context.Context, roomID string) error
 }
 
 type Handler struct {
-	hub            *Hub
+	hub            Router
 	messageService MessageService
 }
 
-func NewHandler(h *Hub, messageService MessageService) *Handler {
+func NewHandler(h Router, messageService MessageService) *Handler {
 	return &Handler{
 		hub:            h,
 		messageService: messageService,
 	}
 }
 
+// CloseRoomFull is the close code sent to clients rejected by a full room
+const CloseRoomFull = 4001
+
 type CreateRoomReq struct {
-	ID   string `json:"id"`
-	Name string `json:"name"`
+	ID              string `json:"id"`
+	Name            string `json:"name"`
+	MaxMembers      int    `json:"maxMembers" binding:"min=0"`
+	AllowSpectators bool   `json:"allowSpectators"`
 }
 
 func (h *Handler) CreateRoom(c *gin.Context) {
 	var req CreateRoomReq
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		c.Error(apperr.Validation("invalid_request", err.Error()))
 		return
 	}
 
-	h.hub.Rooms[req.ID] = &Room{
-		ID:           req.ID,
-		Name:         req.Name,
-		Clients:      make(map[string]*Client),
-		OwnerID:      "system",
-		Created:      time.Now(),
-		LastActivity: time.Now(),
+	// Replacing a room would drop its clients without unregistering them
+	shard := h.hub.ShardFor(req.ID)
+	if _, exists := shard.Rooms[req.ID]; exists {
+		c.Error(message.ErrRoomExists)
+		return
+	}
+	metrics.HubRooms.Inc()
+	shard.Rooms[req.ID] = &Room{
+		ID:              req.ID,
+		Name:            req.Name,
+		Clients:         make(map[string]*Client),
+		OwnerID:         "system",
+		Created:         time.Now(),
+		LastActivity:    time.Now(),
+		MaxMembers:      req.MaxMembers,
+		AllowSpectators: req.AllowSpectators,
 	}
 
 	if h.messageService != nil {
-		_, err := h.messageService.CreateRoom(c.Request.Context(), req.ID, req.Name, "system")
+		capacity := message.RoomCapacity{
+			MaxMembers:      req.MaxMembers,
+			AllowSpectators: req.AllowSpectators,
+		}
+		_, err := h.messageService.CreateRoom(c.Request.Context(), req.ID, req.Name, "system", capacity)
 		if err != nil {
 			log.Printf("Error saving room to database: %v", err)
 		}
@@ -69,9 +92,13 @@ var upgrader = websocket.Upgrader{
 
 func (h *Handler) JoinRoom(c *gin.Context) {
 
-	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	// Joining writes the join message and the client's messages follow over
+	// this GET, so its reads are pinned to the primary. The upgrade response
+	// is the only one that can carry the pin cookie.
+	ctx := db.PinPrimary(c.Request.Context())
+	conn, err := upgrader.Upgrade(c.Writer, c.Request, http.Header{"Set-Cookie": c.Writer.Header().Values("Set-Cookie")})
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		c.Error(apperr.Validation("websocket_upgrade_failed", err.Error()))
 		return
 	}
 
@@ -79,77 +106,127 @@ func (h *Handler) JoinRoom(c *gin.Context) {
 	clientID := c.Query("userId")
 	username := c.Query("username")
 
+	// Signed-in clients post as their user, which gives them access to the
+	// room's history in search
+	userID := ""
+	if user := auth.CurrentUser(c); user != nil {
+		userID = user.ID
+	}
+
 	cl := &Client{
 		Conn:     conn,
 		Message:  make(chan *Message, 10),
 		ID:       clientID,
 		RoomID:   roomID,
 		Username: username,
+		UserID:   userID,
+		admitted: make(chan Admission, 1),
 	}
 
 	m := &Message{
 		Content:   "A new user has joined the room",
 		RoomID:    roomID,
 		Username:  username,
+		UserID:    userID,
 		Type:      MessageTypeJoin,
 		Timestamp: time.Now(),
 	}
 
-	h.hub.Register <- cl
-	h.hub.Broadcast <- m
+	shard := h.hub.ShardFor(roomID)
+	shard.Register <- cl
 
-	if h.messageService != nil {
-		if err := h.messageService.UpdateRoomActivity(c.Request.Context(), roomID); err != nil {
-			log.Printf("Error updating room activity: %v", err)
+	switch <-cl.admitted {
+	case RejectFull:
+		log.Printf("Rejected client %s: room %s is full", clientID, roomID)
+		closeMsg := websocket.FormatCloseMessage(CloseRoomFull, "room is full")
+		conn.WriteControl(websocket.CloseMessage, closeMsg, time.Now().Add(time.Second))
+		conn.Close()
+		return
+
+	case AdmitSpectator:
+		cl.Message <- &Message{
+			Content:   "Room is full, you joined as a spectator and cannot send messages",
+			RoomID:    roomID,
+			Username:  username,
+			Type:      MessageTypeSystem,
+			Timestamp: time.Now(),
 		}
-		
-		if err := h.messageService.SaveMessage(c.Request.Context(), m); err != nil {
-			log.Printf("Error saving join message: %v", err)
+
+	default:
+		shard.Broadcast <- m
+
+		if h.messageService != nil {
+			if err := h.messageService.UpdateRoomActivity(ctx, roomID); err != nil {
+				log.Printf("Error updating room activity: %v", err)
+			}
+
+			if err := h.messageService.SaveMessage(ctx, m); err != nil {
+				log.Printf("Error saving join message: %v", err)
+			}
 		}
 	}
 
 	cl.messageService = h.messageService
 
 	go cl.writeMessage()
-	cl.readMessage(h.hub)
+	cl.readMessage(shard)
 }
 
 type RoomRes struct {
-	ID   string `json:"id"`
-	Name string `json:"name"`
+	ID              string `json:"id"`
+	Name            string `json:"name"`
+	Members         int    `json:"members"`
+	Spectators      int    `json:"spectators"`
+	MaxMembers      int    `json:"maxMembers"`
+	AllowSpectators bool   `json:"allowSpectators"`
 }
 
 func (h *Handler) GetRooms(c *gin.Context) {
 	rooms := make([]RoomRes, 0)
 
-	for _, r := range h.hub.Rooms {
-		rooms = append(rooms, RoomRes{
-			ID:   r.ID,
-			Name: r.Name,
-		})
+	for _, shard := range h.hub.Shards() {
+		for _, r := range shard.Rooms {
+			members, spectators := r.Counts()
+			rooms = append(rooms, RoomRes{
+				ID:              r.ID,
+				Name:            r.Name,
+				Members:         members,
+				Spectators:      spectators,
+				MaxMembers:      r.MaxMembers,
+				AllowSpectators: r.AllowSpectators,
+			})
+		}
 	}
 
 	c.JSON(http.StatusOK, rooms)
 }
 
 type ClientRes struct {
-	ID       string `json:"id"`
-	Username string `json:"username"`
+	ID         string    `json:"id"`
+	Username   string    `json:"username"`
+	UserID     string    `json:"userId,omitempty"`
+	Spectator  bool      `json:"spectator,omitempty"`
+	LastActive time.Time `json:"lastActive"`
 }
 
 func (h *Handler) GetClients(c *gin.Context) {
 	var clients []ClientRes
 	roomId := c.Param("roomId")
+	shard := h.hub.ShardFor(roomId)
 
-	if _, ok := h.hub.Rooms[roomId]; !ok {
+	if _, ok := shard.Rooms[roomId]; !ok {
 		clients = make([]ClientRes, 0)
 		c.JSON(http.StatusOK, clients)
+		return
 	}
 
-	for _, c := range h.hub.Rooms[roomId].Clients {
+	for _, c := range shard.Rooms[roomId].Clients {
 		clients = append(clients, ClientRes{
-			ID:       c.ID,
-			Username: c.Username,
+			ID:         c.ID,
+			Username:   c.Username,
+			UserID:     c.UserID,
+			Spectator:  c.IsSpectator,
+			LastActive: c.LastActive(),
 		})
 	}
 
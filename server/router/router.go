@@ -1,33 +1,62 @@
 package router
 
 import (
+	"crypto/subtle"
+	"net/http"
+	"server/config"
+	"server/db"
+	"server/internal/apperr"
 	"server/internal/auth"
+	"server/internal/health"
 	"server/internal/message"
+	"server/internal/metrics"
 	"server/internal/user"
 	"server/internal/ws"
 	"time"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
 )
 
 var r *gin.Engine
 
-func InitRouter(userHandler *user.Handler, wsHandler *ws.Handler, messageHandler *message.Handler, authHandler *auth.Handler) {
+func InitRouter(cfg *config.Config, userHandler *user.Handler, wsHandler *ws.Handler, messageHandler *message.Handler, authHandler *auth.Handler, adminHandler *message.AdminHandler, healthHandler *health.Handler) {
 	r = gin.Default()
+	// WebSocket connections are traced per message instead of per connection
+	r.Use(otelgin.Middleware(cfg.Tracing.ServiceName, otelgin.WithGinFilter(func(c *gin.Context) bool {
+		return !c.IsWebsocket()
+	})))
+	r.Use(apperr.Middleware())
+	r.Use(metrics.Middleware())
 
 	r.Use(cors.New(cors.Config{
-		AllowOrigins:     []string{"http://localhost:3000", "http://localhost:8081"},
+		AllowOrigins:     cfg.CORS.AllowedOrigins,
 		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
 		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization"},
 		ExposeHeaders:    []string{"Content-Length"},
 		AllowCredentials: true,
 		AllowOriginFunc: func(origin string) bool {
-			return origin == "http://localhost:3000" || origin == "http://localhost:8081"
+			for _, allowed := range cfg.CORS.AllowedOrigins {
+				if origin == allowed {
+					return true
+				}
+			}
+			return false
 		},
 		MaxAge: 12 * time.Hour,
 	}))
 
+	if len(cfg.Database.Replicas) > 0 {
+		r.Use(ReadYourWrites(cfg.Database.ReadYourWritesWindow))
+	}
+
+	// Health routes for load balancers and supervisors
+	r.GET("/healthz", healthHandler.Live)
+	r.GET("/readyz", healthHandler.Ready)
+	r.GET("/status", adminAuth(cfg.Admin.Token), healthHandler.Status)
+	r.GET("/metrics", gin.WrapH(metrics.Handler()))
+
 	// Auth routes
 	authGroup := r.Group("/auth")
 	{
@@ -49,7 +78,7 @@ func InitRouter(userHandler *user.Handler, wsHandler *ws.Handler, messageHandler
 
 	// WebSocket routes
 	r.POST("/ws/createRoom", wsHandler.CreateRoom)
-	r.GET("/ws/joinRoom/:roomId", wsHandler.JoinRoom)
+	r.GET("/ws/joinRoom/:roomId", authHandler.OptionalSession, wsHandler.JoinRoom)
 	r.GET("/ws/getRooms", wsHandler.GetRooms)
 	r.GET("/ws/getClients/:roomId", wsHandler.GetClients)
 
@@ -57,6 +86,8 @@ func InitRouter(userHandler *user.Handler, wsHandler *ws.Handler, messageHandler
 	messageRoutes := r.Group("/api/messages")
 	{
 		messageRoutes.GET("/room/:roomId", messageHandler.GetMessages)
+		messageRoutes.GET("/room/:roomId/search", authHandler.RequireSession, messageHandler.SearchMessages)
+		messageRoutes.GET("/search", authHandler.RequireSession, messageHandler.SearchMessages)
 		messageRoutes.GET("/:messageId", messageHandler.GetMessage)
 	}
 	
@@ -67,6 +98,70 @@ func InitRouter(userHandler *user.Handler, wsHandler *ws.Handler, messageHandler
 		roomRoutes.GET("/", messageHandler.GetRooms)
 		roomRoutes.GET("/:roomId", messageHandler.GetRoom)
 	}
+
+	// Admin routes
+	adminRoutes := r.Group("/admin", adminAuth(cfg.Admin.Token))
+	{
+		adminRoutes.GET("/spool", adminHandler.GetSpool)
+		adminRoutes.GET("/cache", adminHandler.GetCache)
+
+		adminRoutes.GET("/dead-letters", adminHandler.ListDeadLetters)
+		adminRoutes.GET("/dead-letters/:messageId", adminHandler.GetDeadLetter)
+		adminRoutes.PUT("/dead-letters/:messageId", adminHandler.UpdateDeadLetter)
+		adminRoutes.POST("/dead-letters/:messageId/replay", adminHandler.ReplayDeadLetter)
+		adminRoutes.DELETE("/dead-letters/:messageId", adminHandler.DiscardDeadLetter)
+	}
+}
+
+// adminAuth only lets requests through that carry the configured admin token.
+// The admin API is disabled entirely when no token is configured.
+func adminAuth(token string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if token == "" {
+			apperr.Abort(c, apperr.Forbidden("admin_disabled", "admin API is disabled"))
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(c.GetHeader("X-Admin-Token")), []byte(token)) != 1 {
+			apperr.Abort(c, apperr.Unauthorized("invalid_admin_token", "invalid admin token"))
+			return
+		}
+		c.Next()
+	}
+}
+
+// primaryCookie marks a client that wrote recently and must read from the
+// primary until it expires
+const primaryCookie = "read_primary"
+
+// ReadYourWrites lets a client read its own writes while replicas catch up.
+// A write pins the client's reads to the primary for window, which is
+// tracked with a cookie so it holds across server instances. Any request
+// that is not a GET, HEAD or OPTIONS counts as a write; handlers that write
+// on a GET pin explicitly with db.PinPrimary.
+func ReadYourWrites(window time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		if _, err := c.Cookie(primaryCookie); err == nil {
+			ctx = db.WithPrimary(ctx)
+		}
+
+		pinned := false
+		ctx = db.WithPinner(ctx, func() {
+			if !pinned {
+				pinned = true
+				c.SetCookie(primaryCookie, "1", int(window.Seconds()+1), "/", "", false, true)
+			}
+		})
+
+		switch c.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+		default:
+			ctx = db.PinPrimary(ctx)
+		}
+
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+	}
 }
 
 func Start(addr string) error {